@@ -3,13 +3,19 @@ package main
 import (
 	"flag"
 	"fmt"
+	"github.com/tanelmae/private-dns/internal/admission"
+	"github.com/tanelmae/private-dns/internal/gc"
+	"github.com/tanelmae/private-dns/internal/health"
+	"github.com/tanelmae/private-dns/internal/metrics"
+	"github.com/tanelmae/private-dns/internal/pdns"
 	"github.com/tanelmae/private-dns/internal/service"
-	"github.com/tanelmae/private-dns/pkg/gcp"
+	"k8s.io/apimachinery/pkg/util/wait"
 	"k8s.io/client-go/rest"
 	"k8s.io/client-go/tools/clientcmd"
 	"k8s.io/klog/v2"
 	"os"
 	"strings"
+	"time"
 )
 
 // TODO: support passing in kubeconfig and context for local testing
@@ -18,39 +24,128 @@ func main() {
 	// Fix for Kubernetes client trying to log to /tmp
 	klog.SetOutput(os.Stderr)
 
-	project := flag.String("gcp-project", "", "GCP project where the DNS zone is. Defaults to the same as GKE cluster.")
-	zone := flag.String("gcp-zone", "", "GCP DNS zone where to write the records")
-	reverseZone := flag.String("gcp-reverse-zone", "", "GCP DNS zone where to write the reverse lookup records")
-	saFile := flag.String("gcp-cred", "", "Path to GCP service account credentials")
+	provider := flag.String("provider", "gcp", fmt.Sprintf("DNS provider where to write the records: %s",
+		strings.Join(pdns.Providers(), ", ")))
+	pdns.RegisterFlags(flag.CommandLine)
+
 	namespace := flag.String("namespace", "", "Limits private DNS to the given namesapce")
 	kubeconfig := flag.String("kubeconfig", "", "Path to kubeconfig file. Not needed on Kubernetes.")
+	gcInterval := flag.Duration("gc-interval", time.Hour, "How often owned records of removed PrivateDNS resources and pods are garbage collected. 0 disables it.")
+	gcGracePeriod := flag.Duration("gc-grace-period", 10*time.Minute, "How long a record has to stay orphaned before it is garbage collected")
+	gcDryRun := flag.Bool("gc-dry-run", false, "Only report the orphaned records without removing them")
+	dryRun := flag.Bool("dry-run", false, "Only log the record changes without making them in the DNS provider")
+	healthAddr := flag.String("health-addr", ":8081", "Address where /healthz and /readyz probes are served. Empty disables them.")
+	metricsAddr := flag.String("metrics-addr", ":9090", "Address where Prometheus metrics are served. Empty disables it.")
+	webhookAddr := flag.String("webhook-addr", "", "Address where the validating admission webhook is served with TLS. Empty disables it.")
+	webhookCert := flag.String("webhook-cert", "/certs/tls.crt", "TLS certificate of the admission webhook")
+	webhookKey := flag.String("webhook-key", "/certs/tls.key", "TLS key of the admission webhook")
+	workers := flag.Int("workers", 2, "Number of pods handled at the same time for each PrivateDNS resource")
+	podTTL := flag.Int64("pod-ttl", pdns.DefaultTTL, "TTL in seconds of the pod A and AAAA records")
+	serviceTTL := flag.Int64("service-ttl", pdns.DefaultTTL, "TTL in seconds of the service A and AAAA records")
+	srvTTL := flag.Int64("srv-ttl", pdns.DefaultTTL, "TTL in seconds of the SRV records")
+	ptrTTL := flag.Int64("ptr-ttl", pdns.DefaultTTL, "TTL in seconds of the PTR records")
+	reconcileInterval := flag.Duration("reconcile-interval", 5*time.Minute, "How often records are compared with the pods and repaired. 0 disables it.")
+	leaderElect := flag.Bool("leader-elect", false, "Use leader election to run multiple replicas")
+	leaseName := flag.String("leader-elect-lease", "private-dns", "Name of the Lease used for leader election")
+	leaseNamespace := flag.String("leader-elect-namespace", os.Getenv("POD_NAMESPACE"), "Namespace of the Lease. Defaults to POD_NAMESPACE environment variable.")
+	leaseDuration := flag.Duration("leader-elect-lease-duration", 15*time.Second, "Time followers wait before taking over the lease")
+	renewDeadline := flag.Duration("leader-elect-renew-deadline", 10*time.Second, "Time the leader retries renewing the lease before giving it up")
+	retryPeriod := flag.Duration("leader-elect-retry-period", 2*time.Second, "Time between the lease acquire and renew attempts")
 
 	flag.Parse()
 
+	ttl := pdns.TTL{Pod: *podTTL, Service: *serviceTTL, SRV: *srvTTL, PTR: *ptrTTL}
+	if err := ttl.Validate(); err != nil {
+		klog.Fatalln(err)
+	}
+
+	dnsClient, err := pdns.FromFlags(*provider, flag.CommandLine)
+	if err != nil {
+		klog.Fatalln(err)
+	}
+
+	// Followers would serve empty zones as only the leader publishes the records
+	if local, ok := dnsClient.(pdns.ReplicaLocal); ok && local.ReplicaLocal() && *leaderElect {
+		klog.Fatalf("-leader-elect can't be used with %s provider as its records are local to the replica. Run a single replica instead.\n", *provider)
+	}
+	// Provider structs hold the credentials so only providers describing themselves are logged
+	if s, ok := dnsClient.(fmt.Stringer); ok {
+		klog.Infof("DNS provider: %s\n", s)
+	} else {
+		klog.Infof("DNS provider: %s\n", *provider)
+	}
+	klog.Flush()
+
+	if *metricsAddr != "" {
+		go metrics.Serve(*metricsAddr)
+	}
+
+	// Served by all the replicas as the followers are behind the same service
+	if *webhookAddr != "" {
+		go admission.Serve(*webhookAddr, *webhookCert, *webhookKey)
+	}
+
 	config, err := resolveConfig(*kubeconfig)
 	if err != nil {
 		klog.Fatalln(err)
 	}
 
-	if *project == "" {
-		*project, err = gcp.GetProject()
+	controller, err := service.New(config, dnsClient, *namespace, *reconcileInterval, *workers, ttl, *dryRun, gc.Config{
+		Interval:    *gcInterval,
+		GracePeriod: *gcGracePeriod,
+		DryRun:      *gcDryRun || *dryRun,
+	})
+	if err != nil {
+		klog.Fatalln(err)
 	}
 
-	if *project == "" {
-		klog.Fatalln("Failed to resolve GCP project")
+	if *healthAddr != "" {
+		serveHealth(*healthAddr, controller, dnsClient)
 	}
 
-	// JSON key file for service account with DNS admin permissions
-	dnsClient := gcp.FromJSON(*saFile, *zone, *reverseZone, *project)
-	klog.Infof("DNS client: %+v\n", dnsClient)
-	klog.Flush()
+	if !*leaderElect {
+		controller.Run()
+		return
+	}
+
+	if *leaseNamespace == "" {
+		*leaseNamespace = "default"
+	}
 
-	controller, err := service.New(config, dnsClient, *namespace)
+	// Pod name is used to identify the replica
+	identity, err := os.Hostname()
 	if err != nil {
 		klog.Fatalln(err)
 	}
 
-	controller.Run()
+	controller.RunWithLeaderElection(service.LeaderElection{
+		LeaseName:     *leaseName,
+		Namespace:     *leaseNamespace,
+		Identity:      identity,
+		LeaseDuration: *leaseDuration,
+		RenewDeadline: *renewDeadline,
+		RetryPeriod:   *retryPeriod,
+	})
+}
+
+// serveHealth starts the probe endpoints. Liveness fails when the PrivateDNS informer
+// or the DNS provider is stuck and readiness until both are working.
+func serveHealth(addr string, controller *service.Controller, dnsClient pdns.DNSProvider) {
+	live := &health.Checks{}
+	ready := &health.Checks{}
+
+	live.Add("informer", controller.InformerLive)
+	ready.Add("informer", controller.InformerReady)
+
+	if checker, ok := dnsClient.(pdns.HealthChecker); ok {
+		monitor := health.NewProviderMonitor(checker, 30*time.Second, 2*time.Minute)
+		go monitor.Run(wait.NeverStop)
+
+		live.Add("provider", monitor.Live)
+		ready.Add("provider", monitor.Ready)
+	}
+
+	go health.Serve(addr, live, ready)
 }
 
 /*
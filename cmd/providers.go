@@ -0,0 +1,13 @@
+package main
+
+// DNS providers register themselves in the provider registry
+import (
+	_ "github.com/tanelmae/private-dns/pkg/aws"
+	_ "github.com/tanelmae/private-dns/pkg/azure"
+	_ "github.com/tanelmae/private-dns/pkg/etcd"
+	_ "github.com/tanelmae/private-dns/pkg/fanout"
+	_ "github.com/tanelmae/private-dns/pkg/gcp"
+	_ "github.com/tanelmae/private-dns/pkg/memory"
+	_ "github.com/tanelmae/private-dns/pkg/powerdns"
+	_ "github.com/tanelmae/private-dns/pkg/rfc2136"
+)
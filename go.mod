@@ -4,19 +4,27 @@ go 1.15
 
 require (
 	cloud.google.com/go v0.46.3 // indirect
+	github.com/Azure/azure-sdk-for-go v46.4.0+incompatible
+	github.com/Azure/go-autorest/autorest v0.11.4
+	github.com/Azure/go-autorest/autorest/azure/auth v0.5.1
+	github.com/Azure/go-autorest/autorest/to v0.4.1
+	github.com/aws/aws-sdk-go v1.34.28
 	github.com/golang/groupcache v0.0.0-20191002201903-404acd9df4cc // indirect
 	github.com/hashicorp/golang-lru v0.5.3 // indirect
+	github.com/miekg/dns v1.1.31
+	github.com/prometheus/client_golang v1.0.0
 	github.com/stretchr/testify v1.5.1 // indirect
+	go.etcd.io/etcd v0.5.0-alpha.5.0.20200910180754-dd1b699fc489
 	go.opencensus.io v0.22.1 // indirect
-	golang.org/x/net v0.0.0-20191007182048-72f939374954 // indirect
 	golang.org/x/sys v0.0.0-20191007154456-ef33b2fb2c41 // indirect
 	google.golang.org/api v0.10.0
 	google.golang.org/appengine v1.6.5 // indirect
 	google.golang.org/genproto v0.0.0-20191007204434-a023cd5227bd // indirect
-	google.golang.org/grpc v1.24.0 // indirect
 	k8s.io/api v0.17.0
 	k8s.io/apimachinery v0.17.0
 	k8s.io/client-go v0.17.0
 	k8s.io/code-generator v0.18.0-alpha.1
+	k8s.io/klog v1.0.0
 	k8s.io/klog/v2 v2.0.0-20191023130815-8422fac62d1e
+	sigs.k8s.io/yaml v1.1.0
 )
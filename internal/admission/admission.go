@@ -0,0 +1,89 @@
+package admission
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/tanelmae/private-dns/internal/hostname"
+	"github.com/tanelmae/private-dns/internal/pdns"
+	dnsAPI "github.com/tanelmae/private-dns/pkg/apis/privatedns/v1"
+	admissionv1 "k8s.io/api/admission/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/klog/v2"
+)
+
+// Validate checks the parts of PrivateDNS spec the CRD schema can't.
+// Hostname templates have to parse and render valid DNS names.
+func Validate(res *dnsAPI.PrivateDNS) error {
+	h := res.Spec.Hostnames
+	if _, err := hostname.Parse(res.Spec.Domain, h.Pod, h.Service, h.SRV); err != nil {
+		return err
+	}
+
+	if f := res.Spec.OwnerFallback; f != "" {
+		if err := hostname.Validate(f); err != nil {
+			return fmt.Errorf("Invalid owner fallback: %s", err)
+		}
+	}
+
+	ttl := pdns.TTL{
+		Pod:     res.Spec.TTL.Pod,
+		Service: res.Spec.TTL.Service,
+		SRV:     res.Spec.TTL.SRV,
+		PTR:     res.Spec.TTL.PTR,
+	}
+	return ttl.Validate()
+}
+
+// Handler is the validating admission webhook for PrivateDNS resources
+type Handler struct{}
+
+func (Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	review := admissionv1.AdmissionReview{}
+	if err := json.NewDecoder(r.Body).Decode(&review); err != nil || review.Request == nil {
+		http.Error(w, "Invalid admission review", http.StatusBadRequest)
+		return
+	}
+
+	review.Response = respond(review.Request)
+	review.Request = nil
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(review); err != nil {
+		klog.Errorln(err)
+	}
+}
+
+func respond(req *admissionv1.AdmissionRequest) *admissionv1.AdmissionResponse {
+	resp := &admissionv1.AdmissionResponse{UID: req.UID, Allowed: true}
+
+	res := &dnsAPI.PrivateDNS{}
+	err := json.Unmarshal(req.Object.Raw, res)
+	if err == nil {
+		err = Validate(res)
+	}
+	if err != nil {
+		klog.V(2).Infof("Rejected PrivateDNS %s/%s: %s\n", req.Namespace, req.Name, err)
+		resp.Allowed = false
+		resp.Result = &metav1.Status{
+			Status:  metav1.StatusFailure,
+			Message: fmt.Sprintf("Invalid PrivateDNS: %s", err),
+			Reason:  metav1.StatusReasonInvalid,
+			Code:    http.StatusUnprocessableEntity,
+		}
+	}
+	return resp
+}
+
+// Serve serves the webhook on /validate path of the given address with TLS.
+// Doesn't return unless the server fails.
+func Serve(addr, certFile, keyFile string) {
+	mux := http.NewServeMux()
+	mux.Handle("/validate", Handler{})
+
+	klog.Infof("Serving admission webhook on %s/validate\n", addr)
+	if err := http.ListenAndServeTLS(addr, certFile, keyFile, mux); err != nil {
+		klog.Fatalln(err)
+	}
+}
@@ -0,0 +1,77 @@
+package admission
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	dnsAPI "github.com/tanelmae/private-dns/pkg/apis/privatedns/v1"
+	admissionv1 "k8s.io/api/admission/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+func admit(t *testing.T, spec dnsAPI.PrivateDNSSpec) *admissionv1.AdmissionResponse {
+	t.Helper()
+	raw, err := json.Marshal(&dnsAPI.PrivateDNS{Spec: spec})
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, err := json.Marshal(&admissionv1.AdmissionReview{
+		Request: &admissionv1.AdmissionRequest{UID: "uid", Object: runtime.RawExtension{Raw: raw}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rec := httptest.NewRecorder()
+	Handler{}.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/validate", bytes.NewReader(body)))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", rec.Code)
+	}
+
+	review := admissionv1.AdmissionReview{}
+	if err := json.NewDecoder(rec.Body).Decode(&review); err != nil {
+		t.Fatal(err)
+	}
+	if review.Response == nil || review.Response.UID != "uid" {
+		t.Fatalf("unexpected response %+v", review.Response)
+	}
+	return review.Response
+}
+
+func TestAdmission(t *testing.T) {
+	valid := dnsAPI.PrivateDNSSpec{
+		Domain: "example.com",
+		Hostnames: dnsAPI.HostnameTemplates{
+			Pod:     "{{.PodName}}.{{.Namespace}}",
+			Service: `{{index .Labels "app"}}`,
+		},
+	}
+	if resp := admit(t, valid); !resp.Allowed {
+		t.Fatalf("expected to be allowed: %s", resp.Result.Message)
+	}
+
+	for _, templates := range []dnsAPI.HostnameTemplates{
+		{Pod: "{{.PodName"},
+		{Service: "{{.Owner}}"},
+		{SRV: "{{.SRVPort}}/{{.SRVProto}}"},
+	} {
+		if resp := admit(t, dnsAPI.PrivateDNSSpec{Domain: "example.com", Hostnames: templates}); resp.Allowed {
+			t.Fatalf("expected %+v to be rejected", templates)
+		}
+	}
+
+	if resp := admit(t, dnsAPI.PrivateDNSSpec{Domain: "example.com", TTL: dnsAPI.RecordTTL{Pod: -1}}); resp.Allowed {
+		t.Fatal("expected negative TTL to be rejected")
+	}
+}
+
+func TestInvalidReview(t *testing.T) {
+	rec := httptest.NewRecorder()
+	Handler{}.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/validate", bytes.NewReader([]byte("{}"))))
+	if rec.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400, got %d", rec.Code)
+	}
+}
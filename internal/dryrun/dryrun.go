@@ -0,0 +1,102 @@
+package dryrun
+
+import (
+	"fmt"
+
+	"github.com/tanelmae/private-dns/internal/pdns"
+	"k8s.io/klog/v2"
+)
+
+// Request passes the changes to the request of the DNS provider so its checks
+// for the existing and stale records are done, but never sends them.
+// Changes are only logged when the request is done. Requests describing the changes
+// they have computed have those logged, others the requested changes.
+type Request struct {
+	req     pdns.DNSRequest
+	changes []string
+}
+
+// Wrap creates dry-run request around the request of the DNS provider
+func Wrap(req pdns.DNSRequest) *Request {
+	return &Request{req: req}
+}
+
+// Changes returns the changes the request would have made
+func (r *Request) Changes() []string {
+	if describer, ok := r.req.(pdns.ChangeDescriber); ok {
+		return describer.Changes()
+	}
+	return r.changes
+}
+
+func (r *Request) change(format string, args ...interface{}) {
+	r.changes = append(r.changes, fmt.Sprintf(format, args...))
+}
+
+// SetResource passes the PrivateDNS resource to the requests that track the record ownership
+func (r *Request) SetResource(namespace, name string) {
+	if owned, ok := r.req.(pdns.OwnedRequest); ok {
+		owned.SetResource(namespace, name)
+	}
+}
+
+// SetTTL passes the TTL of the added records to the request of the DNS provider
+func (r *Request) SetTTL(ttl pdns.TTL) {
+	r.req.SetTTL(ttl)
+}
+
+// Do logs the changes without making the request
+func (r *Request) Do() error {
+	for _, c := range r.Changes() {
+		klog.Infof("Dry run: would %s\n", c)
+	}
+	return nil
+}
+
+// AddRecord adds A or AAAA record with single IP
+func (r *Request) AddRecord(domain, ip string) {
+	r.req.AddRecord(domain, ip)
+	r.change("add record %s with %s", domain, ip)
+}
+
+// RemoveRecord deletes A or AAAA record with a single IP
+func (r *Request) RemoveRecord(domain, ip string) {
+	r.req.RemoveRecord(domain, ip)
+	r.change("remove record %s with %s", domain, ip)
+}
+
+// AddReverseRecord adds a PTR record for the reverse lookup
+func (r *Request) AddReverseRecord(domain, ip string) {
+	r.req.AddReverseRecord(domain, ip)
+	r.change("add reverse record of %s for %s", ip, domain)
+}
+
+// RemoveReverseRecord removes a PTR record from the reverse lookup zone
+func (r *Request) RemoveReverseRecord(domain, ip string) {
+	r.req.RemoveReverseRecord(domain, ip)
+	r.change("remove reverse record of %s for %s", ip, domain)
+}
+
+// AddToService adds the given IP to A or AAAA record with multiple IPs
+func (r *Request) AddToService(domain, ip string) {
+	r.req.AddToService(domain, ip)
+	r.change("add %s to service %s", ip, domain)
+}
+
+// RemoveFromService removes given IP from an A or AAAA record with multiple IPs
+func (r *Request) RemoveFromService(domain, ip string) {
+	r.req.RemoveFromService(domain, ip)
+	r.change("remove %s from service %s", ip, domain)
+}
+
+// AddToSRV adds target to SRV record
+func (r *Request) AddToSRV(srv string, data pdns.SRV) {
+	r.req.AddToSRV(srv, data)
+	r.change("add %s to SRV record %s", data, srv)
+}
+
+// RemoveFromSRV removes domain from SRV record
+func (r *Request) RemoveFromSRV(srv, domain string) {
+	r.req.RemoveFromSRV(srv, domain)
+	r.change("remove %s from SRV record %s", domain, srv)
+}
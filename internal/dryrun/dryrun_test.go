@@ -0,0 +1,79 @@
+package dryrun
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/tanelmae/private-dns/internal/pdns"
+	"github.com/tanelmae/private-dns/pkg/memory"
+)
+
+func TestDryRun(t *testing.T) {
+	zones := memory.New("example.com", "", "", "")
+
+	// Changes computed by the provider are returned
+	req := Wrap(zones.NewRequest())
+	req.AddRecord("pod-0.app.example.com", "10.0.0.1")
+	req.AddToService("app.example.com", "10.0.0.1")
+	req.AddToSRV("_http._tcp.example.com", pdns.SRV{Priority: 1, Target: "app.example.com"})
+	if err := req.Do(); err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{
+		"add _http._tcp.example.com. 60 IN SRV 1 0 0 app.example.com.",
+		"add app.example.com. 60 IN A 10.0.0.1",
+		"add pod-0.app.example.com. 60 IN A 10.0.0.1",
+	}
+	if fmt.Sprint(req.Changes()) != fmt.Sprint(want) {
+		t.Fatalf("expected %v, got %v", want, req.Changes())
+	}
+
+	records, err := zones.ListRecords("example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 0 {
+		t.Fatalf("expected zone not to be changed, got %v", records)
+	}
+}
+
+func TestDryRunExisting(t *testing.T) {
+	zones := memory.New("example.com", "", "", "")
+	req := zones.NewRequest()
+	req.AddRecord("pod-0.app.example.com", "10.0.0.1")
+	req.AddToService("app.example.com", "10.0.0.1")
+	if err := req.Do(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Existing records are not changed and the stale one is replaced
+	dry := Wrap(zones.NewRequest())
+	dry.AddToService("app.example.com", "10.0.0.1")
+	dry.AddRecord("pod-0.app.example.com", "10.0.0.2")
+
+	want := []string{
+		"remove pod-0.app.example.com. 60 IN A 10.0.0.1",
+		"add pod-0.app.example.com. 60 IN A 10.0.0.2",
+	}
+	if fmt.Sprint(dry.Changes()) != fmt.Sprint(want) {
+		t.Fatalf("expected %v, got %v", want, dry.Changes())
+	}
+}
+
+func TestDryRunRequested(t *testing.T) {
+	zones := memory.New("example.com", "", "", "")
+
+	// Requested changes are returned when the provider can't describe its changes
+	req := Wrap(struct{ pdns.DNSRequest }{zones.NewRequest()})
+	req.AddRecord("pod-0.app.example.com", "10.0.0.1")
+	req.RemoveFromSRV("_http._tcp.example.com", "pod-1.app.example.com")
+
+	want := []string{
+		"add record pod-0.app.example.com with 10.0.0.1",
+		"remove pod-1.app.example.com from SRV record _http._tcp.example.com",
+	}
+	if fmt.Sprint(req.Changes()) != fmt.Sprint(want) {
+		t.Fatalf("expected %v, got %v", want, req.Changes())
+	}
+}
@@ -0,0 +1,236 @@
+package gc
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/tanelmae/private-dns/internal/pdns"
+	"github.com/tanelmae/private-dns/pkg/gen/clientset/privatedns"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/klog/v2"
+)
+
+// Config configures the garbage collection of orphaned records
+type Config struct {
+	// Interval between the passes. Zero disables the garbage collection.
+	Interval time.Duration
+	// GracePeriod is how long a record has to stay orphaned before it is removed
+	GracePeriod time.Duration
+	// DryRun only reports the orphaned records
+	DryRun bool
+}
+
+// Collector removes the owned records whose PrivateDNS resource or pod no longer exists.
+// Such records are left behind when resources or pods are deleted while the controller is down.
+type Collector struct {
+	conf       Config
+	namespace  string
+	kubeClient kubernetes.Interface
+	crdClient  privatedns.Interface
+	dnsClient  pdns.DNSProvider
+	registry   pdns.OwnerRegistry
+
+	// When the records were first found orphaned
+	orphans map[string]time.Time
+}
+
+// New creates garbage collector for the records owned by this controller instance.
+// Only resources in the given namespace are considered when it is not empty.
+func New(conf Config, namespace string, kubeClient kubernetes.Interface,
+	crdClient privatedns.Interface, dnsClient pdns.DNSProvider) (*Collector, error) {
+
+	registry, ok := dnsClient.(pdns.OwnerRegistry)
+	if !ok {
+		return nil, fmt.Errorf("DNS provider %T doesn't keep record ownership", dnsClient)
+	}
+
+	if registry.OwnerID() == "" {
+		return nil, fmt.Errorf("DNS provider %T has no owner ID configured", dnsClient)
+	}
+
+	return &Collector{
+		conf:       conf,
+		namespace:  namespace,
+		kubeClient: kubeClient,
+		crdClient:  crdClient,
+		dnsClient:  dnsClient,
+		registry:   registry,
+		orphans:    make(map[string]time.Time),
+	}, nil
+}
+
+// Run collects the orphaned records right away and then with the configured interval
+// until stopChan is closed
+func (c *Collector) Run(stopChan <-chan struct{}) {
+	klog.Infof("Will garbage collect orphaned records every %s with %s grace period\n",
+		c.conf.Interval, c.conf.GracePeriod)
+	wait.Until(c.collect, c.conf.Interval, stopChan)
+}
+
+// pass caches the Kubernetes lookups for a single garbage collection pass
+type pass struct {
+	resources map[string]bool
+	podIPs    map[string]map[string]bool
+}
+
+func (c *Collector) collect() {
+	records, err := c.registry.OwnedRecords()
+	if err != nil {
+		klog.Errorf("Failed to list owned records: %s\n", err)
+		return
+	}
+
+	now := time.Now()
+	p := pass{
+		resources: make(map[string]bool),
+		podIPs:    make(map[string]map[string]bool),
+	}
+	seen := make(map[string]bool)
+	req := c.dnsClient.NewRequest()
+	orphaned, removed := 0, 0
+
+	for _, rec := range records {
+		owner := rec.Owner
+		if owner.Namespace == "" || owner.Name == "" {
+			continue
+		}
+
+		if c.namespace != "" && owner.Namespace != c.namespace {
+			continue
+		}
+
+		for _, value := range rec.Values {
+			reason, err := c.orphanReason(p, owner, recordIP(rec, value))
+			if err != nil {
+				klog.Errorln(err)
+				continue
+			}
+			if reason == "" {
+				continue
+			}
+
+			key := fmt.Sprintf("%s|%s|%s", rec.Name, rec.Type, value)
+			seen[key] = true
+			orphaned++
+
+			since, ok := c.orphans[key]
+			if !ok {
+				since = now
+				c.orphans[key] = now
+			}
+
+			if now.Sub(since) < c.conf.GracePeriod {
+				klog.V(2).Infof("%s %s %s is orphaned as %s. Waiting for the grace period.\n",
+					rec.Name, rec.Type, value, reason)
+				continue
+			}
+
+			if c.conf.DryRun {
+				klog.Infof("Dry run: would remove %s %s %s of %s/%s as %s\n",
+					rec.Name, rec.Type, value, owner.Namespace, owner.Name, reason)
+				continue
+			}
+
+			klog.Infof("Removing %s %s %s of %s/%s as %s\n",
+				rec.Name, rec.Type, value, owner.Namespace, owner.Name, reason)
+			if pdns.RemoveOwned(req, rec, value) {
+				removed++
+			}
+		}
+	}
+
+	// Records that are not orphaned anymore start the grace period from the beginning
+	for key := range c.orphans {
+		if !seen[key] {
+			delete(c.orphans, key)
+		}
+	}
+
+	if removed > 0 {
+		if err := req.Do(); err != nil {
+			klog.Errorf("Failed to remove orphaned records: %s\n", err)
+			return
+		}
+	}
+	klog.Infof("Garbage collection found %d orphaned records and removed %d of them\n", orphaned, removed)
+}
+
+// orphanReason tells why the record with the pod IP is orphaned. Empty reason means it is not.
+func (c *Collector) orphanReason(p pass, owner pdns.Owner, ip string) (string, error) {
+	exists, err := c.resourceExists(p, owner.Namespace, owner.Name)
+	if err != nil {
+		return "", err
+	}
+	if !exists {
+		return fmt.Sprintf("PrivateDNS %s/%s is gone", owner.Namespace, owner.Name), nil
+	}
+
+	// SRV targets are service names and are removed together with the resource
+	if owner.Kind != pdns.KindPod && owner.Kind != pdns.KindService && owner.Kind != pdns.KindPTR {
+		return "", nil
+	}
+
+	ips, err := c.podIPs(p, owner.Namespace)
+	if err != nil {
+		return "", err
+	}
+	if !ips[ip] {
+		return fmt.Sprintf("pod with IP %s is gone", ip), nil
+	}
+	return "", nil
+}
+
+// recordIP returns the pod IP of the record value.
+// IP of PTR record is in its name.
+func recordIP(rec pdns.OwnedRecord, value string) string {
+	if rec.Owner.Kind == pdns.KindPTR {
+		ip, _ := pdns.ReverseIP(rec.Name)
+		return ip
+	}
+	return value
+}
+
+func (c *Collector) resourceExists(p pass, namespace, name string) (bool, error) {
+	key := fmt.Sprintf("%s/%s", namespace, name)
+	if exists, ok := p.resources[key]; ok {
+		return exists, nil
+	}
+
+	_, err := c.crdClient.TanelmaeV1().PrivateDNS(namespace).Get(name, metav1.GetOptions{})
+	if errors.IsNotFound(err) {
+		p.resources[key] = false
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	p.resources[key] = true
+	return true, nil
+}
+
+func (c *Collector) podIPs(p pass, namespace string) (map[string]bool, error) {
+	if ips, ok := p.podIPs[namespace]; ok {
+		return ips, nil
+	}
+
+	pods, err := c.kubeClient.CoreV1().Pods(namespace).List(metav1.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+
+	ips := make(map[string]bool)
+	for _, pod := range pods.Items {
+		if pod.Status.PodIP != "" {
+			ips[pod.Status.PodIP] = true
+		}
+		for _, ip := range pod.Status.PodIPs {
+			ips[ip.IP] = true
+		}
+	}
+	p.podIPs[namespace] = ips
+	return ips, nil
+}
@@ -0,0 +1,153 @@
+package gc
+
+import (
+	"fmt"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/tanelmae/private-dns/internal/pdns"
+	dnsAPI "github.com/tanelmae/private-dns/pkg/apis/privatedns/v1"
+	crdfake "github.com/tanelmae/private-dns/pkg/gen/clientset/privatedns/fake"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	kubefake "k8s.io/client-go/kubernetes/fake"
+)
+
+// fakeRegistry returns fixed owned records and keeps track of the removals
+type fakeRegistry struct {
+	records []pdns.OwnedRecord
+	removed []string
+}
+
+func (f *fakeRegistry) OwnerID() string { return "test" }
+
+func (f *fakeRegistry) OwnedRecords() ([]pdns.OwnedRecord, error) { return f.records, nil }
+
+func (f *fakeRegistry) NewRequest() pdns.DNSRequest { return &fakeRequest{registry: f} }
+
+type fakeRequest struct {
+	registry *fakeRegistry
+	removed  []string
+}
+
+func (r *fakeRequest) remove(op, name, value string) {
+	r.removed = append(r.removed, fmt.Sprintf("%s %s %s", op, name, value))
+}
+
+func (r *fakeRequest) AddRecord(domain, ip string)           {}
+func (r *fakeRequest) AddReverseRecord(domain, ip string)    {}
+func (r *fakeRequest) AddToService(domain, ip string)        {}
+func (r *fakeRequest) AddToSRV(srv string, data pdns.SRV)    {}
+func (r *fakeRequest) RemoveRecord(domain, ip string)        { r.remove("record", domain, ip) }
+func (r *fakeRequest) RemoveFromService(domain, ip string)   { r.remove("service", domain, ip) }
+func (r *fakeRequest) RemoveFromSRV(srv, domain string)      { r.remove("srv", srv, domain) }
+func (r *fakeRequest) RemoveReverseRecord(domain, ip string) { r.remove("ptr", ip, domain) }
+func (r *fakeRequest) SetTTL(ttl pdns.TTL)                   {}
+func (r *fakeRequest) Do() error {
+	r.registry.removed = append(r.registry.removed, r.removed...)
+	return nil
+}
+
+func owned(name, recType string, kind pdns.RecordKind, resource string, values ...string) pdns.OwnedRecord {
+	return pdns.OwnedRecord{
+		Record: pdns.Record{Name: name, Type: recType, Values: values},
+		Owner:  pdns.Owner{ID: "test", Namespace: "default", Name: resource, Kind: kind},
+	}
+}
+
+func newTestCollector(t *testing.T, conf Config, registry *fakeRegistry) *Collector {
+	kubeClient := kubefake.NewSimpleClientset(&v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "pod-0", Namespace: "default"},
+		Status:     v1.PodStatus{PodIP: "10.0.0.1"},
+	})
+	// Created through the client as the tracker guesses different resource name for seeded objects
+	crdClient := crdfake.NewSimpleClientset()
+	_, err := crdClient.TanelmaeV1().PrivateDNS("default").Create(&dnsAPI.PrivateDNS{
+		ObjectMeta: metav1.ObjectMeta{Name: "app", Namespace: "default"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c, err := New(conf, "", kubeClient, crdClient, registry)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+func testRecords() []pdns.OwnedRecord {
+	return []pdns.OwnedRecord{
+		owned("pod-0.app.example.com", "A", pdns.KindPod, "app", "10.0.0.1"),
+		owned("pod-1.app.example.com", "A", pdns.KindPod, "app", "10.0.0.2"),
+		owned("app.example.com", "A", pdns.KindService, "app", "10.0.0.1", "10.0.0.2"),
+		owned("_http._tcp.example.com", "SRV", pdns.KindSRV, "app", "app.example.com"),
+		owned("_http._tcp.example.com", "SRV", pdns.KindSRV, "gone", "1 0 0 gone.example.com."),
+		owned("pod-0.gone.example.com", "A", pdns.KindPod, "gone", "10.0.0.1"),
+		owned("1.0.0.10.in-addr.arpa", "PTR", pdns.KindPTR, "app", "pod-0.app.example.com"),
+		owned("2.0.0.10.in-addr.arpa", "PTR", pdns.KindPTR, "app", "pod-1.app.example.com"),
+	}
+}
+
+func assertValues(t *testing.T, got []string, want ...string) {
+	t.Helper()
+	sort.Strings(got)
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Fatalf("expected %v, got %v", want, got)
+	}
+}
+
+func TestCollect(t *testing.T) {
+	registry := &fakeRegistry{records: testRecords()}
+	c := newTestCollector(t, Config{Interval: time.Hour}, registry)
+
+	c.collect()
+	assertValues(t, registry.removed,
+		"ptr 10.0.0.2 pod-1.app.example.com",
+		"record pod-0.gone.example.com 10.0.0.1",
+		"record pod-1.app.example.com 10.0.0.2",
+		"service app.example.com 10.0.0.2",
+		"srv _http._tcp.example.com gone.example.com",
+	)
+}
+
+func TestGracePeriod(t *testing.T) {
+	registry := &fakeRegistry{records: testRecords()}
+	c := newTestCollector(t, Config{Interval: time.Hour, GracePeriod: time.Minute}, registry)
+
+	c.collect()
+	assertValues(t, registry.removed)
+
+	// Record that is not orphaned anymore starts the grace period again
+	for key := range c.orphans {
+		c.orphans[key] = time.Now().Add(-2 * time.Minute)
+	}
+	registry.records = registry.records[:1]
+	c.collect()
+	assertValues(t, registry.removed)
+	if len(c.orphans) != 0 {
+		t.Fatalf("expected no orphans, got %v", c.orphans)
+	}
+
+	registry.records = testRecords()
+	c.collect()
+	for key := range c.orphans {
+		c.orphans[key] = time.Now().Add(-2 * time.Minute)
+	}
+	c.collect()
+	if len(registry.removed) != 5 {
+		t.Fatalf("expected orphans to be removed after the grace period, got %v", registry.removed)
+	}
+}
+
+func TestDryRun(t *testing.T) {
+	registry := &fakeRegistry{records: testRecords()}
+	c := newTestCollector(t, Config{Interval: time.Hour, DryRun: true}, registry)
+
+	c.collect()
+	assertValues(t, registry.removed)
+	if len(c.orphans) != 5 {
+		t.Fatalf("expected 5 orphans to be reported, got %v", c.orphans)
+	}
+}
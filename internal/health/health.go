@@ -0,0 +1,134 @@
+package health
+
+import (
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/tanelmae/private-dns/internal/pdns"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/klog/v2"
+)
+
+// Check returns an error when the checked part is not healthy
+type Check func() error
+
+type namedCheck struct {
+	name  string
+	check Check
+}
+
+// Checks is a list of named checks served as a single probe endpoint.
+// Response lists the result of every check and the status is 503 when any of them fails.
+type Checks struct {
+	checks []namedCheck
+}
+
+// Add adds the check to the list
+func (c *Checks) Add(name string, check Check) {
+	c.checks = append(c.checks, namedCheck{name: name, check: check})
+}
+
+func (c *Checks) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	status := http.StatusOK
+	body := ""
+
+	for _, c := range c.checks {
+		if err := c.check(); err != nil {
+			status = http.StatusServiceUnavailable
+			body += fmt.Sprintf("[-]%s failed: %s\n", c.name, err)
+			continue
+		}
+		body += fmt.Sprintf("[+]%s ok\n", c.name)
+	}
+
+	if status != http.StatusOK {
+		klog.V(2).Infof("%s check failed:\n%s", r.URL.Path, body)
+	}
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.WriteHeader(status)
+	fmt.Fprint(w, body)
+}
+
+// Serve serves the liveness checks on /healthz and readiness checks on /readyz
+// of the given address. Doesn't return unless the server fails.
+func Serve(addr string, live, ready *Checks) {
+	mux := http.NewServeMux()
+	mux.Handle("/healthz", live)
+	mux.Handle("/readyz", ready)
+
+	klog.Infof("Serving health checks on %s\n", addr)
+	if err := http.ListenAndServe(addr, mux); err != nil {
+		klog.Fatalln(err)
+	}
+}
+
+// ProviderMonitor checks the DNS provider in the background so the probes
+// don't call the provider API themselves
+type ProviderMonitor struct {
+	checker     pdns.HealthChecker
+	interval    time.Duration
+	hangTimeout time.Duration
+
+	mu      sync.Mutex
+	checked bool
+	lastErr error
+	// When the running check was started. Zero when no check is running.
+	started time.Time
+}
+
+// NewProviderMonitor creates monitor that checks the provider with the given interval.
+// Check that takes longer than the hang timeout fails the liveness.
+func NewProviderMonitor(checker pdns.HealthChecker, interval, hangTimeout time.Duration) *ProviderMonitor {
+	return &ProviderMonitor{
+		checker:     checker,
+		interval:    interval,
+		hangTimeout: hangTimeout,
+	}
+}
+
+// Run checks the provider until stopChan is closed
+func (p *ProviderMonitor) Run(stopChan <-chan struct{}) {
+	wait.Until(p.check, p.interval, stopChan)
+}
+
+func (p *ProviderMonitor) check() {
+	p.mu.Lock()
+	p.started = time.Now()
+	p.mu.Unlock()
+
+	err := p.checker.CheckHealth()
+	if err != nil {
+		klog.Errorf("DNS provider health check failed: %s\n", err)
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.started = time.Time{}
+	p.checked = true
+	p.lastErr = err
+}
+
+// Ready returns the result of the last check
+func (p *ProviderMonitor) Ready() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if !p.checked {
+		return fmt.Errorf("provider not checked yet")
+	}
+	return p.lastErr
+}
+
+// Live returns an error when the running check has hung
+func (p *ProviderMonitor) Live() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if !p.started.IsZero() && time.Since(p.started) > p.hangTimeout {
+		return fmt.Errorf("provider call has not returned in %s", time.Since(p.started).Round(time.Second))
+	}
+	return nil
+}
@@ -0,0 +1,81 @@
+package health
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+type fakeChecker struct {
+	err  error
+	hang chan struct{}
+}
+
+func (f *fakeChecker) CheckHealth() error {
+	if f.hang != nil {
+		<-f.hang
+	}
+	return f.err
+}
+
+func probe(t *testing.T, checks *Checks) (int, string) {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	checks.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+	return rec.Code, rec.Body.String()
+}
+
+func TestChecks(t *testing.T) {
+	failing := fmt.Errorf("not synced")
+	checks := &Checks{}
+	checks.Add("provider", func() error { return nil })
+	checks.Add("informer", func() error { return failing })
+
+	code, body := probe(t, checks)
+	if code != http.StatusServiceUnavailable {
+		t.Fatalf("expected 503, got %d", code)
+	}
+	if body != "[+]provider ok\n[-]informer failed: not synced\n" {
+		t.Fatalf("unexpected body %q", body)
+	}
+
+	failing = nil
+	if code, _ := probe(t, checks); code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", code)
+	}
+}
+
+func TestProviderMonitor(t *testing.T) {
+	checker := &fakeChecker{err: fmt.Errorf("403 Forbidden")}
+	monitor := NewProviderMonitor(checker, time.Minute, time.Millisecond)
+
+	if err := monitor.Ready(); err == nil {
+		t.Fatal("expected unchecked provider not to be ready")
+	}
+
+	monitor.check()
+	if err := monitor.Ready(); err == nil || !strings.Contains(err.Error(), "403") {
+		t.Fatalf("expected check error, got %v", err)
+	}
+
+	checker.err = nil
+	monitor.check()
+	if err := monitor.Ready(); err != nil {
+		t.Fatal(err)
+	}
+	if err := monitor.Live(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Hung provider call fails the liveness
+	checker.hang = make(chan struct{})
+	go monitor.check()
+	time.Sleep(10 * time.Millisecond)
+	if err := monitor.Live(); err == nil {
+		t.Fatal("expected hung check to fail the liveness")
+	}
+	close(checker.hang)
+}
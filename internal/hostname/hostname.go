@@ -0,0 +1,165 @@
+package hostname
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"strings"
+	"text/template"
+)
+
+const (
+	// DefaultPod is the pod record name like nats-0.nats-cluster
+	DefaultPod = "{{.PodName}}.{{.OwnerName}}"
+	// DefaultService is the service record name like nats-cluster
+	DefaultService = "{{.OwnerName}}"
+	// DefaultSRV is the SRV record name like _route._tcp
+	DefaultSRV = "_{{.SRVPort}}._{{.SRVProto}}"
+
+	maxNameLength = 253
+)
+
+var label = regexp.MustCompile(`^[a-zA-Z0-9_]([a-zA-Z0-9_-]{0,61}[a-zA-Z0-9_])?$`)
+
+// Data is available to the templates
+type Data struct {
+	PodName         string
+	Namespace       string
+	OwnerKind       string
+	OwnerName       string
+	Labels          map[string]string
+	Annotations     map[string]string
+	ClusterName     string
+	ClusterLocation string
+	SRVPort         string
+	SRVProto        string
+}
+
+// Templates render the record names under the domain.
+// Templates give the name without the domain.
+type Templates struct {
+	domain   string
+	cluster  string
+	location string
+	pod      *template.Template
+	service  *template.Template
+	srv      *template.Template
+}
+
+// Parse parses the templates of the pod, service and SRV record names.
+// Empty template uses the default one. Templates are tried with sample data
+// so unknown fields and functions or characters not allowed in DNS names fail here.
+func Parse(domain, pod, service, srv string) (*Templates, error) {
+	t := &Templates{domain: domain}
+	var err error
+
+	if t.pod, err = parse("pod", pod, DefaultPod); err != nil {
+		return nil, err
+	}
+	if t.service, err = parse("service", service, DefaultService); err != nil {
+		return nil, err
+	}
+	if t.srv, err = parse("srv", srv, DefaultSRV); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+func parse(name, text, defaultText string) (*template.Template, error) {
+	if text == "" {
+		text = defaultText
+	}
+
+	tmpl, err := template.New(name).Option("missingkey=zero").Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid %s hostname template: %s", name, err)
+	}
+
+	// Label and annotation values are not known beforehand so empty labels are allowed here
+	out, err := execute(tmpl, sample())
+	if err != nil {
+		return nil, fmt.Errorf("Invalid %s hostname template: %s", name, err)
+	}
+	for _, l := range strings.Split(out, ".") {
+		if l != "" && !label.MatchString(l) {
+			return nil, fmt.Errorf("Invalid %s hostname template: %q is not a valid DNS label", name, l)
+		}
+	}
+	return tmpl, nil
+}
+
+func sample() Data {
+	return Data{
+		PodName:         "pod-0",
+		Namespace:       "default",
+		OwnerKind:       "StatefulSet",
+		OwnerName:       "app",
+		Labels:          map[string]string{},
+		Annotations:     map[string]string{},
+		ClusterName:     "cluster",
+		ClusterLocation: "location",
+		SRVPort:         "http",
+		SRVProto:        "tcp",
+	}
+}
+
+func execute(tmpl *template.Template, data Data) (string, error) {
+	buf := &bytes.Buffer{}
+	if err := tmpl.Execute(buf, data); err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(buf.String()), nil
+}
+
+// SetCluster sets the cluster name and location given to the templates
+func (t *Templates) SetCluster(name, location string) {
+	t.cluster = name
+	t.location = location
+}
+
+// Pod returns the pod record name
+func (t *Templates) Pod(data Data) (string, error) {
+	return t.render(t.pod, data)
+}
+
+// Service returns the service record name
+func (t *Templates) Service(data Data) (string, error) {
+	return t.render(t.service, data)
+}
+
+// SRV returns the SRV record name
+func (t *Templates) SRV(data Data) (string, error) {
+	return t.render(t.srv, data)
+}
+
+func (t *Templates) render(tmpl *template.Template, data Data) (string, error) {
+	data.ClusterName = t.cluster
+	data.ClusterLocation = t.location
+
+	out, err := execute(tmpl, data)
+	if err != nil {
+		return "", fmt.Errorf("Failed to render %s hostname: %s", tmpl.Name(), err)
+	}
+
+	name := fmt.Sprintf("%s.%s", out, t.domain)
+	if out == "" {
+		name = t.domain
+	}
+	if err := Validate(name); err != nil {
+		return "", fmt.Errorf("Invalid %s hostname: %s", tmpl.Name(), err)
+	}
+	return name, nil
+}
+
+// Validate checks that the name is a valid DNS name
+func Validate(name string) error {
+	if len(name) > maxNameLength {
+		return fmt.Errorf("%s is longer than %d characters", name, maxNameLength)
+	}
+	for _, l := range strings.Split(name, ".") {
+		if !label.MatchString(l) {
+			return fmt.Errorf("%q in %s is not a valid DNS label", l, name)
+		}
+	}
+	return nil
+}
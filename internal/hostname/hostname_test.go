@@ -0,0 +1,64 @@
+package hostname
+
+import "testing"
+
+func TestRender(t *testing.T) {
+	tmpl, err := Parse("example.com", "", `{{.OwnerName}}.{{index .Labels "tier"}}`, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl.SetCluster("sauna", "europe-north1-a")
+
+	data := Data{
+		PodName:   "nats-0",
+		OwnerName: "nats-cluster",
+		Labels:    map[string]string{"tier": "backend"},
+		SRVPort:   "route",
+		SRVProto:  "tcp",
+	}
+	for _, c := range []struct {
+		render func(Data) (string, error)
+		want   string
+	}{
+		{tmpl.Pod, "nats-0.nats-cluster.example.com"},
+		{tmpl.Service, "nats-cluster.backend.example.com"},
+		{tmpl.SRV, "_route._tcp.example.com"},
+	} {
+		got, err := c.render(data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != c.want {
+			t.Fatalf("expected %s, got %s", c.want, got)
+		}
+	}
+
+	// Missing label leaves an empty label in the name
+	data.Labels = nil
+	if name, err := tmpl.Service(data); err == nil {
+		t.Fatalf("expected invalid name, got %s", name)
+	}
+
+	cluster, err := Parse("example.com", "{{.PodName}}.{{.ClusterName}}.{{.ClusterLocation}}", "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cluster.SetCluster("sauna", "europe-north1-a")
+	if name, _ := cluster.Pod(data); name != "nats-0.sauna.europe-north1-a.example.com" {
+		t.Fatalf("unexpected name %s", name)
+	}
+}
+
+func TestParseInvalid(t *testing.T) {
+	for _, pod := range []string{
+		"{{.PodName",
+		"{{.Pod}}",
+		"{{.PodName | nosuchfunc}}",
+		"{{.PodName}}/{{.Namespace}}",
+		"{{.PodName}} {{.Namespace}}",
+	} {
+		if _, err := Parse("example.com", pod, "", ""); err == nil {
+			t.Fatalf("expected %q to be invalid", pod)
+		}
+	}
+}
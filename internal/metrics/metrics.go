@@ -0,0 +1,215 @@
+package metrics
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"k8s.io/client-go/util/workqueue"
+	"k8s.io/klog/v2"
+)
+
+const namespace = "private_dns"
+
+var (
+	providerCalls = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "provider_calls_total",
+		Help:      "Number of DNS provider API calls",
+	}, []string{"provider", "operation", "zone", "result"})
+
+	providerCallDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "provider_call_duration_seconds",
+		Help:      "Duration of DNS provider API calls",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"provider", "operation", "zone", "result"})
+
+	requestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "request_duration_seconds",
+		Help:      "Duration of DNS requests including the wait for the changes to be applied",
+		Buckets:   prometheus.ExponentialBuckets(0.05, 2, 12),
+	}, []string{"provider", "result"})
+
+	resources = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "resources",
+		Help:      "Number of managed PrivateDNS resources",
+	})
+
+	records = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "records",
+		Help:      "Number of published pod records per PrivateDNS resource",
+	}, []string{"namespace", "name"})
+
+	pendingPods = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "pending_ip_pods",
+		Help:      "Number of pods waiting for an IP per PrivateDNS resource",
+	}, []string{"namespace", "name"})
+
+	reconcileDrift = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "reconcile_drift_total",
+		Help:      "Number of missing and stale records found by the reconciliation",
+	}, []string{"namespace", "name"})
+
+	queueDepth = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Subsystem: "workqueue",
+		Name:      "depth",
+		Help:      "Number of pods waiting in the queue",
+	}, []string{"queue"})
+
+	queueAdds = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "workqueue",
+		Name:      "adds_total",
+		Help:      "Number of pods added to the queue",
+	}, []string{"queue"})
+
+	queueLatency = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Subsystem: "workqueue",
+		Name:      "queue_duration_seconds",
+		Help:      "How long pods stay in the queue before they are handled",
+		Buckets:   prometheus.ExponentialBuckets(0.001, 4, 10),
+	}, []string{"queue"})
+
+	queueWorkDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Subsystem: "workqueue",
+		Name:      "work_duration_seconds",
+		Help:      "How long handling a pod takes",
+		Buckets:   prometheus.ExponentialBuckets(0.001, 4, 10),
+	}, []string{"queue"})
+
+	queueUnfinishedWork = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Subsystem: "workqueue",
+		Name:      "unfinished_work_seconds",
+		Help:      "How long the pods being handled have been in progress",
+	}, []string{"queue"})
+
+	queueLongestRunning = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Subsystem: "workqueue",
+		Name:      "longest_running_processor_seconds",
+		Help:      "How long the longest running worker has been handling a pod",
+	}, []string{"queue"})
+
+	queueRetries = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "workqueue",
+		Name:      "retries_total",
+		Help:      "Number of retried pods",
+	}, []string{"queue"})
+)
+
+func init() {
+	prometheus.MustRegister(
+		providerCalls,
+		providerCallDuration,
+		requestDuration,
+		resources,
+		records,
+		pendingPods,
+		reconcileDrift,
+		queueDepth,
+		queueAdds,
+		queueLatency,
+		queueWorkDuration,
+		queueUnfinishedWork,
+		queueLongestRunning,
+		queueRetries,
+	)
+	workqueue.SetProvider(queueMetrics{})
+}
+
+// Serve exposes the metrics on /metrics path of the given address.
+// Doesn't return unless the server fails.
+func Serve(addr string) {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.Handler())
+
+	klog.Infof("Serving metrics on %s/metrics\n", addr)
+	if err := http.ListenAndServe(addr, mux); err != nil {
+		klog.Fatalln(err)
+	}
+}
+
+func result(err error) string {
+	if err != nil {
+		return "error"
+	}
+	return "success"
+}
+
+// ObserveCall records DNS provider API call that was started at the given time
+func ObserveCall(provider, operation, zone string, start time.Time, err error) {
+	providerCalls.WithLabelValues(provider, operation, zone, result(err)).Inc()
+	providerCallDuration.WithLabelValues(provider, operation, zone, result(err)).Observe(time.Since(start).Seconds())
+}
+
+// ObserveRequest records DNS request that was started at the given time.
+// Meant to be deferred with the named error result of the request.
+func ObserveRequest(provider string, start time.Time, err *error) {
+	requestDuration.WithLabelValues(provider, result(*err)).Observe(time.Since(start).Seconds())
+}
+
+// SetResources sets the number of managed PrivateDNS resources
+func SetResources(count int) {
+	resources.Set(float64(count))
+}
+
+// SetRecords sets the number of published records and pending pods of the PrivateDNS resource
+func SetRecords(namespace, name string, published, pending int) {
+	records.WithLabelValues(namespace, name).Set(float64(published))
+	pendingPods.WithLabelValues(namespace, name).Set(float64(pending))
+}
+
+// DeleteRecords drops the metrics of PrivateDNS resource that is not managed anymore
+func DeleteRecords(namespace, name string) {
+	records.DeleteLabelValues(namespace, name)
+	pendingPods.DeleteLabelValues(namespace, name)
+	reconcileDrift.DeleteLabelValues(namespace, name)
+}
+
+// AddDrift counts the records the reconciliation found missing or stale
+func AddDrift(namespace, name string, changes int) {
+	reconcileDrift.WithLabelValues(namespace, name).Add(float64(changes))
+}
+
+// queueMetrics provides the metrics of the named work queues
+type queueMetrics struct{}
+
+func (queueMetrics) NewDepthMetric(name string) workqueue.GaugeMetric {
+	return queueDepth.WithLabelValues(name)
+}
+
+func (queueMetrics) NewAddsMetric(name string) workqueue.CounterMetric {
+	return queueAdds.WithLabelValues(name)
+}
+
+func (queueMetrics) NewLatencyMetric(name string) workqueue.HistogramMetric {
+	return queueLatency.WithLabelValues(name)
+}
+
+func (queueMetrics) NewWorkDurationMetric(name string) workqueue.HistogramMetric {
+	return queueWorkDuration.WithLabelValues(name)
+}
+
+func (queueMetrics) NewUnfinishedWorkSecondsMetric(name string) workqueue.SettableGaugeMetric {
+	return queueUnfinishedWork.WithLabelValues(name)
+}
+
+func (queueMetrics) NewLongestRunningProcessorSecondsMetric(name string) workqueue.SettableGaugeMetric {
+	return queueLongestRunning.WithLabelValues(name)
+}
+
+func (queueMetrics) NewRetriesMetric(name string) workqueue.CounterMetric {
+	return queueRetries.WithLabelValues(name)
+}
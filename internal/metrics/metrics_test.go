@@ -0,0 +1,81 @@
+package metrics
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"k8s.io/client-go/util/workqueue"
+)
+
+// count returns the number of series of the collector
+func count(c prometheus.Collector) int {
+	ch := make(chan prometheus.Metric)
+	go func() {
+		c.Collect(ch)
+		close(ch)
+	}()
+
+	n := 0
+	for range ch {
+		n++
+	}
+	return n
+}
+
+func request(fail bool) (err error) {
+	defer ObserveRequest("test", time.Now(), &err)
+	if fail {
+		return fmt.Errorf("failed")
+	}
+	return nil
+}
+
+func TestObserveRequest(t *testing.T) {
+	request(false)
+	request(true)
+	request(true)
+
+	if n := count(requestDuration); n != 2 {
+		t.Fatalf("expected success and error series, got %d", n)
+	}
+}
+
+func TestObserveCall(t *testing.T) {
+	ObserveCall("test", "change", "zone", time.Now(), nil)
+	ObserveCall("test", "change", "zone", time.Now(), fmt.Errorf("failed"))
+	ObserveCall("test", "change", "zone", time.Now(), nil)
+
+	if n := testutil.ToFloat64(providerCalls.WithLabelValues("test", "change", "zone", "success")); n != 2 {
+		t.Fatalf("expected 2 successful calls, got %v", n)
+	}
+	if n := testutil.ToFloat64(providerCalls.WithLabelValues("test", "change", "zone", "error")); n != 1 {
+		t.Fatalf("expected 1 failed call, got %v", n)
+	}
+}
+
+func TestRecords(t *testing.T) {
+	SetRecords("default", "app", 3, 1)
+	AddDrift("default", "app", 2)
+	if n := testutil.ToFloat64(records.WithLabelValues("default", "app")); n != 3 {
+		t.Fatalf("expected 3 records, got %v", n)
+	}
+
+	DeleteRecords("default", "app")
+	if n := count(records) + count(reconcileDrift); n != 0 {
+		t.Fatalf("expected metrics to be deleted, got %d series", n)
+	}
+}
+
+func TestQueueDepth(t *testing.T) {
+	queue := workqueue.NewNamedRateLimitingQueue(workqueue.DefaultControllerRateLimiter(), "default/app")
+	defer queue.ShutDown()
+
+	queue.Add("default/pod-0")
+	queue.Add("default/pod-1")
+	if n := testutil.ToFloat64(queueDepth.WithLabelValues("default/app")); n != 2 {
+		t.Fatalf("expected queue depth 2, got %v", n)
+	}
+}
@@ -0,0 +1,111 @@
+package pdns
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+)
+
+const (
+	// TypeA is the record type of IPv4 addresses
+	TypeA = "A"
+	// TypeAAAA is the record type of IPv6 addresses
+	TypeAAAA = "AAAA"
+
+	reverseV4 = "in-addr.arpa"
+	reverseV6 = "ip6.arpa"
+)
+
+// AddressType returns the address record type for the IP
+func AddressType(ip string) string {
+	if IsIPv6(ip) {
+		return TypeAAAA
+	}
+	return TypeA
+}
+
+// IsAddressType tells if the record type holds IP addresses
+func IsAddressType(recType string) bool {
+	return recType == TypeA || recType == TypeAAAA
+}
+
+// IsIPv6 tells if the IP is a valid IPv6 address. IPv4-mapped addresses are IPv4.
+func IsIPv6(ip string) bool {
+	addr := net.ParseIP(ip)
+	return addr != nil && addr.To4() == nil
+}
+
+// ReverseName returns the reverse lookup name of the IP without the trailing dot.
+// IPv4 octets are reversed under in-addr.arpa and IPv6 nibbles under ip6.arpa:
+// 10.0.0.1 is 1.0.0.10.in-addr.arpa and fd00::1 is 1.0.0.0.<...>.0.d.f.ip6.arpa.
+func ReverseName(ip string) (string, bool) {
+	addr := net.ParseIP(ip)
+	if addr == nil {
+		return "", false
+	}
+
+	if v4 := addr.To4(); v4 != nil {
+		return fmt.Sprintf("%d.%d.%d.%d.%s", v4[3], v4[2], v4[1], v4[0], reverseV4), true
+	}
+
+	labels := make([]string, 0, 2*net.IPv6len+1)
+	for i := net.IPv6len - 1; i >= 0; i-- {
+		labels = append(labels, strconv.FormatUint(uint64(addr[i]&0xf), 16), strconv.FormatUint(uint64(addr[i]>>4), 16))
+	}
+	return strings.Join(append(labels, reverseV6), "."), true
+}
+
+// ReverseIP returns the IP of the reverse lookup name. Trailing dot is optional.
+func ReverseIP(name string) (string, bool) {
+	name = strings.ToLower(strings.TrimSuffix(name, "."))
+
+	switch {
+	case strings.HasSuffix(name, "."+reverseV4):
+		octets := strings.Split(strings.TrimSuffix(name, "."+reverseV4), ".")
+		if len(octets) != net.IPv4len {
+			return "", false
+		}
+		for i, j := 0, len(octets)-1; i < j; i, j = i+1, j-1 {
+			octets[i], octets[j] = octets[j], octets[i]
+		}
+		addr := net.ParseIP(strings.Join(octets, "."))
+		if addr == nil || addr.To4() == nil {
+			return "", false
+		}
+		return addr.String(), true
+
+	case strings.HasSuffix(name, "."+reverseV6):
+		nibbles := strings.Split(strings.TrimSuffix(name, "."+reverseV6), ".")
+		if len(nibbles) != 2*net.IPv6len {
+			return "", false
+		}
+		addr := make(net.IP, net.IPv6len)
+		for i, n := range nibbles {
+			v, err := strconv.ParseUint(n, 16, 4)
+			if err != nil || len(n) != 1 {
+				return "", false
+			}
+			// Last nibble is the high half of the first byte
+			b := net.IPv6len - 1 - i/2
+			if i%2 == 0 {
+				addr[b] |= byte(v)
+			} else {
+				addr[b] |= byte(v) << 4
+			}
+		}
+		return addr.String(), true
+	}
+	return "", false
+}
+
+// IsReverseZone tells if the zone is under in-addr.arpa or ip6.arpa
+func IsReverseZone(zone string) bool {
+	return InDomain(zone, reverseV4) || InDomain(zone, reverseV6)
+}
+
+// InReverseZone checks if the reverse lookup name of the IP is in the zone
+func InReverseZone(ip, zone string) bool {
+	name, ok := ReverseName(ip)
+	return ok && zone != "" && InDomain(name, zone)
+}
@@ -0,0 +1,55 @@
+package pdns
+
+import "testing"
+
+func TestReverseName(t *testing.T) {
+	tests := []struct {
+		ip   string
+		name string
+	}{
+		{"10.1.2.3", "3.2.1.10.in-addr.arpa"},
+		{"::ffff:10.1.2.3", "3.2.1.10.in-addr.arpa"},
+		{"fd00::1", "1.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.d.f.ip6.arpa"},
+		{"2001:db8::a:bc", "c.b.0.0.a.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.8.b.d.0.1.0.0.2.ip6.arpa"},
+	}
+
+	for _, tt := range tests {
+		name, ok := ReverseName(tt.ip)
+		if !ok || name != tt.name {
+			t.Fatalf("expected %s for %s, got %s", tt.name, tt.ip, name)
+		}
+
+		ip, ok := ReverseIP(name + ".")
+		if want, _ := ReverseIP(tt.name); !ok || ip != want {
+			t.Fatalf("expected %s from %s, got %s", want, name, ip)
+		}
+	}
+
+	if ip, _ := ReverseIP("1.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.d.f.ip6.arpa"); ip != "fd00::1" {
+		t.Fatalf("unexpected IP %s", ip)
+	}
+
+	for _, invalid := range []string{"0.0.10.in-addr.arpa", "300.0.0.10.in-addr.arpa", "1.0.d.f.ip6.arpa", "10.0.0.1"} {
+		if _, ok := ReverseIP(invalid); ok {
+			t.Fatalf("expected %s not to be a reverse name", invalid)
+		}
+	}
+	if _, ok := ReverseName("not-an-ip"); ok {
+		t.Fatal("expected invalid IP to have no reverse name")
+	}
+}
+
+func TestInReverseZone(t *testing.T) {
+	if !InReverseZone("10.1.2.3", "10.in-addr.arpa.") || InReverseZone("10.1.2.3", "2.10.in-addr.arpa") {
+		t.Fatal("unexpected IPv4 reverse zone match")
+	}
+	if !InReverseZone("fd00::1", "d.f.ip6.arpa") || InReverseZone("fd00::1", "10.in-addr.arpa") {
+		t.Fatal("unexpected IPv6 reverse zone match")
+	}
+	if !IsReverseZone("1.10.in-addr.arpa.") || !IsReverseZone("ip6.arpa") || IsReverseZone("example.com") {
+		t.Fatal("unexpected reverse zone check")
+	}
+	if AddressType("fd00::1") != TypeAAAA || AddressType("10.0.0.1") != TypeA {
+		t.Fatal("unexpected address types")
+	}
+}
@@ -11,7 +11,34 @@ type DNSRequest interface {
 	RemoveReverseRecord(domain, ip string)
 	AddToService(domain, ip string)
 	RemoveFromService(domain, ip string)
-	AddToSRV(srv, domain string, priority int)
+	AddToSRV(srv string, data SRV)
 	RemoveFromSRV(srv, domain string)
+	SetTTL(ttl TTL)
 	Do() error
 }
+
+// ClusterInfo is implemented by providers that can resolve
+// the cluster name and location used for subdomains
+type ClusterInfo interface {
+	ClusterName() (string, error)
+	ClusterLocation() (string, error)
+}
+
+// HealthChecker is implemented by providers that can verify the connection
+// and the credentials with a cheap call such as getting the zone
+type HealthChecker interface {
+	CheckHealth() error
+}
+
+// ChangeDescriber is implemented by requests that can describe the changes
+// they have computed from the existing records, before the request is done
+type ChangeDescriber interface {
+	Changes() []string
+}
+
+// ReplicaLocal is implemented by providers whose records are only served
+// by the replica that wrote them, like the built-in DNS server.
+// Such providers can't be used with leader election.
+type ReplicaLocal interface {
+	ReplicaLocal() bool
+}
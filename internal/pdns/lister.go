@@ -0,0 +1,34 @@
+package pdns
+
+import "strings"
+
+// Record is a record set found in the DNS zone.
+// Name is without the trailing dot.
+type Record struct {
+	Name   string
+	Type   string
+	Values []string
+}
+
+// RecordLister is implemented by providers that can list
+// the records in the zone under the given domain
+type RecordLister interface {
+	ListRecords(domain string) ([]Record, error)
+}
+
+// InDomain checks if the name is the domain or any name under it
+func InDomain(name, domain string) bool {
+	name = strings.ToLower(strings.TrimSuffix(name, "."))
+	domain = strings.ToLower(strings.TrimSuffix(domain, "."))
+	return name == domain || strings.HasSuffix(name, "."+domain)
+}
+
+// SRVTarget returns the target from SRV record data in lower case without the trailing dot.
+// Records written by earlier versions may hold only the target instead of the full data.
+func SRVTarget(value string) string {
+	fields := strings.Fields(value)
+	if len(fields) == 0 {
+		return ""
+	}
+	return strings.ToLower(strings.TrimSuffix(fields[len(fields)-1], "."))
+}
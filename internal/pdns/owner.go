@@ -0,0 +1,128 @@
+package pdns
+
+import (
+	"fmt"
+	"strings"
+
+	"k8s.io/klog/v2"
+)
+
+const (
+	// OwnerPrefix is prepended to the record name to get the name of its TXT ownership record
+	OwnerPrefix = "_private-dns"
+
+	heritage = "private-dns"
+)
+
+// RecordKind tells which kind of record the ownership record is for
+type RecordKind string
+
+const (
+	// KindPod is A or AAAA record with a single pod IP
+	KindPod RecordKind = "pod"
+	// KindService is A or AAAA record with the IPs of all the owner pods
+	KindService RecordKind = "service"
+	// KindSRV is SRV record for the service discovery
+	KindSRV RecordKind = "srv"
+	// KindPTR is PTR record for the reverse lookup of a pod IP
+	KindPTR RecordKind = "ptr"
+)
+
+// Owner identifies the controller instance and the PrivateDNS resource a record was written for
+type Owner struct {
+	ID        string
+	Cluster   string
+	Namespace string
+	Name      string
+	Kind      RecordKind
+}
+
+// OwnedRecord is a record set together with its ownership
+type OwnedRecord struct {
+	Record
+	Owner Owner
+}
+
+// OwnerRegistry is implemented by providers that keep TXT ownership records.
+// OwnedRecords returns the records of this controller instance in all the zones it writes to.
+// Empty owner ID means the registry is disabled.
+type OwnerRegistry interface {
+	OwnerID() string
+	OwnedRecords() ([]OwnedRecord, error)
+}
+
+// OwnedRequest is implemented by requests that keep track of the record ownership.
+// Resource is the PrivateDNS namespace and name the records are written for.
+type OwnedRequest interface {
+	SetResource(namespace, name string)
+}
+
+// OwnerRecordName returns the name of the TXT ownership record for the given record name
+func OwnerRecordName(name string) string {
+	return fmt.Sprintf("%s.%s", OwnerPrefix, name)
+}
+
+// OwnedName returns the record name the TXT ownership record is for
+func OwnedName(ownerRecord string) (string, bool) {
+	if !strings.HasPrefix(ownerRecord, OwnerPrefix+".") {
+		return "", false
+	}
+	return strings.TrimPrefix(ownerRecord, OwnerPrefix+"."), true
+}
+
+// String returns the TXT record data
+func (o Owner) String() string {
+	return fmt.Sprintf("heritage=%s,%s/owner=%s,%s/cluster=%s,%s/resource=%s/%s,%s/record=%s",
+		heritage, heritage, o.ID, heritage, o.Cluster, heritage, o.Namespace, o.Name, heritage, o.Kind)
+}
+
+// ParseOwner parses the TXT record data. Quotes around the data are ignored.
+// False is returned when the TXT record was not written by private-dns.
+func ParseOwner(txt string) (Owner, bool) {
+	owner := Owner{}
+	found := false
+
+	for _, field := range strings.Split(strings.Trim(txt, `"`), ",") {
+		kv := strings.SplitN(field, "=", 2)
+		if len(kv) != 2 {
+			return Owner{}, false
+		}
+
+		switch kv[0] {
+		case "heritage":
+			found = kv[1] == heritage
+		case heritage + "/owner":
+			owner.ID = kv[1]
+		case heritage + "/cluster":
+			owner.Cluster = kv[1]
+		case heritage + "/resource":
+			resource := strings.SplitN(kv[1], "/", 2)
+			if len(resource) == 2 {
+				owner.Namespace, owner.Name = resource[0], resource[1]
+			}
+		case heritage + "/record":
+			owner.Kind = RecordKind(kv[1])
+		}
+	}
+	return owner, found && owner.ID != ""
+}
+
+// RemoveOwned adds the removal of the owned record value to the request.
+// False is returned when the kind of the record is unknown.
+func RemoveOwned(req DNSRequest, rec OwnedRecord, value string) bool {
+	switch rec.Owner.Kind {
+	case KindPod:
+		req.RemoveRecord(rec.Name, value)
+	case KindService:
+		req.RemoveFromService(rec.Name, value)
+	case KindSRV:
+		req.RemoveFromSRV(rec.Name, SRVTarget(value))
+	case KindPTR:
+		ip, _ := ReverseIP(rec.Name)
+		req.RemoveReverseRecord(strings.TrimSuffix(value, "."), ip)
+	default:
+		klog.Warningf("Unknown kind of record %s. Not removing it.\n", rec.Name)
+		return false
+	}
+	return true
+}
@@ -0,0 +1,26 @@
+package pdns
+
+import "testing"
+
+func TestParseOwner(t *testing.T) {
+	owner := Owner{ID: "cluster-a", Cluster: "sauna", Namespace: "default", Name: "app", Kind: KindPod}
+
+	parsed, ok := ParseOwner(`"` + owner.String() + `"`)
+	if !ok || parsed != owner {
+		t.Fatalf("expected %+v, got %+v", owner, parsed)
+	}
+
+	for _, txt := range []string{
+		"v=spf1 -all",
+		"heritage=external-dns,external-dns/owner=default",
+		"heritage=private-dns,private-dns/cluster=sauna",
+	} {
+		if _, ok := ParseOwner(txt); ok {
+			t.Fatalf("expected %q not to be an owner record", txt)
+		}
+	}
+
+	if name, ok := OwnedName(OwnerRecordName("pod-0.app.example.com.")); !ok || name != "pod-0.app.example.com." {
+		t.Fatalf("unexpected owned name %s", name)
+	}
+}
@@ -0,0 +1,226 @@
+// Package pdnstest has the behaviour tests shared by the DNS providers.
+// Every provider runs them against its own fake API.
+package pdnstest
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/tanelmae/private-dns/internal/pdns"
+)
+
+// Fake is a DNS provider backed by a fake API
+type Fake struct {
+	Provider pdns.DNSProvider
+	// Values returns the data of the record set with the fully
+	// qualified name and the type as stored by the fake API
+	Values func(name, recType string) []string
+	// Writes returns the number of writes made to the fake API.
+	// Providers that make a single write per record set or zone set it.
+	Writes func() int
+}
+
+// Run runs the shared behaviour tests. New is called for every test
+// and has to return a provider with empty zones for example.com
+// and a reverse zone that covers 10.0.0.0/8.
+func Run(t *testing.T, new func(t *testing.T) Fake) {
+	tests := []struct {
+		name string
+		test func(t *testing.T, f Fake)
+	}{
+		{"ARecord", testARecord},
+		{"Service", testService},
+		{"SRV", testSRV},
+		{"PTR", testPTR},
+		{"BatchedChanges", testBatchedChanges},
+		{"ListRecords", testListRecords},
+		{"CheckHealth", testCheckHealth},
+	}
+
+	for _, tc := range tests {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			tc.test(t, new(t))
+		})
+	}
+}
+
+// AssertValues fails the test when the values don't match in any order
+func AssertValues(t *testing.T, got []string, want ...string) {
+	t.Helper()
+	if a, b := sorted(got), sorted(want); a != b {
+		t.Fatalf("expected %s, got %s", b, a)
+	}
+}
+
+func sorted(values []string) string {
+	out := append([]string{}, values...)
+	sort.Strings(out)
+	return fmt.Sprint(out)
+}
+
+// assertData compares the record data without the trailing dots
+// as only some of the APIs keep them in the names
+func assertData(t *testing.T, got []string, want ...string) {
+	t.Helper()
+	trim := func(values []string) []string {
+		out := []string{}
+		for _, v := range values {
+			out = append(out, strings.TrimSuffix(v, "."))
+		}
+		return out
+	}
+	AssertValues(t, trim(got), trim(want)...)
+}
+
+// Do fails the test when the request fails
+func Do(t *testing.T, req interface{ Do() error }) {
+	t.Helper()
+	if err := req.Do(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func testARecord(t *testing.T, f Fake) {
+	req := f.Provider.NewRequest()
+	req.AddRecord("pod-0.app.example.com", "10.0.0.1")
+	Do(t, req)
+	assertData(t, f.Values("pod-0.app.example.com.", pdns.TypeA), "10.0.0.1")
+	assertData(t, f.Values("1.0.0.10.in-addr.arpa.", "PTR"), "pod-0.app.example.com.")
+
+	// Stale record gets replaced
+	req = f.Provider.NewRequest()
+	req.AddRecord("pod-0.app.example.com", "10.0.0.2")
+	Do(t, req)
+	assertData(t, f.Values("pod-0.app.example.com.", pdns.TypeA), "10.0.0.2")
+	assertData(t, f.Values("2.0.0.10.in-addr.arpa.", "PTR"), "pod-0.app.example.com.")
+
+	// Record with other IP is left alone
+	req = f.Provider.NewRequest()
+	req.RemoveRecord("pod-0.app.example.com", "10.0.0.1")
+	Do(t, req)
+	assertData(t, f.Values("pod-0.app.example.com.", pdns.TypeA), "10.0.0.2")
+	assertData(t, f.Values("2.0.0.10.in-addr.arpa.", "PTR"), "pod-0.app.example.com.")
+
+	req = f.Provider.NewRequest()
+	req.RemoveRecord("pod-0.app.example.com", "10.0.0.2")
+	Do(t, req)
+	assertData(t, f.Values("pod-0.app.example.com.", pdns.TypeA))
+	assertData(t, f.Values("2.0.0.10.in-addr.arpa.", "PTR"))
+}
+
+func testService(t *testing.T, f Fake) {
+	for _, ip := range []string{"10.0.0.1", "10.0.0.2", "10.0.0.2", "10.0.0.3"} {
+		req := f.Provider.NewRequest()
+		req.AddToService("app.example.com", ip)
+		Do(t, req)
+	}
+	assertData(t, f.Values("app.example.com.", pdns.TypeA), "10.0.0.1", "10.0.0.2", "10.0.0.3")
+
+	req := f.Provider.NewRequest()
+	req.RemoveFromService("app.example.com", "10.0.0.2")
+	Do(t, req)
+	assertData(t, f.Values("app.example.com.", pdns.TypeA), "10.0.0.1", "10.0.0.3")
+
+	req = f.Provider.NewRequest()
+	req.RemoveFromService("app.example.com", "10.0.0.1")
+	req.RemoveFromService("app.example.com", "10.0.0.3")
+	Do(t, req)
+	assertData(t, f.Values("app.example.com.", pdns.TypeA))
+}
+
+func testSRV(t *testing.T, f Fake) {
+	for _, target := range []string{"pod-0.app.example.com", "pod-1.app.example.com", "pod-1.app.example.com"} {
+		req := f.Provider.NewRequest()
+		req.AddToSRV("_http._tcp.example.com", pdns.SRV{Priority: 1, Port: 8080, Target: target})
+		Do(t, req)
+	}
+	assertData(t, f.Values("_http._tcp.example.com.", "SRV"),
+		"1 0 8080 pod-0.app.example.com.", "1 0 8080 pod-1.app.example.com.")
+
+	// Changed data replaces the target
+	req := f.Provider.NewRequest()
+	req.AddToSRV("_http._tcp.example.com", pdns.SRV{Priority: 2, Weight: 10, Port: 8080, Target: "pod-0.app.example.com"})
+	Do(t, req)
+	assertData(t, f.Values("_http._tcp.example.com.", "SRV"),
+		"1 0 8080 pod-1.app.example.com.", "2 10 8080 pod-0.app.example.com.")
+
+	req = f.Provider.NewRequest()
+	req.RemoveFromSRV("_http._tcp.example.com", "pod-0.app.example.com")
+	Do(t, req)
+	assertData(t, f.Values("_http._tcp.example.com.", "SRV"), "1 0 8080 pod-1.app.example.com.")
+
+	req = f.Provider.NewRequest()
+	req.RemoveFromSRV("_http._tcp.example.com", "pod-1.app.example.com")
+	Do(t, req)
+	assertData(t, f.Values("_http._tcp.example.com.", "SRV"))
+}
+
+func testPTR(t *testing.T, f Fake) {
+	req := f.Provider.NewRequest()
+	req.AddReverseRecord("pod-0.app.example.com", "10.0.0.1")
+	Do(t, req)
+	assertData(t, f.Values("1.0.0.10.in-addr.arpa.", "PTR"), "pod-0.app.example.com.")
+
+	req = f.Provider.NewRequest()
+	req.RemoveReverseRecord("pod-0.app.example.com", "10.0.0.1")
+	Do(t, req)
+	assertData(t, f.Values("1.0.0.10.in-addr.arpa.", "PTR"))
+}
+
+func testBatchedChanges(t *testing.T, f Fake) {
+	req := f.Provider.NewRequest()
+	req.AddToService("app.example.com", "10.0.0.1")
+	req.AddToService("app.example.com", "10.0.0.2")
+	req.AddToService("app.example.com", "10.0.0.3")
+	Do(t, req)
+	assertData(t, f.Values("app.example.com.", pdns.TypeA), "10.0.0.1", "10.0.0.2", "10.0.0.3")
+
+	// Every change sees the changes made before it in the same request
+	req = f.Provider.NewRequest()
+	req.RemoveFromService("app.example.com", "10.0.0.1")
+	req.AddToService("app.example.com", "10.0.0.4")
+	req.RemoveFromService("app.example.com", "10.0.0.2")
+	Do(t, req)
+	assertData(t, f.Values("app.example.com.", pdns.TypeA), "10.0.0.3", "10.0.0.4")
+
+	if f.Writes != nil && f.Writes() != 2 {
+		t.Fatalf("expected a single write per request, got %d", f.Writes())
+	}
+}
+
+func testListRecords(t *testing.T, f Fake) {
+	lister, ok := f.Provider.(pdns.RecordLister)
+	if !ok {
+		t.Skip("provider can't list records")
+	}
+
+	req := f.Provider.NewRequest()
+	req.AddRecord("pod-0.app.example.com", "10.0.0.1")
+	req.AddToService("app.example.com", "10.0.0.1")
+	req.AddToService("other.example.com", "10.0.0.2")
+	Do(t, req)
+
+	records, err := lister.ListRecords("app.example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	names := []string{}
+	for _, r := range records {
+		names = append(names, fmt.Sprintf("%s %s %v", r.Name, r.Type, r.Values))
+	}
+	AssertValues(t, names, "app.example.com A [10.0.0.1]", "pod-0.app.example.com A [10.0.0.1]")
+}
+
+func testCheckHealth(t *testing.T, f Fake) {
+	checker, ok := f.Provider.(pdns.HealthChecker)
+	if !ok {
+		t.Skip("provider has no health check")
+	}
+	if err := checker.CheckHealth(); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,120 @@
+package pdns
+
+import (
+	"flag"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// ProviderConfig holds the configuration of a single DNS provider.
+// Fields can be set with flags or unmarshalled from JSON.
+type ProviderConfig interface {
+	// RegisterFlags adds the provider specific flags to the flag set
+	RegisterFlags(fs *flag.FlagSet)
+	// New validates the configuration and creates the provider
+	New() (DNSProvider, error)
+}
+
+// ConfigFactory returns empty configuration for a provider
+type ConfigFactory func() ProviderConfig
+
+var (
+	registryMu sync.Mutex
+	registry   = make(map[string]ConfigFactory)
+
+	// Configurations bound to the command line flags
+	flagConfigs = make(map[string]ProviderConfig)
+	flagOwners  = make(map[string]string)
+)
+
+// Register makes a provider available by the given name.
+// Meant to be called from the provider package init function.
+func Register(name string, factory ConfigFactory) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+
+	if _, exists := registry[name]; exists {
+		panic(fmt.Sprintf("DNS provider %s registered twice", name))
+	}
+	registry[name] = factory
+}
+
+// Providers returns the names of registered providers
+func Providers() []string {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+
+	names := []string{}
+	for name := range registry {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// NewConfig returns empty configuration for the named provider
+func NewConfig(name string) (ProviderConfig, error) {
+	registryMu.Lock()
+	factory, exists := registry[name]
+	registryMu.Unlock()
+
+	if !exists {
+		return nil, fmt.Errorf("Unknown DNS provider %q. Supported providers: %s",
+			name, strings.Join(Providers(), ", "))
+	}
+	return factory(), nil
+}
+
+// RegisterFlags adds the flags of all registered providers to the flag set
+func RegisterFlags(fs *flag.FlagSet) {
+	for _, name := range Providers() {
+		conf, _ := NewConfig(name)
+
+		// Provider flags are first registered in a separate set
+		// to know which provider owns each flag.
+		providerFlags := flag.NewFlagSet(name, flag.ContinueOnError)
+		conf.RegisterFlags(providerFlags)
+		providerFlags.VisitAll(func(f *flag.Flag) {
+			fs.Var(f.Value, f.Name, f.Usage)
+			flagOwners[f.Name] = name
+		})
+
+		registryMu.Lock()
+		flagConfigs[name] = conf
+		registryMu.Unlock()
+	}
+}
+
+// FromFlags creates the named provider from the parsed flags.
+// Fails when flags of some other provider have been set.
+func FromFlags(name string, fs *flag.FlagSet) (DNSProvider, error) {
+	registryMu.Lock()
+	conf, exists := flagConfigs[name]
+	registryMu.Unlock()
+
+	if !exists {
+		if _, err := NewConfig(name); err != nil {
+			return nil, err
+		}
+		return nil, fmt.Errorf("Flags for DNS provider %s are not registered", name)
+	}
+
+	var err error
+	fs.Visit(func(f *flag.Flag) {
+		if owner, ok := flagOwners[f.Name]; ok && owner != name && err == nil {
+			err = fmt.Errorf("Flag -%s is only supported by %s provider but %s provider was selected",
+				f.Name, owner, name)
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	provider, err := conf.New()
+	if err != nil {
+		return nil, fmt.Errorf("Failed to create %s DNS provider: %s", name, err)
+	}
+	return provider, nil
+}
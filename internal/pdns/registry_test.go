@@ -0,0 +1,64 @@
+package pdns
+
+import (
+	"errors"
+	"flag"
+	"strings"
+	"testing"
+)
+
+type testConfig struct {
+	zone string
+}
+
+func (c *testConfig) RegisterFlags(fs *flag.FlagSet) {
+	fs.StringVar(&c.zone, "test-zone", "", "")
+}
+
+func (c *testConfig) New() (DNSProvider, error) {
+	if c.zone == "" {
+		return nil, errors.New("zone is required")
+	}
+	return nil, nil
+}
+
+type otherConfig struct {
+	testConfig
+}
+
+func (c *otherConfig) RegisterFlags(fs *flag.FlagSet) {
+	fs.StringVar(&c.zone, "other-zone", "", "")
+}
+
+func TestFromFlags(t *testing.T) {
+	Register("test", func() ProviderConfig { return &testConfig{} })
+	Register("other", func() ProviderConfig { return &otherConfig{} })
+
+	newFlags := func(args ...string) *flag.FlagSet {
+		fs := flag.NewFlagSet("test", flag.ContinueOnError)
+		RegisterFlags(fs)
+		if err := fs.Parse(args); err != nil {
+			t.Fatal(err)
+		}
+		return fs
+	}
+
+	if _, err := FromFlags("test", newFlags("-test-zone=example.com")); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err := FromFlags("test", newFlags("-test-zone=example.com", "-other-zone=example.com"))
+	if err == nil || !strings.Contains(err.Error(), "-other-zone is only supported by other provider") {
+		t.Fatalf("expected flag ownership error, got %v", err)
+	}
+
+	_, err = FromFlags("test", newFlags())
+	if err == nil || !strings.Contains(err.Error(), "zone is required") {
+		t.Fatalf("expected configuration error, got %v", err)
+	}
+
+	_, err = FromFlags("missing", newFlags())
+	if err == nil || !strings.Contains(err.Error(), "Supported providers: other, test") {
+		t.Fatalf("expected unknown provider error, got %v", err)
+	}
+}
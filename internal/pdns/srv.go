@@ -0,0 +1,46 @@
+package pdns
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// SRV is a single target of SRV record
+type SRV struct {
+	Priority int
+	Weight   int
+	Port     int
+	// Target is the domain name without the trailing dot
+	Target string
+}
+
+// String returns the record data as "priority weight port target." with fully qualified target
+func (s SRV) String() string {
+	return fmt.Sprintf("%d %d %d %s.", s.Priority, s.Weight, s.Port, strings.TrimSuffix(s.Target, "."))
+}
+
+// Same tells if the record data is equal ignoring the case and the trailing dot of the target
+func (s SRV) Same(other SRV) bool {
+	return s.Priority == other.Priority && s.Weight == other.Weight && s.Port == other.Port &&
+		SRVTarget(s.Target) == SRVTarget(other.Target)
+}
+
+// ParseSRV parses "priority weight port target" record data.
+// Target is returned in lower case without the trailing dot.
+func ParseSRV(value string) (SRV, bool) {
+	fields := strings.Fields(value)
+	if len(fields) != 4 {
+		return SRV{}, false
+	}
+
+	numbers := make([]int, 3)
+	for i, f := range fields[:3] {
+		n, err := strconv.ParseUint(f, 10, 16)
+		if err != nil {
+			return SRV{}, false
+		}
+		numbers[i] = int(n)
+	}
+	return SRV{Priority: numbers[0], Weight: numbers[1], Port: numbers[2], Target: SRVTarget(fields[3])}, true
+}
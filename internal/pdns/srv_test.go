@@ -0,0 +1,46 @@
+package pdns
+
+import "testing"
+
+func TestParseSRV(t *testing.T) {
+	tests := []struct {
+		value string
+		srv   SRV
+	}{
+		{"0 0 8080 pod-0.app.example.com.", SRV{Port: 8080, Target: "pod-0.app.example.com"}},
+		{"10 20 53 Pod-0.App.Example.com", SRV{Priority: 10, Weight: 20, Port: 53, Target: "pod-0.app.example.com"}},
+	}
+
+	for _, tt := range tests {
+		srv, ok := ParseSRV(tt.value)
+		if !ok || srv != tt.srv {
+			t.Fatalf("expected %+v from %s, got %+v", tt.srv, tt.value, srv)
+		}
+		if parsed, _ := ParseSRV(srv.String()); !parsed.Same(srv) {
+			t.Fatalf("expected %s to parse back to %+v, got %+v", srv, srv, parsed)
+		}
+	}
+
+	for _, invalid := range []string{"pod-0.app.example.com", "1 0 pod-0.app.example.com.", "1 0 70000 pod-0.app.example.com.", "-1 0 80 a."} {
+		if _, ok := ParseSRV(invalid); ok {
+			t.Fatalf("expected %s not to be SRV data", invalid)
+		}
+	}
+}
+
+func TestSRVString(t *testing.T) {
+	for _, target := range []string{"pod-0.app.example.com", "pod-0.app.example.com."} {
+		srv := SRV{Priority: 1, Weight: 2, Port: 8080, Target: target}
+		if srv.String() != "1 2 8080 pod-0.app.example.com." {
+			t.Fatalf("unexpected SRV data %s", srv)
+		}
+	}
+
+	srv := SRV{Port: 8080, Target: "pod-0.app.example.com"}
+	if !srv.Same(SRV{Port: 8080, Target: "POD-0.app.example.com."}) {
+		t.Fatal("expected target case and trailing dot to be ignored")
+	}
+	if srv.Same(SRV{Weight: 1, Port: 8080, Target: "pod-0.app.example.com"}) {
+		t.Fatal("expected different weight not to be the same")
+	}
+}
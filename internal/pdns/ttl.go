@@ -0,0 +1,68 @@
+package pdns
+
+import "fmt"
+
+const (
+	// DefaultTTL is the time to live in seconds of the records without a TTL set
+	DefaultTTL = 60
+	// MinTTL and MaxTTL are the limits of the TTL that can be set
+	MinTTL = 1
+	MaxTTL = 86400
+)
+
+// TTL is the time to live in seconds of the records by their kind.
+// Zero uses the default TTL.
+type TTL struct {
+	Pod     int64
+	Service int64
+	SRV     int64
+	PTR     int64
+}
+
+// For returns the TTL of the given kind of record
+func (t TTL) For(kind RecordKind) int64 {
+	ttl := int64(0)
+	switch kind {
+	case KindPod:
+		ttl = t.Pod
+	case KindService:
+		ttl = t.Service
+	case KindSRV:
+		ttl = t.SRV
+	case KindPTR:
+		ttl = t.PTR
+	}
+
+	if ttl == 0 {
+		return DefaultTTL
+	}
+	return ttl
+}
+
+// Validate checks that the set TTLs are within the limits
+func (t TTL) Validate() error {
+	for _, kind := range []RecordKind{KindPod, KindService, KindSRV, KindPTR} {
+		ttl := t.For(kind)
+		if ttl < MinTTL || ttl > MaxTTL {
+			return fmt.Errorf("TTL of %s records must be between %d and %d: %d", kind, MinTTL, MaxTTL, ttl)
+		}
+	}
+	return nil
+}
+
+// Or returns the TTLs with the unset ones taken from the defaults
+func (t TTL) Or(defaults TTL) TTL {
+	if t.Pod == 0 {
+		t.Pod = defaults.Pod
+	}
+	if t.Service == 0 {
+		t.Service = defaults.Service
+	}
+	if t.SRV == 0 {
+		t.SRV = defaults.SRV
+	}
+	if t.PTR == 0 {
+		t.PTR = defaults.PTR
+	}
+	return t
+}
@@ -0,0 +1,19 @@
+package pdns
+
+import "testing"
+
+func TestTTL(t *testing.T) {
+	ttl := TTL{Pod: 30}.Or(TTL{Pod: 60, Service: 300})
+	if ttl.For(KindPod) != 30 || ttl.For(KindService) != 300 || ttl.For(KindSRV) != DefaultTTL {
+		t.Fatalf("unexpected TTLs %+v", ttl)
+	}
+	if err := ttl.Validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, invalid := range []TTL{{Pod: -1}, {PTR: MaxTTL + 1}} {
+		if err := invalid.Validate(); err == nil {
+			t.Fatalf("expected %+v to be invalid", invalid)
+		}
+	}
+}
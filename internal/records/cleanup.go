@@ -0,0 +1,147 @@
+package records
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/tanelmae/private-dns/internal/pdns"
+	dnsAPI "github.com/tanelmae/private-dns/pkg/apis/privatedns/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/klog/v2"
+)
+
+// Cleanup stops the pod watcher and removes all the records of the deleted PrivateDNS resource.
+// Records are looked up from the pods with the label, the published records in the status
+// and, when the provider keeps the record ownership, the records owned by the resource.
+// Pod watcher store is not used as it may have been stopped before the initial list was handled.
+// Failure is written to the resource status and returned so the removal can be retried.
+func (m Manager) Cleanup(pdns *dnsAPI.PrivateDNS) error {
+	m.Stop()
+
+	// Deletion changes the generation
+	m.generation = pdns.Generation
+	klog.Infof("Removing all %s/%s private DNS records\n", m.namespace, m.name)
+
+	err := m.removeAll(pdns.Status.Records)
+	if err != nil {
+		err = fmt.Errorf("Failed to remove %s/%s records: %s", m.namespace, m.name, err)
+		m.updateStatus(err)
+		return err
+	}
+
+	m.status.replace(make(map[string]dnsAPI.PublishedRecord))
+	m.updateStatus(nil)
+	return nil
+}
+
+// removeAll removes the records this manager has published and the records owned by the resource.
+// Other records under the same names are left alone as they may belong to other resources.
+func (m Manager) removeAll(published []dnsAPI.PublishedRecord) error {
+	// Pod record names with their IPs
+	records := make(map[string]map[string]bool)
+	add := func(name, ip string) {
+		name = strings.ToLower(name)
+		if records[name] == nil {
+			records[name] = make(map[string]bool)
+		}
+		records[name][ip] = true
+	}
+
+	// Service and SRV records the pod records were added to
+	podServices := make(map[string]string)
+	srvs := make(map[string]bool)
+	addPublished := func(r dnsAPI.PublishedRecord) {
+		for _, ip := range recordIPs(r) {
+			add(r.Name, ip)
+		}
+		podServices[strings.ToLower(r.Name)] = strings.ToLower(serviceName(r))
+		if srv := m.srvName(r); m.srvEnabled() && srv != "" {
+			srvs[srv] = true
+		}
+	}
+
+	pods, err := m.kubeClient.CoreV1().Pods(m.namespace).List(metav1.ListOptions{LabelSelector: m.label})
+	if err != nil {
+		return err
+	}
+	for i := range pods.Items {
+		pod := &pods.Items[i]
+		ips := podIPs(pod)
+		if len(ips) == 0 || !m.hasOwner(pod) {
+			continue
+		}
+		n, err := m.names(pod)
+		if err != nil {
+			klog.V(2).Infof("No records of pod %s to remove: %s\n", podID(pod), err)
+			continue
+		}
+		addPublished(n.record(ips))
+	}
+
+	for _, r := range append(published, m.status.list()...) {
+		addPublished(r)
+	}
+
+	ownedRecords := []pdns.OwnedRecord{}
+	if registry, ok := m.dnsClient.(pdns.OwnerRegistry); ok && registry.OwnerID() != "" {
+		if ownedRecords, err = m.ownedRecords(); err != nil {
+			return err
+		}
+	}
+
+	// Owned records may have values of pods that are gone and not in the status.
+	// Values removed together with the published records are not removed twice.
+	req := m.newRequest()
+	removed := make(map[string]bool)
+	remove := func(rec pdns.OwnedRecord, value string) {
+		key := fmt.Sprintf("%s|%s|%s", rec.Owner.Kind, strings.ToLower(rec.Name), strings.ToLower(value))
+		if !removed[key] {
+			removed[key] = true
+			pdns.RemoveOwned(req, rec, value)
+		}
+	}
+	owned := func(kind pdns.RecordKind, name string) pdns.OwnedRecord {
+		return pdns.OwnedRecord{Record: pdns.Record{Name: name}, Owner: pdns.Owner{Kind: kind}}
+	}
+
+	for name, ips := range records {
+		for ip := range ips {
+			remove(owned(pdns.KindPod, name), ip)
+			if service := podServices[name]; m.service && service != "" {
+				remove(owned(pdns.KindService, service), ip)
+			}
+		}
+	}
+
+	// Targets are the pod records. SRV records published without the data point to the service records.
+	for srv := range srvs {
+		for name := range records {
+			remove(owned(pdns.KindSRV, srv), name)
+			if service := podServices[name]; service != "" {
+				remove(owned(pdns.KindSRV, srv), service)
+			}
+		}
+	}
+
+	targets := ownedTargets(ownedRecords, nil)
+	for _, rec := range ownedRecords {
+		for _, value := range rec.Values {
+			switch rec.Owner.Kind {
+			case pdns.KindSRV:
+				// Targets of other resources sharing the SRV record are left alone
+				value = pdns.SRVTarget(value)
+				if !targets[value] {
+					continue
+				}
+			case pdns.KindPTR:
+				// Removed together with the pod record
+				ip, _ := pdns.ReverseIP(rec.Name)
+				if records[strings.ToLower(strings.TrimSuffix(value, "."))][ip] {
+					continue
+				}
+			}
+			remove(rec, value)
+		}
+	}
+	return req.Do()
+}
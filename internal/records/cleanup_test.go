@@ -0,0 +1,104 @@
+package records
+
+import (
+	"testing"
+
+	"github.com/tanelmae/private-dns/internal/pdns"
+	dnsAPI "github.com/tanelmae/private-dns/pkg/apis/privatedns/v1"
+	"github.com/tanelmae/private-dns/pkg/memory"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestCleanup(t *testing.T) {
+	zones := memory.New("example.com", "", "", "")
+
+	req := resourceRequest(zones, "app")
+	req.AddRecord("pod-0.app.example.com", "10.0.0.1")
+	req.AddToService("app.example.com", "10.0.0.1")
+	req.AddToSRV("_http._tcp.example.com", pdns.SRV{Priority: 1, Target: "app.example.com"})
+	// Published before the restart and only known from the status
+	req.AddRecord("pod-1.app.example.com", "10.0.0.2")
+	req.AddToService("app.example.com", "10.0.0.2")
+	// Left behind by a failed request
+	req.AddRecord("pod-2.app.example.com", "10.0.0.3")
+	req.AddToService("app.example.com", "10.0.0.3")
+	if err := req.Do(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Records of other resources and written by hand under the same names
+	req = resourceRequest(zones, "other")
+	req.AddRecord("pod-0.other.example.com", "10.0.1.1")
+	req.AddToService("other.example.com", "10.0.1.1")
+	req.AddToSRV("_http._tcp.example.com", pdns.SRV{Priority: 1, Target: "other.example.com"})
+	req.AddRecord("pod-5.app.example.com", "10.0.1.5")
+	if err := req.Do(); err != nil {
+		t.Fatal(err)
+	}
+	req = zones.NewRequest()
+	req.AddRecord("pod-9.app.example.com", "10.0.0.9")
+	if err := req.Do(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Pod watcher store is empty as it was never started
+	m := newTestManager(t, zones)
+	if _, err := m.kubeClient.CoreV1().Pods("default").Create(newPod("pod-0", "app", "10.0.0.1")); err != nil {
+		t.Fatal(err)
+	}
+
+	pdns := &dnsAPI.PrivateDNS{
+		ObjectMeta: metav1.ObjectMeta{Name: "app", Namespace: "default"},
+		Status: dnsAPI.PrivateDNSStatus{
+			Records: []dnsAPI.PublishedRecord{{Name: "pod-1.app.example.com", IP: "10.0.0.2"}},
+		},
+	}
+	if err := m.Cleanup(pdns); err != nil {
+		t.Fatal(err)
+	}
+
+	assertValues(t, listRecords(t, zones),
+		"_http._tcp.example.com SRV [1 0 0 other.example.com.]",
+		"other.example.com A [10.0.1.1]",
+		"pod-0.other.example.com A [10.0.1.1]",
+		"pod-5.app.example.com A [10.0.1.5]",
+		"pod-9.app.example.com A [10.0.0.9]",
+	)
+}
+
+func TestDestroyAfterRestart(t *testing.T) {
+	zones := memory.New("example.com", "", "", "")
+
+	req := resourceRequest(zones, "app")
+	req.AddRecord("pod-0.app.example.com", "10.0.0.1")
+	req.AddToService("app.example.com", "10.0.0.1")
+	req.AddRecord("pod-1.app.example.com", "10.0.0.2")
+	req.AddToService("app.example.com", "10.0.0.2")
+	if err := req.Do(); err != nil {
+		t.Fatal(err)
+	}
+	req = resourceRequest(zones, "other")
+	req.AddRecord("pod-0.other.example.com", "10.0.1.1")
+	if err := req.Do(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Records are found from the pods and the resource status without the ownership
+	provider := struct {
+		pdns.DNSProvider
+		pdns.RecordLister
+	}{zones, zones}
+	m := newTestManager(t, provider)
+	if _, err := m.kubeClient.CoreV1().Pods("default").Create(newPod("pod-0", "app", "10.0.0.1")); err != nil {
+		t.Fatal(err)
+	}
+
+	m.Destroy(&dnsAPI.PrivateDNS{
+		ObjectMeta: metav1.ObjectMeta{Name: "app", Namespace: "default"},
+		Status: dnsAPI.PrivateDNSStatus{
+			Records: []dnsAPI.PublishedRecord{{Name: "pod-1.app.example.com", IP: "10.0.0.2"}},
+		},
+	})
+
+	assertValues(t, listRecords(t, zones), "pod-0.other.example.com A [10.0.1.1]")
+}
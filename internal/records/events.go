@@ -0,0 +1,54 @@
+package records
+
+import (
+	dnsAPI "github.com/tanelmae/private-dns/pkg/apis/privatedns/v1"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/tools/cache"
+)
+
+// Event reasons
+const (
+	reasonRecordCreated   = "RecordCreated"
+	reasonRecordRemoved   = "RecordRemoved"
+	reasonRecordReplaced  = "RecordReplaced"
+	reasonRecordsRepaired = "RecordsRepaired"
+	reasonProviderError   = "ProviderError"
+	reasonPodIPTimeout    = "PodIPTimeout"
+	reasonInvalidHostname = "InvalidHostname"
+	reasonInvalidSRV      = "InvalidSRV"
+)
+
+// resourceRef references the PrivateDNS resource of the manager in the events
+func (m Manager) resourceRef() *v1.ObjectReference {
+	return &v1.ObjectReference{
+		Kind:       "PrivateDNS",
+		APIVersion: dnsAPI.SchemeGroupVersion.String(),
+		Namespace:  m.namespace,
+		Name:       m.name,
+		UID:        m.uid,
+	}
+}
+
+// podRef references the pod with the given key. Used for the pods that are gone already.
+func podRef(key string) *v1.ObjectReference {
+	namespace, name, _ := cache.SplitMetaNamespaceKey(key)
+	return &v1.ObjectReference{
+		Kind:       "Pod",
+		APIVersion: "v1",
+		Namespace:  namespace,
+		Name:       name,
+	}
+}
+
+// eventf records the event on the PrivateDNS resource and on the pod when it's given.
+// Events of the dry run tell that the changes were not made.
+func (m Manager) eventf(pod runtime.Object, eventType, reason, messageFmt string, args ...interface{}) {
+	if m.dryRun {
+		messageFmt = "Dry run: " + messageFmt
+	}
+	m.recorder.Eventf(m.resourceRef(), eventType, reason, messageFmt, args...)
+	if pod != nil {
+		m.recorder.Eventf(pod, eventType, reason, messageFmt, args...)
+	}
+}
@@ -2,37 +2,104 @@ package records
 
 import (
 	"fmt"
+	"net"
+	"strings"
+	"sync"
 	"time"
 
+	"github.com/tanelmae/private-dns/internal/dryrun"
+	"github.com/tanelmae/private-dns/internal/hostname"
+	"github.com/tanelmae/private-dns/internal/metrics"
 	"github.com/tanelmae/private-dns/internal/pdns"
+	dnsAPI "github.com/tanelmae/private-dns/pkg/apis/privatedns/v1"
+	"github.com/tanelmae/private-dns/pkg/gen/clientset/privatedns"
 	v1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/apimachinery/pkg/util/wait"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/client-go/util/workqueue"
 	"k8s.io/klog/v2"
 )
 
-type close struct{}
+const (
+	retryBackoff    = 500 * time.Millisecond
+	retryBackoffMax = 5 * time.Minute
+)
+
+// Config describes the records of a PrivateDNS resource
+type Config struct {
+	// Name and Namespace of the PrivateDNS resource. Pods are watched in the same namespace.
+	Name      string
+	Namespace string
+	// Generation and UID of the resource the status is written for
+	Generation int64
+	UID        types.UID
+
+	// Label selects the pods the records are published for
+	Label  string
+	Domain string
+	// Hostnames are the templates the record names are rendered from
+	Hostnames *hostname.Templates
+	// OwnerFallback names the pods without a controller. Empty ignores such pods.
+	OwnerFallback string
+	// Service adds the pods to the service record
+	Service bool
+	TTL     pdns.TTL
+
+	// SRV targets are the pod records with the given priority and weight
+	// unless the pod annotations override them
+	SRVPort     string
+	SRVProto    string
+	SRVPriority int
+	SRVWeight   int
+
+	// PodTimeout is how long a pod can wait for an IP before it is reported
+	PodTimeout time.Duration
+	// ReconcileInterval of zero disables the periodic reconciliation
+	ReconcileInterval time.Duration
+	// Workers is the number of pods handled at the same time
+	Workers int
+	// DryRun only logs the changes and never sends them to the DNS provider
+	DryRun bool
+}
 
-// New creates the controller to watch pods with given properties
-// and trigger changes in the DNS records
-func New(name, domain, label, namespace, srvPort, srvProto string, service bool, podTimeout time.Duration,
-	kubeClient *kubernetes.Clientset, DNSprovider pdns.DNSProvider) Manager {
+// New creates the controller to watch pods with the configured properties
+// and trigger changes in the DNS records.
+func New(conf Config, kubeClient *kubernetes.Clientset, crdClient privatedns.Interface,
+	DNSprovider pdns.DNSProvider, recorder record.EventRecorder) Manager {
 
 	m := Manager{
-		name:       name,
-		kubeClient: kubeClient,
-		dnsClient:  DNSprovider,
-		namespace:  namespace,
-		label:      label,
-		domain:     domain,
-		srvProto:   srvProto,
-		srvPort:    srvPort,
-		service:    service,
-		timeout:    podTimeout,
-		pendingIP:  make(map[string]time.Time),
-		stopChan:   make(chan struct{}),
+		name:              conf.Name,
+		generation:        conf.Generation,
+		uid:               conf.UID,
+		dryRun:            conf.DryRun,
+		recorder:          recorder,
+		kubeClient:        kubeClient,
+		crdClient:         crdClient,
+		dnsClient:         DNSprovider,
+		namespace:         conf.Namespace,
+		label:             conf.Label,
+		domain:            conf.Domain,
+		srvProto:          conf.SRVProto,
+		srvPort:           conf.SRVPort,
+		srvPriority:       conf.SRVPriority,
+		srvWeight:         conf.SRVWeight,
+		service:           conf.Service,
+		timeout:           conf.PodTimeout,
+		reconcileInterval: conf.ReconcileInterval,
+		workers:           conf.Workers,
+		hostnames:         conf.Hostnames,
+		ownerFallback:     conf.OwnerFallback,
+		owners:            newOwners(),
+		ttl:               conf.TTL,
+		queue:             newQueue(fmt.Sprintf("%s/%s", conf.Namespace, conf.Name)),
+		pendingIP:         newPending(),
+		stopChan:          make(chan struct{}),
+		stopOnce:          &sync.Once{},
+		status:            newStatus(),
 	}
 
 	watchlist := cache.NewFilteredListWatchFromClient(
@@ -59,173 +126,555 @@ func New(name, domain, label, namespace, srvPort, srvProto string, service bool,
 
 // Manager ..
 type Manager struct {
-	name       string
-	kubeClient *kubernetes.Clientset
-	dnsClient  pdns.DNSProvider
-	timeout    time.Duration
-	pendingIP  map[string]time.Time
-	stopChan   chan struct{}
-	namespace  string
-	label      string
-	domain     string
-	srvProto   string
-	srvPort    string
-	service    bool
-	store      cache.Store
-	controller cache.Controller
+	name              string
+	generation        int64
+	uid               types.UID
+	dryRun            bool
+	recorder          record.EventRecorder
+	kubeClient        kubernetes.Interface
+	crdClient         privatedns.Interface
+	dnsClient         pdns.DNSProvider
+	timeout           time.Duration
+	reconcileInterval time.Duration
+	workers           int
+	hostnames         *hostname.Templates
+	ownerFallback     string
+	owners            *owners
+	ttl               pdns.TTL
+	queue             workqueue.RateLimitingInterface
+	pendingIP         *pending
+	stopChan          chan struct{}
+	stopOnce          *sync.Once
+	namespace         string
+	label             string
+	domain            string
+	srvProto          string
+	srvPort           string
+	srvPriority       int
+	srvWeight         int
+	service           bool
+	store             cache.Store
+	controller        cache.Controller
+	status            *status
 }
 
 // Start will start watching pods defined in the CRD
 func (m Manager) Start() {
 	/*
 		Initial startup will triggger AddFunc for all the pods that match the watchlist.
-		Handlers are run sequentally as the events come in.
+		Handlers only queue the pods and workers update the records.
+		Same pod is never handled by two workers at the same time.
 	*/
 	klog.Infof("Will watch pods with %s label in %s namespace\n", m.label, m.namespace)
+	go m.controller.Run(m.stopChan)
+
+	if !cache.WaitForCacheSync(m.stopChan, m.controller.HasSynced) {
+		klog.Infof("Records manager for %s/%s stopped before the pods were listed\n", m.namespace, m.name)
+		return
+	}
+
+	for i := 0; i < m.workers; i++ {
+		go wait.Until(m.runWorker, time.Second, m.stopChan)
+	}
 
 	// Checks with given interval that all expected records are there
 	// and removes any stale record if any is found.
-	m.controller.Run(m.stopChan)
-	klog.Infof("Records manager for %s/%s\n stopped", m.name, m.namespace)
+	switch {
+	case m.reconcileInterval <= 0:
+	case !m.canReconcile():
+		klog.Warningf("DNS provider can't list the records or doesn't keep their ownership. Not reconciling %s/%s records.\n", m.namespace, m.name)
+	default:
+		klog.Infof("Will reconcile %s/%s records every %s\n", m.namespace, m.name, m.reconcileInterval)
+		go wait.Until(m.reconcile, m.reconcileInterval, m.stopChan)
+	}
+
+	<-m.stopChan
+	klog.Infof("Records manager for %s/%s stopped\n", m.namespace, m.name)
 }
 
-// Stop will close the controller
+// Stop will close the controller and the workers
 func (m Manager) Stop() {
-	m.stopChan <- close{}
+	m.stopOnce.Do(func() {
+		close(m.stopChan)
+		m.queue.ShutDown()
+		metrics.DeleteRecords(m.namespace, m.name)
+	})
 	klog.Infof("Stopping pod watcher for %s/%s \n", m.namespace, m.name)
 }
 
 // Destroy will close the controller and delete all DNS records
-// Should be used when CRD is deleted
-func (m Manager) Destroy() {
+// Should be used when CRD is deleted. Published records of the resource are used
+// as the status of the manager is empty when the records were published before a restart.
+func (m Manager) Destroy(pdns *dnsAPI.PrivateDNS) {
 	m.Stop()
 	klog.Infof("Remove all %s/%s private DNS records\n", m.namespace, m.name)
 
-	if len(m.store.List()) > 0 {
-		for _, i := range m.store.List() {
-			pod := i.(*v1.Pod)
-			m.deleteRecords(pod)
+	// Removal failures are left for the garbage collection
+	if err := m.removeAll(pdns.Status.Records); err != nil {
+		klog.Errorf("Failed to remove %s/%s records: %s\n", m.namespace, m.name, err)
+		return
+	}
+	m.status.replace(make(map[string]dnsAPI.PublishedRecord))
+}
+
+// names are the record names of a pod with the SRV data pointing to the pod record.
+// SRV name is empty when the pod is not added to the SRV record.
+type names struct {
+	pod     string
+	service string
+	srv     string
+	target  pdns.SRV
+	srvErr  error
+}
+
+// record returns the published record of the pod with the given IPs
+func (n names) record(ips []string) dnsAPI.PublishedRecord {
+	r := dnsAPI.PublishedRecord{Name: n.pod, IP: ips[0], Service: n.service, SRV: n.srv}
+	if len(ips) > 1 {
+		r.SecondaryIP = ips[1]
+	}
+	if n.srv != "" {
+		r.SRVData = n.target.String()
+	}
+	return r
+}
+
+// recordIPs returns the IPs of the published record
+func recordIPs(r dnsAPI.PublishedRecord) []string {
+	if r.SecondaryIP == "" {
+		return []string{r.IP}
+	}
+	return []string{r.IP, r.SecondaryIP}
+}
+
+// podIPs returns the IPs of the pod with the primary IP first.
+// Dual-stack pods have an IPv4 and an IPv6 address. Invalid IPs are skipped.
+func podIPs(pod *v1.Pod) []string {
+	all := []string{pod.Status.PodIP}
+	for _, ip := range pod.Status.PodIPs {
+		all = append(all, ip.IP)
+	}
+
+	ips := []string{}
+	families := make(map[string]bool)
+	for _, ip := range all {
+		addr := net.ParseIP(ip)
+		if addr == nil {
+			if ip != "" {
+				klog.Warningf("Pod %s has invalid IP %q\n", podID(pod), ip)
+			}
+			continue
 		}
-	} else {
-		klog.Infof("No pods found for %s/%s\n", m.namespace, m.name)
+
+		// One IP of each family
+		family := pdns.AddressType(ip)
+		if families[family] {
+			continue
+		}
+		families[family] = true
+		ips = append(ips, addr.String())
 	}
+	return ips
 }
 
-func (m Manager) podAddresss(pod *v1.Pod) string {
-	// Example: httppod-0.httpstatefulset.example.com
-	return fmt.Sprintf("%s.%s.%s", pod.GetName(), pod.GetOwnerReferences()[0].Name, m.domain)
+// sameFamily tells if any of the IPs is of the same family as the IP
+func sameFamily(ips []string, ip string) bool {
+	for _, i := range ips {
+		if pdns.AddressType(i) == pdns.AddressType(ip) {
+			return true
+		}
+	}
+	return false
 }
 
-func (m Manager) serviceAddresss(pod *v1.Pod) string {
-	// Example: httpstatefulset.example.com
-	return fmt.Sprintf("%s.%s", pod.GetOwnerReferences()[0].Name, m.domain)
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
 }
 
-func (m Manager) srvAddresss() string {
-	return fmt.Sprintf("_%s._%s.%s", m.srvPort, m.srvProto, m.domain)
+// names resolves the owner of the pod and renders the record names
+func (m Manager) names(pod *v1.Pod) (names, error) {
+	o, err := m.ownerOf(pod)
+	if err != nil {
+		return names{}, err
+	}
+	return m.render(pod, o)
 }
 
-func (m Manager) podUpdated(oldObj, newObj interface{}) {
-	pod := newObj.(*v1.Pod)
-	podName := pod.GetName()
-	namespace := pod.GetNamespace()
-	klog.V(2).Infof("Pod updated: %s/%s\n", namespace, podName)
-
-	pendingID := fmt.Sprintf("%s/%s", namespace, podName)
-	lastTime, isPendingIP := m.pendingIP[pendingID]
-	if isPendingIP && pod.Status.PodIP != "" {
-		klog.V(2).Infof("Able to resolve a pending record for %s since %s\n", podName, lastTime.String())
-
-		if err := m.ensureRecords(pod); err != nil {
-			klog.Error(err)
-		} else {
-			delete(m.pendingIP, pendingID)
+// render renders the record names of the pod from the hostname templates.
+// Example with the default templates: httppod-0.httpstatefulset.example.com
+// SRV name is rendered only when SRV record is enabled and the pod has the SRV port.
+func (m Manager) render(pod *v1.Pod, o owner) (names, error) {
+	data := hostname.Data{
+		PodName:     pod.GetName(),
+		Namespace:   pod.GetNamespace(),
+		OwnerKind:   o.kind,
+		OwnerName:   o.name,
+		Labels:      pod.GetLabels(),
+		Annotations: pod.GetAnnotations(),
+		SRVPort:     m.srvPort,
+		SRVProto:    m.srvProto,
+	}
+
+	n := names{}
+	var err error
+	if n.pod, err = m.hostnames.Pod(data); err != nil {
+		return names{}, err
+	}
+	if n.service, err = m.hostnames.Service(data); err != nil {
+		return names{}, err
+	}
+	if m.srvEnabled() {
+		if n.srv, err = m.hostnames.SRV(data); err != nil {
+			return names{}, err
 		}
+		// Pod records are published without the SRV target
+		if n.target, n.srvErr = m.srvData(pod, n.pod); n.srvErr != nil {
+			n.srv = ""
+		}
+	}
+	return n, nil
+}
+
+// serviceName returns the service record name the pod record was added to.
+// Records published without it have the service record as the parent.
+func serviceName(r dnsAPI.PublishedRecord) string {
+	if r.Service != "" {
+		return r.Service
+	}
+	return parent(r.Name)
+}
+
+// srvName returns the SRV record name the pod was added to.
+// Records published without it use the SRV template without the pod.
+func (m Manager) srvName(r dnsAPI.PublishedRecord) string {
+	if r.SRV != "" {
+		return r.SRV
+	}
+
+	name, err := m.hostnames.SRV(hostname.Data{SRVPort: m.srvPort, SRVProto: m.srvProto})
+	if err != nil {
+		klog.Errorf("No SRV record name for %s: %s\n", r.Name, err)
+	}
+	return name
+}
+
+// stopped tells if the pod watcher has been stopped
+func (m Manager) stopped() bool {
+	select {
+	case <-m.stopChan:
+		return true
+	default:
+		return false
 	}
 }
 
+// newRequest creates a DNS request for the records of this PrivateDNS resource
+func (m Manager) newRequest() pdns.DNSRequest {
+	req := m.dnsClient.NewRequest()
+	if m.dryRun {
+		req = dryrun.Wrap(req)
+	}
+	if owned, ok := req.(pdns.OwnedRequest); ok {
+		owned.SetResource(m.namespace, m.name)
+	}
+	req.SetTTL(m.ttl)
+	return req
+}
+
+func podID(pod *v1.Pod) string {
+	return fmt.Sprintf("%s/%s", pod.GetNamespace(), pod.GetName())
+}
+
+// newQueue creates the queue of pod keys with exponential backoff for the failed ones.
+// Name is used in the queue metrics.
+func newQueue(name string) workqueue.RateLimitingInterface {
+	return workqueue.NewNamedRateLimitingQueue(
+		workqueue.NewItemExponentialFailureRateLimiter(retryBackoff, retryBackoffMax), name)
+}
+
+func (m Manager) enqueue(obj interface{}) {
+	key, err := cache.DeletionHandlingMetaNamespaceKeyFunc(obj)
+	if err != nil {
+		klog.Errorln(err)
+		return
+	}
+	m.queue.Add(key)
+}
+
 // Handler for pod creation
 func (m Manager) podCreated(obj interface{}) {
 	pod := obj.(*v1.Pod)
-	podName := pod.GetName()
-	namespace := pod.GetNamespace()
-	klog.V(2).Infof("Pod created: %s/%s", namespace, podName)
+	klog.V(2).Infof("Pod created: %s/%s", pod.GetNamespace(), pod.GetName())
+	m.enqueue(obj)
+}
 
-	if err := m.ensureRecords(pod); err != nil {
-		klog.Error(err)
-		pendingID := fmt.Sprintf("%s/%s", namespace, podName)
-		m.pendingIP[pendingID] = time.Now()
+// Handler for pod updates. Only IP and SRV annotation changes are of interest.
+func (m Manager) podUpdated(oldObj, newObj interface{}) {
+	pod, oldPod := newObj.(*v1.Pod), oldObj.(*v1.Pod)
+	if fmt.Sprint(podIPs(oldPod)) == fmt.Sprint(podIPs(pod)) && !srvAnnotationsChanged(oldPod, pod) {
+		return
 	}
+	klog.V(2).Infof("Pod updated: %s/%s\n", pod.GetNamespace(), pod.GetName())
+	m.enqueue(newObj)
 }
 
 // Handler for pod deletion events
 func (m Manager) podDeleted(obj interface{}) {
+	klog.V(2).Infof("Pod deleted: %v", obj)
+	m.enqueue(obj)
+}
+
+// runWorker handles the queued pods until the queue is shut down
+func (m Manager) runWorker() {
+	for m.processNextItem() {
+	}
+}
+
+func (m Manager) processNextItem() bool {
+	key, quit := m.queue.Get()
+	if quit {
+		return false
+	}
+	defer m.queue.Done(key)
+
+	err := m.sync(key.(string))
+	if err == nil {
+		m.queue.Forget(key)
+		return true
+	}
+
+	klog.Errorf("Failed to sync records of pod %s (%d retries): %s\n", key, m.queue.NumRequeues(key), err)
+	m.queue.AddRateLimited(key)
+	return true
+}
+
+// sync brings the records of the pod in line with the pod in the informer store.
+// Records of a deleted pod are found from the published records.
+func (m Manager) sync(key string) error {
+	obj, exists, err := m.store.GetByKey(key)
+	if err != nil {
+		return err
+	}
+
+	if !exists {
+		m.pendingIP.remove(key)
+		published, ok := m.status.get(key)
+		if !ok {
+			return nil
+		}
+		return m.deleteRecords(key, published)
+	}
+
 	pod := obj.(*v1.Pod)
-	klog.V(2).Infof("Pod deleted: %s/%s", pod.GetNamespace(), pod.GetName())
-	m.deleteRecords(pod)
+	if !m.hasOwner(pod) {
+		klog.V(2).Infof("Pod %s has no controller. Ignoring it.\n", key)
+		return nil
+	}
+
+	// Pod IP update event queues it again
+	if len(podIPs(pod)) == 0 {
+		m.waitForIP(pod)
+		return nil
+	}
+
+	if since, ok := m.pendingIP.since(key); ok {
+		klog.V(2).Infof("Able to resolve a pending record for %s since %s\n", key, since.String())
+	}
+
+	err = m.ensureRecords(pod)
+	if err == nil {
+		m.pendingIP.remove(key)
+	}
+	return err
 }
 
-func (m Manager) deleteRecords(pod *v1.Pod) {
-	req := m.dnsClient.NewRequest()
-	req.RemoveRecord(m.podAddresss(pod), pod.Status.PodIP)
+// waitForIP marks the pod pending and checks it again after the pod timeout
+func (m Manager) waitForIP(pod *v1.Pod) {
+	key := podID(pod)
+	since, ok := m.pendingIP.since(key)
+	if !ok {
+		klog.V(2).Infof("Pod %s IP missing. Waiting for it.\n", key)
+		m.pendingIP.add(key)
+		m.updateStatus(nil)
+		if m.timeout > 0 {
+			m.queue.AddAfter(key, m.timeout)
+		}
+		return
+	}
+
+	// Timeout is reported only once for each pod
+	if m.timeout > 0 && time.Since(since) >= m.timeout && m.pendingIP.timedOut(key) {
+		klog.Warningf("Pod %s has had no IP for %s\n", key, time.Since(since).Round(time.Second))
+		m.eventf(pod, v1.EventTypeWarning, reasonPodIPTimeout,
+			"Pod %s has had no IP for %s", key, time.Since(since).Round(time.Second))
+	}
+}
 
-	if m.service {
-		req.RemoveFromService(m.serviceAddresss(pod), pod.Status.PodIP)
+func (m Manager) deleteRecords(key string, published dnsAPI.PublishedRecord) error {
+	service := serviceName(published)
+	ips := strings.Join(recordIPs(published), ", ")
+
+	req := m.newRequest()
+	for _, ip := range recordIPs(published) {
+		req.RemoveRecord(published.Name, ip)
+
+		if m.service {
+			req.RemoveFromService(service, ip)
+		}
 	}
 
-	if m.srvProto != "" && m.srvPort != "" {
-		req.RemoveFromSRV(m.srvAddresss(), m.serviceAddresss(pod))
+	if srv := m.srvName(published); m.srvEnabled() && srv != "" {
+		req.RemoveFromSRV(srv, published.Name)
 	}
 
-	// TODO: should handle those failures somehow or
-	// obsolete records might pile up
 	err := req.Do()
-	if err != nil {
-		klog.Errorln(err)
+	if err == nil {
+		m.status.removed(key)
+		m.eventf(podRef(key), v1.EventTypeNormal, reasonRecordRemoved,
+			"Removed %s with %s of pod %s", published.Name, ips, key)
+	} else {
+		m.eventf(podRef(key), v1.EventTypeWarning, reasonProviderError,
+			"Failed to remove %s with %s of pod %s: %s", published.Name, ips, key, err)
 	}
+	m.updateStatus(err)
+	return err
 }
 
 func (m Manager) ensureRecords(pod *v1.Pod) error {
-	var err error
+	key := podID(pod)
+	// Failed owner lookups are retried
+	o, err := m.ownerOf(pod)
+	if err != nil {
+		return err
+	}
+	n, err := m.render(pod, o)
+	if err != nil {
+		// Pod update with other labels or annotations queues it again
+		klog.Errorf("Not publishing records of pod %s: %s\n", key, err)
+		m.eventf(pod, v1.EventTypeWarning, reasonInvalidHostname,
+			"Not publishing records of pod %s: %s", key, err)
+		return nil
+	}
+	name, ips := n.pod, podIPs(pod)
+	addrs := strings.Join(ips, ", ")
+	if m.srvEnabled() && n.srvErr != nil {
+		klog.Warningf("Not adding pod %s to SRV record: %s\n", key, n.srvErr)
+		m.eventf(pod, v1.EventTypeWarning, reasonInvalidSRV,
+			"Not adding pod %s to SRV record: %s", key, n.srvErr)
+	}
 
-	if pod.Status.PodIP == "" {
-		klog.Warningln("Pod IP missing. Will try to resolve.")
-		wait.Poll(2*time.Second, m.timeout, func() (bool, error) {
-			pod, err := m.kubeClient.CoreV1().Pods(
-				pod.GetNamespace()).Get(pod.GetName(), metav1.GetOptions{})
-			if err != nil {
-				klog.Error(err)
-				return false, nil
+	old, published := m.status.get(key)
+	if published && old == n.record(ips) {
+		return nil
+	}
+
+	req := m.newRequest()
+
+	// Pod was published earlier with other IPs or owner.
+	// Address of the same family under the same name is replaced by the new one.
+	if published {
+		for _, oldIP := range recordIPs(old) {
+			if old.Name != name || !sameFamily(ips, oldIP) {
+				req.RemoveRecord(old.Name, oldIP)
 			}
-			if pod.Status.PodIP != "" {
-				klog.V(2).Infof("Pod IP resolved: %s\n", pod.Status.PodIP)
-				return true, nil
+			if m.service && (!contains(ips, oldIP) || serviceName(old) != n.service) {
+				req.RemoveFromService(serviceName(old), oldIP)
 			}
-			return false, nil
-		})
+		}
 
-		pod, err = m.kubeClient.CoreV1().Pods(
-			pod.GetNamespace()).Get(pod.GetName(), metav1.GetOptions{})
-		if err != nil {
-			return err
+		// SRV records published without the data pointed to the service record
+		if srv := m.srvName(old); m.srvEnabled() && srv != "" {
+			if old.SRVData == "" {
+				req.RemoveFromSRV(srv, serviceName(old))
+			} else if old.Name != name || old.SRV != n.srv {
+				req.RemoveFromSRV(srv, old.Name)
+			}
 		}
+	}
 
-		// Leave if for the pod updated event handler
-		if err != nil || pod.Status.PodIP == "" {
-			klog.V(2).Infof("Failed get pod IP in %s\n", m.timeout)
-			return err
+	for _, ip := range ips {
+		req.AddRecord(name, ip)
+
+		if m.service {
+			req.AddToService(n.service, ip)
 		}
 	}
 
-	req := m.dnsClient.NewRequest()
-	req.AddRecord(m.podAddresss(pod), pod.Status.PodIP)
+	if m.srvEnabled() && n.srv != "" {
+		req.AddToSRV(n.srv, n.target)
+	}
+
+	err = req.Do()
+	switch {
+	case err != nil:
+		m.eventf(pod, v1.EventTypeWarning, reasonProviderError,
+			"Failed to publish %s with %s of pod %s: %s", name, addrs, key, err)
+	case published:
+		m.eventf(pod, v1.EventTypeNormal, reasonRecordReplaced,
+			"Replaced %s with %s by %s with %s of pod %s", old.Name, strings.Join(recordIPs(old), ", "), name, addrs, key)
+	default:
+		m.eventf(pod, v1.EventTypeNormal, reasonRecordCreated,
+			"Published %s with %s of pod %s", name, addrs, key)
+	}
 
-	if m.service {
-		req.AddToService(m.serviceAddresss(pod), pod.Status.PodIP)
+	if err == nil {
+		m.status.published(key, n.record(ips))
 	}
+	m.updateStatus(err)
+	return err
+}
+
+// pending keeps track of the pods waiting for an IP.
+// Shared between the copies of Manager.
+type pending struct {
+	mu       sync.Mutex
+	pods     map[string]time.Time
+	timedout map[string]bool
+}
+
+func newPending() *pending {
+	return &pending{pods: make(map[string]time.Time), timedout: make(map[string]bool)}
+}
 
-	if m.srvProto != "" && m.srvPort != "" {
-		req.AddToSRV(m.srvAddresss(), m.serviceAddresss(pod), 1)
+func (p *pending) add(podID string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.pods[podID] = time.Now()
+}
+
+func (p *pending) remove(podID string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	delete(p.pods, podID)
+	delete(p.timedout, podID)
+}
+
+// timedOut marks the pending pod timed out.
+// False is returned when it has been marked already.
+func (p *pending) timedOut(podID string) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.timedout[podID] {
+		return false
 	}
-	return req.Do()
+	p.timedout[podID] = true
+	return true
+}
+
+func (p *pending) since(podID string) (time.Time, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	t, ok := p.pods[podID]
+	return t, ok
+}
+
+func (p *pending) count() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return len(p.pods)
 }
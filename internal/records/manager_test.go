@@ -0,0 +1,287 @@
+package records
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/tanelmae/private-dns/internal/hostname"
+	"github.com/tanelmae/private-dns/internal/pdns"
+	dnsAPI "github.com/tanelmae/private-dns/pkg/apis/privatedns/v1"
+	"github.com/tanelmae/private-dns/pkg/memory"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/record"
+)
+
+// failingProvider fails the requests until it is told to stop failing
+type failingProvider struct {
+	pdns.DNSProvider
+	fail bool
+}
+
+func (p *failingProvider) NewRequest() pdns.DNSRequest {
+	return failingRequest{DNSRequest: p.DNSProvider.NewRequest(), provider: p}
+}
+
+type failingRequest struct {
+	pdns.DNSRequest
+	provider *failingProvider
+}
+
+func (r failingRequest) Do() error {
+	if r.provider.fail {
+		return fmt.Errorf("503 Service Unavailable")
+	}
+	return r.DNSRequest.Do()
+}
+
+func TestSync(t *testing.T) {
+	zones := memory.New("example.com", "", "", "")
+	pod := newPod("pod-0", "app", "")
+	m := newTestManager(t, zones, pod)
+	key := podID(pod)
+
+	// Pod without an IP is pending
+	if err := m.sync(key); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := m.pendingIP.since(key); !ok {
+		t.Fatal("expected pod to be pending")
+	}
+	assertValues(t, listRecords(t, zones))
+
+	pod = newPod("pod-0", "app", "10.0.0.1")
+	if err := m.store.Update(pod); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.sync(key); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := m.pendingIP.since(key); ok {
+		t.Fatal("expected pod not to be pending")
+	}
+	assertValues(t, listRecords(t, zones),
+		"_http._tcp.example.com SRV [0 0 8080 pod-0.app.example.com.]",
+		"app.example.com A [10.0.0.1]",
+		"pod-0.app.example.com A [10.0.0.1]",
+	)
+
+	// Records of the deleted pod are found from the published records
+	if err := m.store.Delete(pod); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.sync(key); err != nil {
+		t.Fatal(err)
+	}
+	assertValues(t, listRecords(t, zones))
+}
+
+func TestSyncIPChanged(t *testing.T) {
+	zones := memory.New("example.com", "", "", "")
+	m := newTestManager(t, zones, newPod("pod-0", "app", "10.0.0.1"), newPod("pod-1", "app", "10.0.0.2"))
+
+	for _, key := range []string{"default/pod-0", "default/pod-1"} {
+		if err := m.sync(key); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := m.store.Update(newPod("pod-0", "app", "10.0.0.3")); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.sync("default/pod-0"); err != nil {
+		t.Fatal(err)
+	}
+	assertValues(t, listRecords(t, zones),
+		"_http._tcp.example.com SRV [0 0 8080 pod-0.app.example.com. 0 0 8080 pod-1.app.example.com.]",
+		"app.example.com A [10.0.0.2 10.0.0.3]",
+		"pod-0.app.example.com A [10.0.0.3]",
+		"pod-1.app.example.com A [10.0.0.2]",
+	)
+}
+
+func TestSyncDualStack(t *testing.T) {
+	zones := memory.New("example.com", "", "", "")
+	pod := newPod("pod-0", "app", "10.0.0.1")
+	pod.Status.PodIPs = []v1.PodIP{{IP: "10.0.0.1"}, {IP: "fd00::1"}}
+	m := newTestManager(t, zones, pod)
+	key := podID(pod)
+
+	if err := m.sync(key); err != nil {
+		t.Fatal(err)
+	}
+	assertValues(t, listRecords(t, zones),
+		"_http._tcp.example.com SRV [0 0 8080 pod-0.app.example.com.]",
+		"app.example.com A [10.0.0.1]",
+		"app.example.com AAAA [fd00::1]",
+		"pod-0.app.example.com A [10.0.0.1]",
+		"pod-0.app.example.com AAAA [fd00::1]",
+	)
+
+	// IPv6 address is gone
+	if err := m.store.Update(newPod("pod-0", "app", "10.0.0.1")); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.sync(key); err != nil {
+		t.Fatal(err)
+	}
+	assertValues(t, listRecords(t, zones),
+		"_http._tcp.example.com SRV [0 0 8080 pod-0.app.example.com.]",
+		"app.example.com A [10.0.0.1]",
+		"pod-0.app.example.com A [10.0.0.1]",
+	)
+
+	if err := m.store.Update(pod); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.sync(key); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.store.Delete(pod); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.sync(key); err != nil {
+		t.Fatal(err)
+	}
+	assertValues(t, listRecords(t, zones))
+}
+
+func TestProviderErrorRetried(t *testing.T) {
+	zones := memory.New("example.com", "", "", "")
+	provider := &failingProvider{DNSProvider: zones, fail: true}
+	m := newTestManager(t, provider, newPod("pod-0", "app", "10.0.0.1"))
+	key := "default/pod-0"
+
+	m.queue.Add(key)
+	m.processNextItem()
+	if requeues := m.queue.NumRequeues(key); requeues != 1 {
+		t.Fatalf("expected pod to be queued again, got %d requeues", requeues)
+	}
+
+	// Queued again after the backoff
+	provider.fail = false
+	m.processNextItem()
+	if requeues := m.queue.NumRequeues(key); requeues != 0 {
+		t.Fatalf("expected pod to be done, got %d requeues", requeues)
+	}
+	assertValues(t, listRecords(t, zones),
+		"_http._tcp.example.com SRV [0 0 8080 pod-0.app.example.com.]",
+		"app.example.com A [10.0.0.1]",
+		"pod-0.app.example.com A [10.0.0.1]",
+	)
+	m.Stop()
+}
+
+func TestPodIPTimeoutReportedOnce(t *testing.T) {
+	zones := memory.New("example.com", "", "", "")
+	pod := newPod("pod-0", "app", "")
+	m := newTestManager(t, zones, pod)
+	m.timeout = time.Millisecond
+	recorder := m.recorder.(*record.FakeRecorder)
+	key := podID(pod)
+
+	for i := 0; i < 3; i++ {
+		if err := m.sync(key); err != nil {
+			t.Fatal(err)
+		}
+		time.Sleep(2 * m.timeout)
+	}
+
+	// Event is recorded on the PrivateDNS resource and on the pod
+	for i := 0; i < 2; i++ {
+		if event := <-recorder.Events; !strings.Contains(event, reasonPodIPTimeout) {
+			t.Fatalf("expected %s event, got %s", reasonPodIPTimeout, event)
+		}
+	}
+	if len(recorder.Events) != 0 {
+		t.Fatalf("expected timeout to be reported once, got %d more events", len(recorder.Events))
+	}
+}
+
+func TestDryRun(t *testing.T) {
+	zones := memory.New("example.com", "", "", "")
+	m := newTestManager(t, zones, newPod("pod-0", "app", "10.0.0.1"))
+	m.dryRun = true
+	recorder := m.recorder.(*record.FakeRecorder)
+
+	published := []dnsAPI.PublishedRecord{{Name: "pod-9.app.example.com", IP: "10.0.0.9"}}
+	_, err := m.crdClient.TanelmaeV1().PrivateDNS("default").Create(&dnsAPI.PrivateDNS{
+		ObjectMeta: metav1.ObjectMeta{Name: "app", Namespace: "default"},
+		Status:     dnsAPI.PrivateDNSStatus{Records: published},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := m.sync("default/pod-0"); err != nil {
+		t.Fatal(err)
+	}
+	assertValues(t, listRecords(t, zones))
+
+	if _, ok := m.status.get("default/pod-0"); !ok {
+		t.Fatal("expected record to be in the status")
+	}
+	if event := <-recorder.Events; !strings.Contains(event, "Dry run: Published pod-0.app.example.com") {
+		t.Fatalf("expected dry run event, got %s", event)
+	}
+
+	// Records that were never written are only planned
+	res, err := m.crdClient.TanelmaeV1().PrivateDNS("default").Get("app", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprint(res.Status.Records) != fmt.Sprint(published) {
+		t.Fatalf("expected published records to stay %v, got %v", published, res.Status.Records)
+	}
+	if len(res.Status.PlannedRecords) != 1 || res.Status.PlannedRecords[0].Name != "pod-0.app.example.com" {
+		t.Fatalf("expected pod-0 record to be planned, got %v", res.Status.PlannedRecords)
+	}
+}
+
+func TestHostnameTemplates(t *testing.T) {
+	zones := memory.New("example.com", "", "", "")
+	pod := newPod("pod-0", "app", "10.0.0.1")
+	pod.Labels = map[string]string{"role": "db"}
+	m := newTestManager(t, zones, pod)
+
+	hostnames, err := hostname.Parse("example.com",
+		"{{.PodName}}.{{.Namespace}}", `{{index .Labels "role"}}.{{.Namespace}}`, "_{{.SRVPort}}._{{.SRVProto}}.{{.Namespace}}")
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.hostnames = hostnames
+
+	if err := m.sync(podID(pod)); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		"_http._tcp.default.example.com SRV [0 0 8080 pod-0.default.example.com.]",
+		"db.default.example.com A [10.0.0.1]",
+		"pod-0.default.example.com A [10.0.0.1]",
+	}
+	assertValues(t, listRecords(t, zones), want...)
+
+	// Reconciliation finds the same names
+	m.reconcile()
+	assertValues(t, listRecords(t, zones), want...)
+
+	// Pod without the label has no valid name
+	other := newPod("pod-1", "app", "10.0.0.2")
+	if err := m.store.Add(other); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.sync(podID(other)); err != nil {
+		t.Fatal(err)
+	}
+	assertValues(t, listRecords(t, zones), want...)
+
+	if err := m.store.Delete(pod); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.sync(podID(pod)); err != nil {
+		t.Fatal(err)
+	}
+	assertValues(t, listRecords(t, zones))
+}
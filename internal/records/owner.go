@@ -0,0 +1,104 @@
+package records
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// Controllers are followed up to this many levels
+const maxOwnerDepth = 5
+
+// owner is the top-level controller of a pod
+type owner struct {
+	kind string
+	name string
+}
+
+// owners caches the controllers of the intermediate owners like ReplicaSets and Jobs.
+// Shared between the copies of Manager.
+type owners struct {
+	mu          sync.Mutex
+	controllers map[string]*metav1.OwnerReference
+}
+
+func newOwners() *owners {
+	return &owners{controllers: make(map[string]*metav1.OwnerReference)}
+}
+
+// hasOwner tells if the records of the pod can be named.
+// Pods without a controller need the fallback owner name.
+func (m Manager) hasOwner(pod *v1.Pod) bool {
+	return metav1.GetControllerOf(pod) != nil || m.ownerFallback != ""
+}
+
+// ownerOf resolves the top-level controller of the pod by following the owner
+// references marked as controller, like ReplicaSet to Deployment and Job to CronJob.
+// Pods without a controller get the fallback owner name.
+func (m Manager) ownerOf(pod *v1.Pod) (owner, error) {
+	ref := metav1.GetControllerOf(pod)
+	if ref == nil {
+		if m.ownerFallback == "" {
+			return owner{}, fmt.Errorf("Pod %s has no controller", podID(pod))
+		}
+		return owner{name: m.ownerFallback}, nil
+	}
+
+	for i := 0; i < maxOwnerDepth; i++ {
+		parent, err := m.owners.controllerOf(m.kubeClient, pod.GetNamespace(), ref)
+		if err != nil {
+			return owner{}, err
+		}
+		if parent == nil {
+			break
+		}
+		ref = parent
+	}
+	return owner{kind: ref.Kind, name: ref.Name}, nil
+}
+
+// controllerOf returns the controller of the owner. Nil is returned
+// for the owners that are not known to have a controller or are gone.
+func (o *owners) controllerOf(client kubernetes.Interface, namespace string,
+	ref *metav1.OwnerReference) (*metav1.OwnerReference, error) {
+
+	group := strings.Split(ref.APIVersion, "/")[0]
+	key := fmt.Sprintf("%s/%s/%s/%s", group, ref.Kind, namespace, ref.Name)
+
+	o.mu.Lock()
+	parent, cached := o.controllers[key]
+	o.mu.Unlock()
+	if cached {
+		return parent, nil
+	}
+
+	var meta metav1.Object
+	var err error
+	switch {
+	case group == "apps" && ref.Kind == "ReplicaSet":
+		meta, err = client.AppsV1().ReplicaSets(namespace).Get(ref.Name, metav1.GetOptions{})
+	case group == "batch" && ref.Kind == "Job":
+		meta, err = client.BatchV1().Jobs(namespace).Get(ref.Name, metav1.GetOptions{})
+	default:
+		return nil, nil
+	}
+
+	switch {
+	case errors.IsNotFound(err):
+		// Pods of a removed owner are named by the owner itself
+		return nil, nil
+	case err != nil:
+		return nil, fmt.Errorf("Failed to get %s %s/%s: %s", ref.Kind, namespace, ref.Name, err)
+	}
+
+	parent = metav1.GetControllerOf(meta)
+	o.mu.Lock()
+	o.controllers[key] = parent
+	o.mu.Unlock()
+	return parent, nil
+}
@@ -0,0 +1,93 @@
+package records
+
+import (
+	"testing"
+
+	"github.com/tanelmae/private-dns/pkg/memory"
+	appsv1 "k8s.io/api/apps/v1"
+	batchv1 "k8s.io/api/batch/v1"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	kubefake "k8s.io/client-go/kubernetes/fake"
+)
+
+func TestOwnerOf(t *testing.T) {
+	rs := &appsv1.ReplicaSet{ObjectMeta: metav1.ObjectMeta{
+		Name:            "web-5d8f9c7b6",
+		Namespace:       "default",
+		OwnerReferences: []metav1.OwnerReference{controllerRef("apps/v1", "Deployment", "web")},
+	}}
+	job := &batchv1.Job{ObjectMeta: metav1.ObjectMeta{
+		Name:            "backup-1602806400",
+		Namespace:       "default",
+		OwnerReferences: []metav1.OwnerReference{controllerRef("batch/v1beta1", "CronJob", "backup")},
+	}}
+	bareRS := &appsv1.ReplicaSet{ObjectMeta: metav1.ObjectMeta{Name: "bare", Namespace: "default"}}
+
+	withOwners := func(refs ...metav1.OwnerReference) *v1.Pod {
+		pod := newPod("pod-0", "", "10.0.0.1")
+		pod.OwnerReferences = refs
+		return pod
+	}
+	notController := metav1.OwnerReference{APIVersion: "v1", Kind: "ConfigMap", Name: "config"}
+
+	tests := []struct {
+		name     string
+		pod      *v1.Pod
+		fallback string
+		want     owner
+		err      bool
+	}{
+		{"statefulset", newPod("pod-0", "db", "10.0.0.1"), "", owner{"StatefulSet", "db"}, false},
+		{"deployment", withOwners(controllerRef("apps/v1", "ReplicaSet", rs.Name)), "", owner{"Deployment", "web"}, false},
+		{"cronjob", withOwners(controllerRef("batch/v1", "Job", job.Name)), "", owner{"CronJob", "backup"}, false},
+		{"replicaset without owner", withOwners(controllerRef("apps/v1", "ReplicaSet", "bare")), "", owner{"ReplicaSet", "bare"}, false},
+		{"removed replicaset", withOwners(controllerRef("apps/v1", "ReplicaSet", "gone")), "", owner{"ReplicaSet", "gone"}, false},
+		{"controller not first", withOwners(notController, controllerRef("apps/v1", "DaemonSet", "agent")), "", owner{"DaemonSet", "agent"}, false},
+		{"no controller", withOwners(notController), "standalone", owner{"", "standalone"}, false},
+		{"bare pod", withOwners(), "standalone", owner{"", "standalone"}, false},
+		{"bare pod without fallback", withOwners(), "", owner{}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := newTestManager(t, memory.New("example.com", "", "", ""))
+			m.kubeClient = kubefake.NewSimpleClientset(rs, job, bareRS)
+			m.ownerFallback = tt.fallback
+
+			got, err := m.ownerOf(tt.pod)
+			if tt.err != (err != nil) {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got != tt.want {
+				t.Fatalf("expected %+v, got %+v", tt.want, got)
+			}
+			if m.hasOwner(tt.pod) == tt.err {
+				t.Fatalf("expected hasOwner to be %t", !tt.err)
+			}
+		})
+	}
+}
+
+func TestSyncBarePod(t *testing.T) {
+	zones := memory.New("example.com", "", "", "")
+	pod := newPod("pod-0", "", "10.0.0.1")
+	pod.OwnerReferences = nil
+	m := newTestManager(t, zones, pod)
+	m.service = false
+
+	// Ignored without the fallback
+	if err := m.sync(podID(pod)); err != nil {
+		t.Fatal(err)
+	}
+	assertValues(t, listRecords(t, zones))
+
+	m.ownerFallback = "standalone"
+	if err := m.sync(podID(pod)); err != nil {
+		t.Fatal(err)
+	}
+	assertValues(t, listRecords(t, zones),
+		"_http._tcp.example.com SRV [0 0 8080 pod-0.standalone.example.com.]",
+		"pod-0.standalone.example.com A [10.0.0.1]",
+	)
+}
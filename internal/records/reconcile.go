@@ -0,0 +1,285 @@
+package records
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/tanelmae/private-dns/internal/metrics"
+	"github.com/tanelmae/private-dns/internal/pdns"
+	dnsAPI "github.com/tanelmae/private-dns/pkg/apis/privatedns/v1"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/klog/v2"
+)
+
+// desiredRecords are the records expected to exist for the pods in the informer store
+type desiredRecords struct {
+	published map[string]dnsAPI.PublishedRecord
+	pods      map[string]map[string]bool
+	services  map[string]map[string]bool
+	targets   map[string]map[string]pdns.SRV
+}
+
+func (m Manager) desiredRecords() desiredRecords {
+	d := desiredRecords{
+		published: make(map[string]dnsAPI.PublishedRecord),
+		pods:      make(map[string]map[string]bool),
+		services:  make(map[string]map[string]bool),
+		targets:   make(map[string]map[string]pdns.SRV),
+	}
+
+	for _, i := range m.store.List() {
+		pod := i.(*v1.Pod)
+		ips := podIPs(pod)
+		if len(ips) == 0 || !m.hasOwner(pod) {
+			continue
+		}
+
+		n, err := m.names(pod)
+		if err != nil {
+			klog.V(2).Infof("Not reconciling records of pod %s: %s\n", podID(pod), err)
+			continue
+		}
+		r := n.record(ips)
+		d.published[podID(pod)] = r
+
+		name := strings.ToLower(r.Name)
+		d.pods[name] = make(map[string]bool)
+		for _, ip := range ips {
+			d.pods[name][ip] = true
+		}
+
+		if m.service {
+			service := strings.ToLower(r.Service)
+			if d.services[service] == nil {
+				d.services[service] = make(map[string]bool)
+			}
+			for _, ip := range ips {
+				d.services[service][ip] = true
+			}
+		}
+
+		if m.srvEnabled() && r.SRV != "" {
+			srv := strings.ToLower(r.SRV)
+			if d.targets[srv] == nil {
+				d.targets[srv] = make(map[string]pdns.SRV)
+			}
+			d.targets[srv][name] = n.target
+		}
+	}
+	return d
+}
+
+func (m Manager) srvEnabled() bool {
+	return m.srvProto != "" && m.srvPort != ""
+}
+
+// reconcile compares the records in the DNS zone with the pods in the informer store.
+// Missing records are added and stale records removed with a single request.
+// Only the records the ownership registry of the provider has for this PrivateDNS resource
+// are removed so the records of other resources and the ones written by hand are left alone.
+// Provider has to be able to list the zone and keep the record ownership.
+func (m Manager) reconcile() {
+	// Informer store is incomplete until the initial list has been handled
+	if !m.controller.HasSynced() {
+		return
+	}
+	klog.V(2).Infof("Reconciling %s/%s records\n", m.namespace, m.name)
+
+	// Workers may publish records while reconciling
+	before := m.status.all()
+	desired := m.desiredRecords()
+
+	actual, err := m.dnsClient.(pdns.RecordLister).ListRecords(m.domain)
+	if err != nil {
+		klog.Errorf("Failed to list %s records: %s\n", m.domain, err)
+		m.updateStatus(err)
+		return
+	}
+
+	owned, err := m.ownedRecords()
+	if err != nil {
+		klog.Errorf("Failed to list %s/%s owned records: %s\n", m.namespace, m.name, err)
+		m.updateStatus(err)
+		return
+	}
+
+	req := m.newRequest()
+	changes := m.addMissing(req, desired, recordValues(actual))
+	changes += m.removeStale(req, desired, owned)
+	if changes == 0 {
+		klog.V(2).Infof("%s/%s records are in sync\n", m.namespace, m.name)
+		m.status.reconciled(before, desired.published)
+		m.updateStatus(nil)
+		return
+	}
+	klog.Infof("Repairing %d %s/%s records\n", changes, m.namespace, m.name)
+	metrics.AddDrift(m.namespace, m.name, changes)
+
+	err = req.Do()
+	if err != nil {
+		klog.Errorf("Failed to reconcile %s/%s records: %s\n", m.namespace, m.name, err)
+		m.eventf(nil, v1.EventTypeWarning, reasonProviderError,
+			"Failed to reconcile %s/%s records: %s", m.namespace, m.name, err)
+	} else {
+		// Records of the removed pods are gone now
+		m.status.reconciled(before, desired.published)
+		m.eventf(nil, v1.EventTypeNormal, reasonRecordsRepaired,
+			"Repaired %d %s/%s records", changes, m.namespace, m.name)
+	}
+	m.updateStatus(err)
+}
+
+// canReconcile tells if the provider can list the zone and keeps the record ownership.
+// Without listing the stale records can't be found and every record
+// would be added again on each reconciliation. Without the ownership
+// the stale records can't be told apart from the records of other resources.
+func (m Manager) canReconcile() bool {
+	_, ok := m.dnsClient.(pdns.RecordLister)
+	registry, owns := m.dnsClient.(pdns.OwnerRegistry)
+	return ok && owns && registry.OwnerID() != ""
+}
+
+// ownedRecords returns the records the ownership registry of the provider has for this PrivateDNS resource
+func (m Manager) ownedRecords() ([]pdns.OwnedRecord, error) {
+	registry, ok := m.dnsClient.(pdns.OwnerRegistry)
+	if !ok || registry.OwnerID() == "" {
+		return nil, fmt.Errorf("DNS provider doesn't keep the record ownership")
+	}
+
+	records, err := registry.OwnedRecords()
+	if err != nil {
+		return nil, err
+	}
+
+	owned := []pdns.OwnedRecord{}
+	for _, r := range records {
+		if r.Owner.Namespace == m.namespace && r.Owner.Name == m.name {
+			owned = append(owned, r)
+		}
+	}
+	return owned, nil
+}
+
+// addMissing adds the desired records that are not in the existing records
+func (m Manager) addMissing(req pdns.DNSRequest, desired desiredRecords, existing existingRecords) int {
+	changes := 0
+
+	for name, ips := range desired.pods {
+		for ip := range ips {
+			if existing.has(name, pdns.AddressType(ip), ip) {
+				continue
+			}
+			req.AddRecord(name, ip)
+			changes++
+		}
+	}
+
+	for name, ips := range desired.services {
+		for ip := range ips {
+			if existing.has(name, pdns.AddressType(ip), ip) {
+				continue
+			}
+			req.AddToService(name, ip)
+			changes++
+		}
+	}
+
+	for srv, targets := range desired.targets {
+		for _, data := range targets {
+			if existing.hasSRV(srv, data) {
+				continue
+			}
+			req.AddToSRV(srv, data)
+			changes++
+		}
+	}
+	return changes
+}
+
+// removeStale removes the owned records that don't belong to any pod in the informer store.
+// SRV record may be shared with other resources so only its targets this manager has added are removed.
+func (m Manager) removeStale(req pdns.DNSRequest, desired desiredRecords, owned []pdns.OwnedRecord) int {
+	changes := 0
+	targets := ownedTargets(owned, m.status.list())
+
+	for _, rec := range owned {
+		name := strings.ToLower(rec.Name)
+
+		for _, value := range rec.Values {
+			stale := false
+			switch {
+			case rec.Owner.Kind == pdns.KindPod && pdns.IsAddressType(rec.Type):
+				stale = !desired.pods[name][value]
+			case rec.Owner.Kind == pdns.KindService && pdns.IsAddressType(rec.Type) && m.service:
+				stale = !desired.services[name][value]
+			case rec.Owner.Kind == pdns.KindSRV && m.srvEnabled():
+				target := pdns.SRVTarget(value)
+				stale = targets[target] && !hasTarget(desired.targets[name], target)
+			}
+
+			if stale && pdns.RemoveOwned(req, rec, value) {
+				changes++
+			}
+		}
+	}
+	return changes
+}
+
+// existingRecords maps "name|type" to the record set values
+type existingRecords map[string][]string
+
+func recordValues(records []pdns.Record) existingRecords {
+	existing := existingRecords{}
+	for _, r := range records {
+		key := fmt.Sprintf("%s|%s", strings.ToLower(r.Name), r.Type)
+		existing[key] = append(existing[key], r.Values...)
+	}
+	return existing
+}
+
+func (e existingRecords) has(name, recType, value string) bool {
+	for _, v := range e[fmt.Sprintf("%s|%s", strings.ToLower(name), recType)] {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// hasSRV checks if the SRV record has the target with the same priority, weight and port
+func (e existingRecords) hasSRV(srv string, data pdns.SRV) bool {
+	for _, v := range e[fmt.Sprintf("%s|SRV", strings.ToLower(srv))] {
+		if existing, ok := pdns.ParseSRV(v); ok && existing.Same(data) {
+			return true
+		}
+	}
+	return false
+}
+
+// ownedTargets returns the names this manager may have added to the SRV records:
+// the owned pod and service records and the published ones
+func ownedTargets(owned []pdns.OwnedRecord, published []dnsAPI.PublishedRecord) map[string]bool {
+	targets := make(map[string]bool)
+	for _, r := range owned {
+		if r.Owner.Kind == pdns.KindPod || r.Owner.Kind == pdns.KindService {
+			targets[strings.ToLower(r.Name)] = true
+		}
+	}
+	for _, r := range published {
+		targets[strings.ToLower(r.Name)] = true
+		targets[strings.ToLower(serviceName(r))] = true
+	}
+	return targets
+}
+
+func hasTarget(targets map[string]pdns.SRV, target string) bool {
+	_, ok := targets[target]
+	return ok
+}
+
+func parent(name string) string {
+	if i := strings.Index(name, "."); i > 0 {
+		return name[i+1:]
+	}
+	return ""
+}
@@ -0,0 +1,224 @@
+package records
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"testing"
+
+	"github.com/tanelmae/private-dns/internal/hostname"
+	"github.com/tanelmae/private-dns/internal/pdns"
+	dnsAPI "github.com/tanelmae/private-dns/pkg/apis/privatedns/v1"
+	"github.com/tanelmae/private-dns/pkg/gen/clientset/privatedns/fake"
+	"github.com/tanelmae/private-dns/pkg/memory"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	kubefake "k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/record"
+)
+
+// syncedController pretends the initial pod list has been handled
+type syncedController struct{}
+
+func (syncedController) Run(stopCh <-chan struct{})      {}
+func (syncedController) HasSynced() bool                 { return true }
+func (syncedController) LastSyncResourceVersion() string { return "" }
+
+func newTestManager(t *testing.T, provider pdns.DNSProvider, pods ...*v1.Pod) Manager {
+	store := cache.NewStore(cache.MetaNamespaceKeyFunc)
+	objects := []runtime.Object{}
+	for _, pod := range pods {
+		if err := store.Add(pod); err != nil {
+			t.Fatal(err)
+		}
+		objects = append(objects, pod)
+	}
+
+	hostnames, err := hostname.Parse("example.com", "", "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return Manager{
+		name:       "app",
+		namespace:  "default",
+		domain:     "example.com",
+		srvPort:    "http",
+		srvProto:   "tcp",
+		service:    true,
+		hostnames:  hostnames,
+		owners:     newOwners(),
+		kubeClient: kubefake.NewSimpleClientset(objects...),
+		crdClient:  fake.NewSimpleClientset(),
+		dnsClient:  provider,
+		recorder:   record.NewFakeRecorder(100),
+		queue:      newQueue("default/app"),
+		pendingIP:  newPending(),
+		stopChan:   make(chan struct{}),
+		stopOnce:   &sync.Once{},
+		status:     newStatus(),
+		store:      store,
+		controller: syncedController{},
+	}
+}
+
+func newPod(name, owner, ip string) *v1.Pod {
+	return &v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:            name,
+			Namespace:       "default",
+			OwnerReferences: []metav1.OwnerReference{controllerRef("apps/v1", "StatefulSet", owner)},
+		},
+		Spec: v1.PodSpec{
+			Containers: []v1.Container{{Ports: []v1.ContainerPort{{Name: "http", ContainerPort: 8080}}}},
+		},
+		Status: v1.PodStatus{PodIP: ip},
+	}
+}
+
+// resourceRequest creates a request writing the records of the given PrivateDNS resource
+func resourceRequest(provider pdns.DNSProvider, name string) pdns.DNSRequest {
+	req := provider.NewRequest()
+	req.(pdns.OwnedRequest).SetResource("default", name)
+	return req
+}
+
+func controllerRef(apiVersion, kind, name string) metav1.OwnerReference {
+	controller := true
+	return metav1.OwnerReference{APIVersion: apiVersion, Kind: kind, Name: name, Controller: &controller}
+}
+
+func listRecords(t *testing.T, lister pdns.RecordLister) []string {
+	t.Helper()
+	records, err := lister.ListRecords("example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	values := []string{}
+	for _, r := range records {
+		sort.Strings(r.Values)
+		values = append(values, fmt.Sprintf("%s %s %v", r.Name, r.Type, r.Values))
+	}
+	sort.Strings(values)
+	return values
+}
+
+func assertValues(t *testing.T, got []string, want ...string) {
+	t.Helper()
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Fatalf("expected %v, got %v", want, got)
+	}
+}
+
+func TestReconcile(t *testing.T) {
+	zones := memory.New("example.com", "", "", "")
+
+	// Zone has drifted from the pods
+	req := resourceRequest(zones, "app")
+	req.AddRecord("pod-0.app.example.com", "10.0.0.9")
+	req.AddRecord("pod-2.app.example.com", "10.0.0.3")
+	req.AddToService("app.example.com", "10.0.0.3")
+	req.AddToSRV("_http._tcp.example.com", pdns.SRV{Priority: 1, Target: "pod-2.app.example.com"})
+	if err := req.Do(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Records of other resources and written by hand under the same names
+	req = resourceRequest(zones, "other")
+	req.AddRecord("pod-0.other.example.com", "10.0.1.1")
+	req.AddRecord("pod-5.app.example.com", "10.0.1.5")
+	req.AddToSRV("_http._tcp.example.com", pdns.SRV{Priority: 1, Target: "pod-0.other.example.com"})
+	if err := req.Do(); err != nil {
+		t.Fatal(err)
+	}
+	req = zones.NewRequest()
+	req.AddRecord("pod-9.app.example.com", "10.0.0.9")
+	if err := req.Do(); err != nil {
+		t.Fatal(err)
+	}
+
+	m := newTestManager(t, zones,
+		newPod("pod-0", "app", "10.0.0.1"),
+		newPod("pod-1", "app", "10.0.0.2"),
+		newPod("pod-3", "app", ""),
+	)
+	m.reconcile()
+
+	assertValues(t, listRecords(t, zones),
+		"_http._tcp.example.com SRV [0 0 8080 pod-0.app.example.com. 0 0 8080 pod-1.app.example.com. 1 0 0 pod-0.other.example.com.]",
+		"app.example.com A [10.0.0.1 10.0.0.2]",
+		"pod-0.app.example.com A [10.0.0.1]",
+		"pod-0.other.example.com A [10.0.1.1]",
+		"pod-1.app.example.com A [10.0.0.2]",
+		"pod-5.app.example.com A [10.0.1.5]",
+		"pod-9.app.example.com A [10.0.0.9]",
+	)
+}
+
+func TestReconcileRemovedOwner(t *testing.T) {
+	zones := memory.New("example.com", "", "", "")
+
+	// Records published for pods that are gone by now
+	req := resourceRequest(zones, "app")
+	req.AddRecord("pod-0.gone.example.com", "10.0.0.1")
+	if err := req.Do(); err != nil {
+		t.Fatal(err)
+	}
+	req = resourceRequest(zones, "other")
+	req.AddRecord("pod-0.other.example.com", "10.0.1.1")
+	if err := req.Do(); err != nil {
+		t.Fatal(err)
+	}
+
+	m := newTestManager(t, zones)
+	m.status.published("default/pod-0", dnsAPI.PublishedRecord{Name: "pod-0.gone.example.com", IP: "10.0.0.1"})
+	m.reconcile()
+
+	assertValues(t, listRecords(t, zones), "pod-0.other.example.com A [10.0.1.1]")
+
+	if records := m.status.list(); len(records) != 0 {
+		t.Fatalf("expected removed records to be dropped from status, got %v", records)
+	}
+}
+
+func TestReconcileNeedsOwnership(t *testing.T) {
+	zones := memory.New("example.com", "", "", "")
+	if m := newTestManager(t, zones); !m.canReconcile() {
+		t.Fatal("expected provider keeping the ownership to be reconciled")
+	}
+
+	provider := struct {
+		pdns.DNSProvider
+		pdns.RecordLister
+	}{zones, zones}
+	if m := newTestManager(t, provider); m.canReconcile() {
+		t.Fatal("expected provider without the ownership not to be reconciled")
+	}
+}
+
+func TestReconciledKeepsWorkerChanges(t *testing.T) {
+	s := newStatus()
+	s.published("default/pod-0", dnsAPI.PublishedRecord{Name: "pod-0.app.example.com", IP: "10.0.0.1"})
+	s.published("default/pod-1", dnsAPI.PublishedRecord{Name: "pod-1.app.example.com", IP: "10.0.0.2"})
+	s.published("default/gone", dnsAPI.PublishedRecord{Name: "gone.app.example.com", IP: "10.0.0.9"})
+	before := s.all()
+
+	// Workers change the records while reconciling
+	s.published("default/pod-0", dnsAPI.PublishedRecord{Name: "pod-0.app.example.com", IP: "10.0.0.3"})
+	s.published("default/pod-2", dnsAPI.PublishedRecord{Name: "pod-2.app.example.com", IP: "10.0.0.4"})
+	s.removed("default/pod-1")
+
+	s.reconciled(before, map[string]dnsAPI.PublishedRecord{
+		"default/pod-0": {Name: "pod-0.app.example.com", IP: "10.0.0.1"},
+		"default/pod-1": {Name: "pod-1.app.example.com", IP: "10.0.0.2"},
+	})
+
+	got := []string{}
+	for _, r := range s.list() {
+		got = append(got, fmt.Sprintf("%s %s", r.Name, r.IP))
+	}
+	assertValues(t, got, "pod-0.app.example.com 10.0.0.3", "pod-2.app.example.com 10.0.0.4")
+}
@@ -0,0 +1,78 @@
+package records
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/tanelmae/private-dns/internal/pdns"
+	v1 "k8s.io/api/core/v1"
+)
+
+// Pod annotations overriding the SRV priority and weight of the PrivateDNS resource.
+// Lower priority is preferred, e.g. for the pods in the same zone as the clients.
+const (
+	srvPriorityAnnotation = "tanelmae.com/srv-priority"
+	srvWeightAnnotation   = "tanelmae.com/srv-weight"
+)
+
+// srvData returns the SRV record data pointing to the pod record.
+// Port is the container port with the SRV port name. Numeric SRV port is used as it is.
+func (m Manager) srvData(pod *v1.Pod, target string) (pdns.SRV, error) {
+	port, err := m.srvPortOf(pod)
+	if err != nil {
+		return pdns.SRV{}, err
+	}
+
+	priority, err := annotationValue(pod, srvPriorityAnnotation, m.srvPriority)
+	if err != nil {
+		return pdns.SRV{}, err
+	}
+
+	weight, err := annotationValue(pod, srvWeightAnnotation, m.srvWeight)
+	if err != nil {
+		return pdns.SRV{}, err
+	}
+
+	return pdns.SRV{Priority: priority, Weight: weight, Port: port, Target: strings.ToLower(target)}, nil
+}
+
+func (m Manager) srvPortOf(pod *v1.Pod) (int, error) {
+	for _, c := range pod.Spec.Containers {
+		for _, p := range c.Ports {
+			if p.Name == m.srvPort {
+				return int(p.ContainerPort), nil
+			}
+		}
+	}
+
+	if port, err := strconv.ParseUint(m.srvPort, 10, 16); err == nil && port > 0 {
+		return int(port), nil
+	}
+	return 0, fmt.Errorf("no container port named %s", m.srvPort)
+}
+
+// annotationValue returns the SRV priority or weight from the pod annotation
+// or the given default when the pod has no such annotation
+func annotationValue(pod *v1.Pod, key string, defaultValue int) (int, error) {
+	value, ok := pod.GetAnnotations()[key]
+	if !ok {
+		return defaultValue, nil
+	}
+
+	n, err := strconv.ParseUint(strings.TrimSpace(value), 10, 16)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s annotation %q: has to be 0-65535", key, value)
+	}
+	return int(n), nil
+}
+
+// srvAnnotationsChanged tells if the pod annotations overriding the SRV data have changed
+func srvAnnotationsChanged(oldPod, pod *v1.Pod) bool {
+	for _, key := range []string{srvPriorityAnnotation, srvWeightAnnotation} {
+		if oldPod.GetAnnotations()[key] != pod.GetAnnotations()[key] {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,96 @@
+package records
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/tanelmae/private-dns/pkg/memory"
+	"k8s.io/client-go/tools/record"
+)
+
+func TestSRVData(t *testing.T) {
+	zones := memory.New("example.com", "", "", "")
+	local := newPod("pod-1", "app", "10.0.0.2")
+	local.Annotations = map[string]string{srvPriorityAnnotation: "0", srvWeightAnnotation: "20"}
+	m := newTestManager(t, zones, newPod("pod-0", "app", "10.0.0.1"), local)
+	m.srvPriority = 10
+	m.srvWeight = 5
+
+	for _, key := range []string{"default/pod-0", "default/pod-1"} {
+		if err := m.sync(key); err != nil {
+			t.Fatal(err)
+		}
+	}
+	assertValues(t, listRecords(t, zones),
+		"_http._tcp.example.com SRV [0 20 8080 pod-1.app.example.com. 10 5 8080 pod-0.app.example.com.]",
+		"app.example.com A [10.0.0.1 10.0.0.2]",
+		"pod-0.app.example.com A [10.0.0.1]",
+		"pod-1.app.example.com A [10.0.0.2]",
+	)
+
+	// Changed annotation replaces the SRV target data
+	local = newPod("pod-1", "app", "10.0.0.2")
+	local.Annotations = map[string]string{srvPriorityAnnotation: "20"}
+	if err := m.store.Update(local); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.sync("default/pod-1"); err != nil {
+		t.Fatal(err)
+	}
+	assertValues(t, listRecords(t, zones),
+		"_http._tcp.example.com SRV [10 5 8080 pod-0.app.example.com. 20 5 8080 pod-1.app.example.com.]",
+		"app.example.com A [10.0.0.1 10.0.0.2]",
+		"pod-0.app.example.com A [10.0.0.1]",
+		"pod-1.app.example.com A [10.0.0.2]",
+	)
+}
+
+func TestSRVNumericPort(t *testing.T) {
+	zones := memory.New("example.com", "", "", "")
+	m := newTestManager(t, zones, newPod("pod-0", "app", "10.0.0.1"))
+	m.srvPort = "9000"
+
+	if err := m.sync("default/pod-0"); err != nil {
+		t.Fatal(err)
+	}
+	assertValues(t, listRecords(t, zones),
+		"_9000._tcp.example.com SRV [0 0 9000 pod-0.app.example.com.]",
+		"app.example.com A [10.0.0.1]",
+		"pod-0.app.example.com A [10.0.0.1]",
+	)
+}
+
+func TestSRVInvalid(t *testing.T) {
+	tests := map[string]struct {
+		srvPort     string
+		annotations map[string]string
+	}{
+		"missing port":     {srvPort: "grpc"},
+		"invalid priority": {srvPort: "http", annotations: map[string]string{srvPriorityAnnotation: "high"}},
+		"invalid weight":   {srvPort: "http", annotations: map[string]string{srvWeightAnnotation: "70000"}},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			zones := memory.New("example.com", "", "", "")
+			pod := newPod("pod-0", "app", "10.0.0.1")
+			pod.Annotations = test.annotations
+			m := newTestManager(t, zones, pod)
+			m.srvPort = test.srvPort
+			recorder := m.recorder.(*record.FakeRecorder)
+
+			if err := m.sync("default/pod-0"); err != nil {
+				t.Fatal(err)
+			}
+
+			// Pod records are published without the SRV target
+			assertValues(t, listRecords(t, zones),
+				"app.example.com A [10.0.0.1]",
+				"pod-0.app.example.com A [10.0.0.1]",
+			)
+			if event := <-recorder.Events; !strings.Contains(event, reasonInvalidSRV) {
+				t.Fatalf("expected %s event, got %s", reasonInvalidSRV, event)
+			}
+		})
+	}
+}
@@ -0,0 +1,199 @@
+package records
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/tanelmae/private-dns/internal/metrics"
+	dnsAPI "github.com/tanelmae/private-dns/pkg/apis/privatedns/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/util/retry"
+	"k8s.io/klog/v2"
+)
+
+// status keeps track of the published records.
+// Shared between the copies of Manager.
+type status struct {
+	mu      sync.Mutex
+	records map[string]dnsAPI.PublishedRecord
+}
+
+func newStatus() *status {
+	return &status{records: make(map[string]dnsAPI.PublishedRecord)}
+}
+
+func (s *status) published(podID string, r dnsAPI.PublishedRecord) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.records[podID] = r
+}
+
+func (s *status) removed(podID string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.records, podID)
+}
+
+func (s *status) replace(records map[string]dnsAPI.PublishedRecord) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.records = records
+}
+
+// reconciled replaces the records with the ones found by the reconciliation.
+// Records that workers have changed since the reconciliation started are kept.
+func (s *status) reconciled(before, records map[string]dnsAPI.PublishedRecord) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for podID, r := range s.records {
+		if old, ok := before[podID]; !ok || old != r {
+			continue
+		}
+		if _, ok := records[podID]; !ok {
+			delete(s.records, podID)
+		}
+	}
+
+	for podID, r := range records {
+		current, published := s.records[podID]
+		old, existed := before[podID]
+		if published == existed && current == old {
+			s.records[podID] = r
+		}
+	}
+}
+
+func (s *status) get(podID string) (dnsAPI.PublishedRecord, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	r, ok := s.records[podID]
+	return r, ok
+}
+
+// all returns a copy of the published records by pod
+func (s *status) all() map[string]dnsAPI.PublishedRecord {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	records := make(map[string]dnsAPI.PublishedRecord, len(s.records))
+	for podID, r := range s.records {
+		records[podID] = r
+	}
+	return records
+}
+
+func (s *status) list() []dnsAPI.PublishedRecord {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	list := []dnsAPI.PublishedRecord{}
+	for _, r := range s.records {
+		list = append(list, r)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+	return list
+}
+
+// updateStatus writes the result of the last DNS request to PrivateDNS status
+func (m Manager) updateStatus(reqErr error) {
+	now := metav1.Now()
+
+	ready := condition(dnsAPI.ConditionReady, corev1.ConditionTrue, "RecordsPublished", "")
+	providerError := condition(dnsAPI.ConditionProviderError, corev1.ConditionFalse, "RequestSucceeded", "")
+	podsPending := condition(dnsAPI.ConditionPodsPending, corev1.ConditionFalse, "", "")
+	dryRun := condition(dnsAPI.ConditionDryRun, corev1.ConditionFalse, "ChangesApplied", "")
+	if m.dryRun {
+		dryRun = condition(dnsAPI.ConditionDryRun, corev1.ConditionTrue, "DryRun",
+			"Changes are logged but not made in the DNS provider")
+	}
+
+	if pending := m.pendingIP.count(); pending > 0 {
+		msg := fmt.Sprintf("%d pods are waiting for an IP", pending)
+		ready = condition(dnsAPI.ConditionReady, corev1.ConditionFalse, "PodsPending", msg)
+		podsPending = condition(dnsAPI.ConditionPodsPending, corev1.ConditionTrue, "PodIPMissing", msg)
+	}
+
+	if reqErr != nil {
+		ready = condition(dnsAPI.ConditionReady, corev1.ConditionFalse, "ProviderError", reqErr.Error())
+		providerError = condition(dnsAPI.ConditionProviderError, corev1.ConditionTrue, "RequestFailed", reqErr.Error())
+	}
+	conditions := []dnsAPI.PrivateDNSCondition{ready, providerError, podsPending, dryRun}
+
+	records := m.status.list()
+	client := m.crdClient.TanelmaeV1().PrivateDNS(m.namespace)
+
+	// Metrics of the stopped managers are gone already
+	if !m.stopped() {
+		metrics.SetRecords(m.namespace, m.name, len(records), m.pendingIP.count())
+	}
+
+	err := retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		pdns, err := client.Get(m.name, metav1.GetOptions{})
+		if err != nil {
+			return err
+		}
+
+		// Resource has been changed and a new manager will take over
+		if pdns.Generation != m.generation {
+			return nil
+		}
+
+		pdns.Status.ObservedGeneration = m.generation
+		if m.dryRun {
+			pdns.Status.PlannedRecords = records
+		} else {
+			pdns.Status.Records = records
+			pdns.Status.PlannedRecords = nil
+		}
+		pdns.Status.LastSyncTime = &now
+		for _, c := range conditions {
+			pdns.Status.Conditions = setCondition(pdns.Status.Conditions, c, now)
+		}
+
+		_, err = client.UpdateStatus(pdns)
+		return err
+	})
+
+	if errors.IsNotFound(err) {
+		klog.V(2).Infof("%s/%s is gone. Status not updated.\n", m.namespace, m.name)
+	} else if err != nil {
+		klog.Errorf("Failed to update %s/%s status: %s\n", m.namespace, m.name, err)
+	}
+}
+
+func condition(condType dnsAPI.ConditionType, condStatus corev1.ConditionStatus,
+	reason, message string) dnsAPI.PrivateDNSCondition {
+
+	return dnsAPI.PrivateDNSCondition{
+		Type:    condType,
+		Status:  condStatus,
+		Reason:  reason,
+		Message: message,
+	}
+}
+
+// setCondition replaces the condition of the same type.
+// Transition time is only changed when the condition status changes.
+func setCondition(conditions []dnsAPI.PrivateDNSCondition,
+	c dnsAPI.PrivateDNSCondition, now metav1.Time) []dnsAPI.PrivateDNSCondition {
+
+	for i, old := range conditions {
+		if old.Type != c.Type {
+			continue
+		}
+
+		c.LastTransitionTime = old.LastTransitionTime
+		if old.Status != c.Status {
+			c.LastTransitionTime = now
+		}
+		conditions[i] = c
+		return conditions
+	}
+
+	c.LastTransitionTime = now
+	return append(conditions, c)
+}
@@ -0,0 +1,123 @@
+package service
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/tanelmae/private-dns/internal/records"
+	dnsAPI "github.com/tanelmae/private-dns/pkg/apis/privatedns/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/util/retry"
+	"k8s.io/klog/v2"
+)
+
+const (
+	// finalizer keeps PrivateDNS resource around until all its records are removed
+	finalizer = "tanelmae.com/private-dns"
+
+	cleanupBackoff    = time.Second
+	cleanupBackoffMax = 5 * time.Minute
+)
+
+func hasFinalizer(pdns *dnsAPI.PrivateDNS) bool {
+	for _, f := range pdns.GetFinalizers() {
+		if f == finalizer {
+			return true
+		}
+	}
+	return false
+}
+
+// addFinalizer puts the finalizer on PrivateDNS resource that doesn't have it yet
+func (c *Controller) addFinalizer(pdns *dnsAPI.PrivateDNS) {
+	if hasFinalizer(pdns) {
+		return
+	}
+
+	client := c.crdClient.TanelmaeV1().PrivateDNS(pdns.Namespace)
+	err := retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		latest, err := client.Get(pdns.Name, metav1.GetOptions{})
+		if err != nil {
+			return err
+		}
+		if hasFinalizer(latest) || latest.DeletionTimestamp != nil {
+			return nil
+		}
+
+		latest.SetFinalizers(append(latest.GetFinalizers(), finalizer))
+		_, err = client.Update(latest)
+		return err
+	})
+
+	if err != nil {
+		klog.Errorf("Failed to add finalizer to %s/%s: %s\n", pdns.Namespace, pdns.Name, err)
+	}
+}
+
+// removeFinalizer lets the PrivateDNS resource to be deleted
+func (c *Controller) removeFinalizer(pdns *dnsAPI.PrivateDNS) error {
+	client := c.crdClient.TanelmaeV1().PrivateDNS(pdns.Namespace)
+	err := retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		latest, err := client.Get(pdns.Name, metav1.GetOptions{})
+		if err != nil {
+			return err
+		}
+
+		finalizers := []string{}
+		for _, f := range latest.GetFinalizers() {
+			if f != finalizer {
+				finalizers = append(finalizers, f)
+			}
+		}
+		if len(finalizers) == len(latest.GetFinalizers()) {
+			return nil
+		}
+
+		latest.SetFinalizers(finalizers)
+		_, err = client.Update(latest)
+		return err
+	})
+
+	if errors.IsNotFound(err) {
+		return nil
+	}
+	return err
+}
+
+// finalize removes the records of the PrivateDNS resource that is being deleted
+// and then the finalizer. Failed attempts are retried with exponential backoff
+// until they succeed or the controller is stopped.
+func (c *Controller) finalize(pdns *dnsAPI.PrivateDNS, m *records.Manager, stopChan <-chan struct{}) {
+	regKey := fmt.Sprintf("%s/%s", pdns.ObjectMeta.Name, pdns.ObjectMeta.Namespace)
+	defer func() {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		delete(c.finalizing, regKey)
+	}()
+
+	backoff := cleanupBackoff
+	for {
+		err := m.Cleanup(pdns)
+		if err == nil {
+			err = c.removeFinalizer(pdns)
+		}
+		if err == nil {
+			klog.Infof("Records of %s/%s removed\n", pdns.Namespace, pdns.Name)
+			return
+		}
+
+		klog.Errorf("%s. Will retry in %s.\n", err, backoff)
+		select {
+		case <-stopChan:
+			// Next leader or restarted controller will try again
+			return
+		case <-time.After(backoff):
+		}
+
+		backoff *= 2
+		if backoff > cleanupBackoffMax {
+			backoff = cleanupBackoffMax
+		}
+	}
+}
@@ -0,0 +1,42 @@
+package service
+
+import (
+	"fmt"
+	"time"
+)
+
+// Informer that hasn't listed the PrivateDNS resources in this time is considered stuck
+const informerSyncTimeout = 5 * time.Minute
+
+// InformerReady returns an error until the PrivateDNS informer has synced.
+// Followers are ready without the informer as they only wait for the lease.
+func (c *Controller) InformerReady() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.electing && !c.leader {
+		return nil
+	}
+	if c.crdSynced == nil {
+		return fmt.Errorf("PrivateDNS informer is not running")
+	}
+	if !c.crdSynced() {
+		return fmt.Errorf("PrivateDNS informer has not synced")
+	}
+	return nil
+}
+
+// InformerLive returns an error when the running PrivateDNS informer is stuck
+// and hasn't synced in the sync timeout
+func (c *Controller) InformerLive() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.crdSynced == nil || c.crdSynced() {
+		return nil
+	}
+	if since := time.Since(c.informerStarted); since > informerSyncTimeout {
+		return fmt.Errorf("PrivateDNS informer has not synced in %s", since.Round(time.Second))
+	}
+	return nil
+}
@@ -0,0 +1,119 @@
+package service
+
+import (
+	"context"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/leaderelection"
+	"k8s.io/client-go/tools/leaderelection/resourcelock"
+	"k8s.io/klog/v2"
+)
+
+// LeaderElection configures Lease based leader election between the replicas
+type LeaderElection struct {
+	LeaseName     string
+	Namespace     string
+	Identity      string
+	LeaseDuration time.Duration
+	RenewDeadline time.Duration
+	RetryPeriod   time.Duration
+}
+
+// RunWithLeaderElection starts the private DNS service once this replica holds the lease.
+// Only the leader watches PrivateDNS resources and pods.
+// Process exits when the lease is lost so the replica can rejoin the election as a follower.
+func (c *Controller) RunWithLeaderElection(conf LeaderElection) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	c.mu.Lock()
+	c.electing = true
+	c.mu.Unlock()
+
+	shutdown := make(chan struct{})
+	started := make(chan struct{})
+	stopped := make(chan struct{})
+
+	// waitStopped waits for the pod watchers to stop if this replica has been leading
+	waitStopped := func() {
+		select {
+		case <-started:
+			<-stopped
+		default:
+		}
+	}
+
+	go func() {
+		waitForShutdown()
+		close(shutdown)
+
+		// Lease is released only after the pod watchers have stopped
+		// so the next leader wouldn't overlap with this one.
+		waitStopped()
+		cancel()
+	}()
+
+	lock := &resourcelock.LeaseLock{
+		LeaseMeta: metav1.ObjectMeta{
+			Name:      conf.LeaseName,
+			Namespace: conf.Namespace,
+		},
+		Client: c.kubeClient.CoordinationV1(),
+		LockConfig: resourcelock.ResourceLockConfig{
+			Identity: conf.Identity,
+		},
+	}
+
+	klog.Infof("Waiting for %s/%s lease as %s\n", conf.Namespace, conf.LeaseName, conf.Identity)
+	leaderelection.RunOrDie(ctx, leaderelection.LeaderElectionConfig{
+		Lock:            lock,
+		LeaseDuration:   conf.LeaseDuration,
+		RenewDeadline:   conf.RenewDeadline,
+		RetryPeriod:     conf.RetryPeriod,
+		ReleaseOnCancel: true,
+		Callbacks: leaderelection.LeaderCallbacks{
+			OnStartedLeading: func(leaderCtx context.Context) {
+				klog.Infof("Started leading as %s\n", conf.Identity)
+				c.setLeader(true)
+				close(started)
+
+				stopChan := make(chan struct{})
+				go func() {
+					select {
+					case <-leaderCtx.Done():
+					case <-shutdown:
+					}
+					close(stopChan)
+				}()
+
+				c.runInformer(stopChan)
+				close(stopped)
+			},
+			OnStoppedLeading: func() {
+				klog.Infof("Stopped leading as %s\n", conf.Identity)
+				c.setLeader(false)
+			},
+			OnNewLeader: func(identity string) {
+				if identity != conf.Identity {
+					klog.Infof("Current leader is %s\n", identity)
+				}
+			},
+		},
+	})
+
+	select {
+	case <-shutdown:
+		klog.Infoln("Private DNS service Stopped")
+	default:
+		// Lease could not be renewed
+		waitStopped()
+		klog.Fatalln("Lost the leader lease")
+	}
+}
+
+func (c *Controller) setLeader(leader bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.leader = leader
+}
@@ -3,17 +3,25 @@ package service
 import (
 	"fmt"
 
+	"github.com/tanelmae/private-dns/internal/gc"
+	"github.com/tanelmae/private-dns/internal/hostname"
+	"github.com/tanelmae/private-dns/internal/metrics"
 	"github.com/tanelmae/private-dns/internal/pdns"
 	"github.com/tanelmae/private-dns/internal/records"
 	dnsAPI "github.com/tanelmae/private-dns/pkg/apis/privatedns/v1"
-	"github.com/tanelmae/private-dns/pkg/gcp"
 	"github.com/tanelmae/private-dns/pkg/gen/clientset/privatedns"
 	dnsV1 "github.com/tanelmae/private-dns/pkg/gen/informers/externalversions/privatedns/v1"
 
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/scheme"
+	typedv1 "k8s.io/client-go/kubernetes/typed/core/v1"
 	_ "k8s.io/client-go/plugin/pkg/client/auth/gcp"
 	"k8s.io/client-go/rest"
 	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/record"
 
 	"os"
 	"os/signal"
@@ -27,18 +35,35 @@ import (
 
 const (
 	defaultTimeout = time.Minute * 2
-)
+	component      = "private-dns"
+
+	// dryRunAnnotation set to "true" on PrivateDNS resource only logs its record changes
+	dryRunAnnotation = "tanelmae.com/dry-run"
 
-type close struct{}
+	// Resource that failed on the cluster details is handled again after this
+	managerRetry = 30 * time.Second
+
+	reasonClusterInfoError = "ClusterInfoError"
+)
 
-// New creates a new private DNS Controller
-func New(kubeConf *rest.Config, dnsClient pdns.DNSProvider, namespace string) (*Controller, error) {
+// New creates a new private DNS Controller.
+// Reconcile interval is used for PrivateDNS resources that don't set their own.
+// Workers is the number of pods handled at the same time for each PrivateDNS resource.
+// TTLs are used for the records of PrivateDNS resources that don't set their own.
+// Dry run only logs the record changes of all the PrivateDNS resources.
+func New(kubeConf *rest.Config, dnsClient pdns.DNSProvider, namespace string,
+	reconcileInterval time.Duration, workers int, ttl pdns.TTL, dryRun bool, gcConf gc.Config) (*Controller, error) {
 	var err error
 
 	c := &Controller{
-		dnsClient: dnsClient,
-		res:       make(map[string]*records.Manager),
-		namespace: namespace, // Empty will mean all
+		dnsClient:         dnsClient,
+		res:               make(map[string]*records.Manager),
+		finalizing:        make(map[string]bool),
+		namespace:         namespace, // Empty will mean all
+		reconcileInterval: reconcileInterval,
+		workers:           workers,
+		ttl:               ttl,
+		dryRun:            dryRun,
 	}
 
 	c.kubeClient, err = kubernetes.NewForConfig(kubeConf)
@@ -51,21 +76,69 @@ func New(kubeConf *rest.Config, dnsClient pdns.DNSProvider, namespace string) (*
 		return nil, err
 	}
 
+	// Events of the record changes are written to the PrivateDNS resources and pods
+	broadcaster := record.NewBroadcaster()
+	broadcaster.StartRecordingToSink(&typedv1.EventSinkImpl{Interface: c.kubeClient.CoreV1().Events("")})
+	c.recorder = broadcaster.NewRecorder(scheme.Scheme, v1.EventSource{Component: component})
+
+	if gcConf.Interval > 0 {
+		c.collector, err = gc.New(gcConf, namespace, c.kubeClient, c.crdClient, dnsClient)
+		if err != nil {
+			klog.Warningf("Garbage collection is disabled: %s\n", err)
+		}
+	}
+
 	return c, nil
 }
 
 // Controller is the controller that manages DNS workers based on found CRDs
 type Controller struct {
-	mu         sync.Mutex
-	kubeClient *kubernetes.Clientset
-	crdClient  *privatedns.Clientset
-	dnsClient  pdns.DNSProvider
-	res        map[string]*records.Manager
-	namespace  string
+	mu                sync.Mutex
+	kubeClient        *kubernetes.Clientset
+	crdClient         *privatedns.Clientset
+	dnsClient         pdns.DNSProvider
+	res               map[string]*records.Manager
+	namespace         string
+	reconcileInterval time.Duration
+	workers           int
+	ttl               pdns.TTL
+	dryRun            bool
+	collector         *gc.Collector
+	recorder          record.EventRecorder
+	leader            bool
+
+	// Resources being finalized and the stop channel of the running informer
+	finalizing map[string]bool
+	stopChan   <-chan struct{}
+
+	// Used by the health checks. Sync function is nil when the informer is not running.
+	electing        bool
+	crdSynced       func() bool
+	informerStarted time.Time
 }
 
 // Run starts the private DNS service
 func (c *Controller) Run() {
+	stopChan := make(chan struct{})
+	stopped := make(chan struct{})
+
+	go func() {
+		c.runInformer(stopChan)
+		close(stopped)
+	}()
+
+	waitForShutdown()
+	close(stopChan)
+	<-stopped
+	klog.Infoln("Private DNS service Stopped")
+}
+
+// runInformer watches PrivateDNS resources until stopChan is closed.
+// Pod watchers are stopped after that but the DNS records are left in place.
+func (c *Controller) runInformer(stopChan <-chan struct{}) {
+	c.mu.Lock()
+	c.stopChan = stopChan
+	c.mu.Unlock()
 
 	// client privatedns.Interface, namespace string, resyncPeriod time.Duration, indexers cache.Indexers
 	crdbInformer := dnsV1.NewPrivateDNSInformer(
@@ -83,60 +156,204 @@ func (c *Controller) Run() {
 		},
 	)
 
-	stopChan := make(chan struct{})
-	go crdbInformer.Run(stopChan)
+	if c.collector != nil {
+		go c.collector.Run(stopChan)
+	}
+
+	c.mu.Lock()
+	c.crdSynced = crdbInformer.HasSynced
+	c.informerStarted = time.Now()
+	c.mu.Unlock()
+
+	// Returns once the CRD watcher has stopped
+	crdbInformer.Run(stopChan)
+
+	c.mu.Lock()
+	c.crdSynced = nil
+	c.mu.Unlock()
 
-	c.gracefulShutdownHandler(stopChan)
+	c.stopManagers()
 }
 
-func (c *Controller) gracefulShutdownHandler(stopChan chan struct{}) {
+// stopManagers stops all the pod watchers without deleting the records
+func (c *Controller) stopManagers() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for regKey, m := range c.res {
+		m.Stop()
+		delete(c.res, regKey)
+	}
+	metrics.SetResources(len(c.res))
+}
+
+func waitForShutdown() {
 	done := make(chan os.Signal, 1)
 	signal.Notify(done, os.Interrupt, syscall.SIGINT, syscall.SIGTERM)
 	<-done
+}
+
+// reconcileIntervalFor returns the reconcile interval of the PrivateDNS resource
+func (c *Controller) reconcileIntervalFor(pdns *dnsAPI.PrivateDNS) time.Duration {
+	if pdns.Spec.ReconcileInterval != nil {
+		return pdns.Spec.ReconcileInterval.Duration
+	}
+	return c.reconcileInterval
+}
+
+// ttlFor returns the TTLs of the records of the PrivateDNS resource
+func (c *Controller) ttlFor(res *dnsAPI.PrivateDNS) pdns.TTL {
+	return pdns.TTL{
+		Pod:     res.Spec.TTL.Pod,
+		Service: res.Spec.TTL.Service,
+		SRV:     res.Spec.TTL.SRV,
+		PTR:     res.Spec.TTL.PTR,
+	}.Or(c.ttl)
+}
 
-	// Stop CRD watcher
-	stopChan <- close{}
+// dryRunFor tells if the record changes of the PrivateDNS resource are only logged
+func (c *Controller) dryRunFor(pdns *dnsAPI.PrivateDNS) bool {
+	return c.dryRun || pdns.GetAnnotations()[dryRunAnnotation] == "true"
+}
+
+// clusterInfo returns the cluster details when the DNS provider can resolve them
+func (c *Controller) clusterInfo() (pdns.ClusterInfo, bool) {
+	cluster, ok := c.dnsClient.(pdns.ClusterInfo)
+	return cluster, ok
+}
 
-	// Stop any pod watchers that might be running
-	if len(c.res) > 0 {
-		for _, i := range c.res {
-			i.Stop()
+// newManager creates records manager for the PrivateDNS resource.
+// False is returned when the resource can't be handled.
+func (c *Controller) newManager(pdns *dnsAPI.PrivateDNS) (*records.Manager, bool) {
+	regKey := fmt.Sprintf("%s/%s", pdns.ObjectMeta.Name, pdns.ObjectMeta.Namespace)
+	domain := pdns.Spec.Domain
+	hostnames := pdns.Spec.Hostnames
+	templated := hostnames.Pod != "" || hostnames.Service != "" || hostnames.SRV != ""
+
+	// Cluster details are available to the hostname templates when the provider has them
+	name, location := "", ""
+	if cluster, ok := c.clusterInfo(); ok && (pdns.Spec.Subdomain || templated) {
+		var err error
+		if name, location, err = clusterDetails(cluster); err != nil {
+			klog.Errorf("Failed to get cluster details for %s: %s. Will retry in %s.\n", regKey, err, managerRetry)
+			c.recorder.Eventf(pdns, v1.EventTypeWarning, reasonClusterInfoError,
+				"Failed to get cluster details: %s. Will retry in %s.", err, managerRetry)
+			c.requeue(pdns)
+			return nil, false
 		}
 	}
 
-	time.Sleep(time.Second)
-	klog.Infoln("Private DNS service Stopped")
+	if pdns.Spec.Subdomain {
+		if name == "" {
+			klog.Errorf("Subdomain for %s is not supported by the DNS provider", regKey)
+			return nil, false
+		}
+		domain = fmt.Sprintf("%s.%s.%s", name, location, domain)
+	}
+
+	templates, err := hostname.Parse(domain, hostnames.Pod, hostnames.Service, hostnames.SRV)
+	if err != nil {
+		klog.Errorf("Invalid hostnames for %s: %s", regKey, err)
+		return nil, false
+	}
+	templates.SetCluster(name, location)
+
+	ttl := c.ttlFor(pdns)
+	if err := ttl.Validate(); err != nil {
+		klog.Errorf("Invalid TTL for %s: %s", regKey, err)
+		return nil, false
+	}
+
+	m := records.New(records.Config{
+		Name:              pdns.Name,
+		Namespace:         pdns.GetNamespace(),
+		Generation:        pdns.Generation,
+		UID:               pdns.UID,
+		Label:             pdns.Spec.Label,
+		Domain:            domain,
+		Hostnames:         templates,
+		OwnerFallback:     pdns.Spec.OwnerFallback,
+		Service:           pdns.Spec.Service,
+		TTL:               ttl,
+		SRVPort:           pdns.Spec.SRVPort,
+		SRVProto:          pdns.Spec.SRVProto,
+		SRVPriority:       int(pdns.Spec.SRVPriority),
+		SRVWeight:         int(pdns.Spec.SRVWeight),
+		PodTimeout:        pdns.Spec.PodTimeout,
+		ReconcileInterval: c.reconcileIntervalFor(pdns),
+		Workers:           c.workers,
+		DryRun:            c.dryRunFor(pdns),
+	}, c.kubeClient, c.crdClient, c.dnsClient, c.recorder)
+	return &m, true
 }
 
-func (c *Controller) dnsRequestCreated(obj interface{}) {
-	pdns := obj.(*dnsAPI.PrivateDNS)
-	klog.Infof("%s created in %s namespace", pdns.ObjectMeta.Name, pdns.ObjectMeta.Namespace)
+// clusterDetails returns the cluster name and location for the hostname templates
+func clusterDetails(cluster pdns.ClusterInfo) (string, string, error) {
+	name, err := cluster.ClusterName()
+	if err != nil {
+		return "", "", err
+	}
+	location, err := cluster.ClusterLocation()
+	if err != nil {
+		return "", "", err
+	}
+	return name, location, nil
+}
+
+// requeue handles the PrivateDNS resource again after the retry delay.
+// Nothing is done when the resource has been deleted or changed meanwhile
+// as the informer events take care of it then.
+func (c *Controller) requeue(pdns *dnsAPI.PrivateDNS) {
 	regKey := fmt.Sprintf("%s/%s", pdns.ObjectMeta.Name, pdns.ObjectMeta.Namespace)
+	c.mu.Lock()
+	stopChan := c.stopChan
+	c.mu.Unlock()
+
+	go func() {
+		select {
+		case <-stopChan:
+			return
+		case <-time.After(managerRetry):
+		}
 
-	if pdns.Spec.Subdomain {
-		name, err := gcp.GetClusterName()
-		if err != nil {
-			klog.Fatalln(err)
+		latest, err := c.crdClient.TanelmaeV1().PrivateDNS(pdns.Namespace).Get(pdns.Name, metav1.GetOptions{})
+		if errors.IsNotFound(err) {
+			return
 		}
-		location, err := gcp.GetClusterLocation()
 		if err != nil {
-			klog.Fatalln(err)
+			klog.Errorf("Failed to get %s: %s. Will retry in %s.\n", regKey, err, managerRetry)
+			c.requeue(pdns)
+			return
+		}
+		if latest.Generation != pdns.Generation {
+			return
 		}
-		pdns.Spec.Domain = fmt.Sprintf("%s.%s.%s", name, location, pdns.Spec.Domain)
-	}
-
-	m := records.New(
-		pdns.Name,
-		pdns.Spec.Domain,
-		pdns.Spec.Label,
-		pdns.GetNamespace(),
-		pdns.Spec.SRVPort,
-		pdns.Spec.SRVProto,
-		pdns.Spec.Service,
-		pdns.Spec.PodTimeout,
-		c.kubeClient,
-		c.dnsClient,
-	)
+
+		c.mu.Lock()
+		_, exists := c.res[regKey]
+		c.mu.Unlock()
+		if !exists {
+			c.dnsRequestCreated(latest)
+		}
+	}()
+}
+
+func (c *Controller) dnsRequestCreated(obj interface{}) {
+	pdns := obj.(*dnsAPI.PrivateDNS)
+	klog.Infof("%s created in %s namespace", pdns.ObjectMeta.Name, pdns.ObjectMeta.Namespace)
+	regKey := fmt.Sprintf("%s/%s", pdns.ObjectMeta.Name, pdns.ObjectMeta.Namespace)
+
+	// Deleted while the controller was not running
+	if pdns.DeletionTimestamp != nil {
+		c.startFinalize(pdns)
+		return
+	}
+	c.addFinalizer(pdns)
+
+	m, ok := c.newManager(pdns)
+	if !ok {
+		return
+	}
 
 	c.mu.Lock()
 	defer c.mu.Unlock()
@@ -146,53 +363,106 @@ func (c *Controller) dnsRequestCreated(obj interface{}) {
 		return
 	}
 
-	c.res[regKey] = &m
+	c.res[regKey] = m
+	metrics.SetResources(len(c.res))
 	go m.Start()
 }
 
+// startFinalize stops the pod watcher of the PrivateDNS resource being deleted
+// and starts removing its records unless it is already being done
+func (c *Controller) startFinalize(pdns *dnsAPI.PrivateDNS) {
+	// Without the finalizer the records are removed on the delete event
+	if !hasFinalizer(pdns) {
+		return
+	}
+	regKey := fmt.Sprintf("%s/%s", pdns.ObjectMeta.Name, pdns.ObjectMeta.Namespace)
+
+	c.mu.Lock()
+	if c.finalizing[regKey] {
+		c.mu.Unlock()
+		return
+	}
+	m, exists := c.res[regKey]
+	delete(c.res, regKey)
+	metrics.SetResources(len(c.res))
+	c.mu.Unlock()
+
+	if !exists {
+		var ok bool
+		if m, ok = c.newManager(pdns); !ok {
+			return
+		}
+	}
+	klog.Infof("%s is being deleted in %s namespace", pdns.ObjectMeta.Name, pdns.ObjectMeta.Namespace)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.finalizing[regKey] = true
+	go c.finalize(pdns, m, c.stopChan)
+}
+
 func (c *Controller) dnsRequestDeleted(obj interface{}) {
 	pdns := obj.(*dnsAPI.PrivateDNS)
 	klog.Infof("%s deleted in %s namespace", pdns.ObjectMeta.Name, pdns.ObjectMeta.Namespace)
 
 	regKey := fmt.Sprintf("%s/%s", pdns.ObjectMeta.Name, pdns.ObjectMeta.Namespace)
 	c.mu.Lock()
-	defer c.mu.Unlock()
-
-	if m, exists := c.res[regKey]; exists {
-		m.Destroy()
-		delete(c.res, regKey)
+	m, exists := c.res[regKey]
+	delete(c.res, regKey)
+	metrics.SetResources(len(c.res))
+	c.mu.Unlock()
+
+	// Resources with the finalizer have been cleaned up already.
+	// Records are removed without the lock as the provider calls can take long.
+	if exists {
+		m.Destroy(pdns)
 	}
 }
 
 func (c *Controller) dnsRequestUpdated(old, new interface{}) {
-	pdns := old.(*dnsAPI.PrivateDNS)
+	pdns := new.(*dnsAPI.PrivateDNS)
+
+	if pdns.DeletionTimestamp != nil {
+		c.startFinalize(pdns)
+		return
+	}
+
+	// Status updates don't change the generation. Annotations don't either.
+	oldPDNS := old.(*dnsAPI.PrivateDNS)
+	if oldPDNS.Generation == pdns.Generation && c.dryRunFor(oldPDNS) == c.dryRunFor(pdns) {
+		return
+	}
 	klog.Infof("%s updated in %s namespace", pdns.ObjectMeta.Name, pdns.ObjectMeta.Namespace)
 
+	m, ok := c.newManager(pdns)
+
 	regKey := fmt.Sprintf("%s/%s", pdns.ObjectMeta.Name, pdns.ObjectMeta.Namespace)
 	c.mu.Lock()
-	defer c.mu.Unlock()
-
-	if m, exists := c.res[regKey]; exists {
-		m.Destroy()
-		delete(c.res, regKey)
+	oldManager, exists := c.res[regKey]
+	delete(c.res, regKey)
+	c.mu.Unlock()
+
+	if exists {
+		// Records stay in place when only the dry run was switched.
+		// Records are removed without the lock as the provider calls can take long.
+		if oldPDNS.Generation == pdns.Generation {
+			oldManager.Stop()
+		} else {
+			oldManager.Destroy(oldPDNS)
+		}
 	} else {
 		// This shouldn't happen
 		klog.Errorf("Pod watcher for %s didn't exist exists! Something is broken!", regKey)
 	}
 
-	m := records.New(
-		pdns.Name,
-		pdns.Spec.Domain,
-		pdns.Spec.Label,
-		pdns.GetNamespace(),
-		pdns.Spec.SRVPort,
-		pdns.Spec.SRVProto,
-		pdns.Spec.Service,
-		pdns.Spec.PodTimeout,
-		c.kubeClient,
-		c.dnsClient,
-	)
-	c.res[regKey] = &m
-	go m.Start()
+	c.mu.Lock()
+	defer c.mu.Unlock()
 
+	if !ok {
+		metrics.SetResources(len(c.res))
+		return
+	}
+	c.res[regKey] = m
+	metrics.SetResources(len(c.res))
+	go m.Start()
 }
@@ -1,13 +1,13 @@
 package v1
 
 import (
+	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"time"
 	//"k8s.io/apimachinery/pkg/runtime"
 )
 
 // +genclient
-// +genclient:noStatus
 // +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
 
 // PrivateDNS is a specification for a DNS resource
@@ -15,18 +15,103 @@ type PrivateDNS struct {
 	metav1.TypeMeta   `json:",inline"`
 	metav1.ObjectMeta `json:"metadata,omitempty"`
 
-	Spec PrivateDNSSpec `json:"spec"`
+	Spec   PrivateDNSSpec   `json:"spec"`
+	Status PrivateDNSStatus `json:"status,omitempty"`
 }
 
 // DNSSpec ...
 type PrivateDNSSpec struct {
 	Label      string        `json:"label"`
 	Domain     string        `json:"domain"`
-	SRVPort    string        `json:"srv-por"`
+	SRVPort    string        `json:"srv-port"`
 	SRVProto   string        `json:"srv-protocol"`
 	PodTimeout time.Duration `json:"pod-timeout"`
 	Service    bool          `json:"service"`
 	Subdomain  bool          `json:"subdomain"`
+
+	// SRVPriority and SRVWeight of the pod targets in the SRV record.
+	// Pods can override them with tanelmae.com/srv-priority and tanelmae.com/srv-weight annotations.
+	SRVPriority int32 `json:"srv-priority,omitempty"`
+	SRVWeight   int32 `json:"srv-weight,omitempty"`
+
+	// ReconcileInterval overrides the global reconcile interval. Zero disables it.
+	ReconcileInterval *metav1.Duration `json:"reconcile-interval,omitempty"`
+
+	// TTL overrides the controller default TTLs of the records
+	TTL RecordTTL `json:"ttl,omitempty"`
+
+	// Hostnames overrides the names of the records
+	Hostnames HostnameTemplates `json:"hostnames,omitempty"`
+
+	// OwnerFallback is the owner name of the pods without a controller. Empty ignores such pods.
+	OwnerFallback string `json:"owner-fallback,omitempty"`
+}
+
+// HostnameTemplates are Go templates of the record names without the domain.
+// Empty template uses the default name.
+type HostnameTemplates struct {
+	// Pod record name. Defaults to {{.PodName}}.{{.OwnerName}}
+	Pod string `json:"pod,omitempty"`
+	// Service record name. Defaults to {{.OwnerName}}
+	Service string `json:"service,omitempty"`
+	// SRV record name. Defaults to _{{.SRVPort}}._{{.SRVProto}}
+	SRV string `json:"srv,omitempty"`
+}
+
+// RecordTTL is the TTL in seconds of the records by their type. Zero uses the controller default.
+type RecordTTL struct {
+	Pod     int64 `json:"pod,omitempty"`
+	Service int64 `json:"service,omitempty"`
+	SRV     int64 `json:"srv,omitempty"`
+	PTR     int64 `json:"ptr,omitempty"`
+}
+
+// ConditionType is a type of PrivateDNS condition
+type ConditionType string
+
+const (
+	// ConditionReady is true when all the records have been published
+	ConditionReady ConditionType = "Ready"
+	// ConditionProviderError is true when the last DNS provider request failed
+	ConditionProviderError ConditionType = "ProviderError"
+	// ConditionPodsPending is true when some pods are waiting for an IP
+	ConditionPodsPending ConditionType = "PodsPending"
+	// ConditionDryRun is true when the changes are only logged and not made in the DNS provider
+	ConditionDryRun ConditionType = "DryRun"
+)
+
+// PrivateDNSCondition describes the state of PrivateDNS at a certain point
+type PrivateDNSCondition struct {
+	Type               ConditionType          `json:"type"`
+	Status             corev1.ConditionStatus `json:"status"`
+	LastTransitionTime metav1.Time            `json:"last-transition-time,omitempty"`
+	Reason             string                 `json:"reason,omitempty"`
+	Message            string                 `json:"message,omitempty"`
+}
+
+// PublishedRecord is a pod record written to the DNS provider
+// with the names of the service and SRV records the pod was added to.
+// SecondaryIP is the IP of the other family of a dual-stack pod.
+// SRVData is the "priority weight port target." data of the pod in the SRV record.
+type PublishedRecord struct {
+	Name        string `json:"name"`
+	IP          string `json:"ip"`
+	SecondaryIP string `json:"secondary-ip,omitempty"`
+	Service     string `json:"service,omitempty"`
+	SRV         string `json:"srv,omitempty"`
+	SRVData     string `json:"srv-data,omitempty"`
+}
+
+// PrivateDNSStatus is the observed state of PrivateDNS
+type PrivateDNSStatus struct {
+	ObservedGeneration int64                 `json:"observed-generation,omitempty"`
+	Conditions         []PrivateDNSCondition `json:"conditions,omitempty"`
+	Records            []PublishedRecord     `json:"records,omitempty"`
+	LastSyncTime       *metav1.Time          `json:"last-sync-time,omitempty"`
+
+	// PlannedRecords are the records a dry run would have published.
+	// Records stay as they were as nothing is written to the DNS provider.
+	PlannedRecords []PublishedRecord `json:"planned-records,omitempty"`
 }
 
 // +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
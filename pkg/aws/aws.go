@@ -0,0 +1,561 @@
+package aws
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/route53"
+	"github.com/aws/aws-sdk-go/service/route53/route53iface"
+	"github.com/tanelmae/private-dns/internal/metrics"
+	"github.com/tanelmae/private-dns/internal/pdns"
+	"k8s.io/klog/v2"
+)
+
+const (
+	providerName = "aws"
+
+	// Change that is still pending after this fails the request
+	changeTimeout = 5 * time.Minute
+)
+
+// Route53 is a wrapper for AWS SDK api to hold relevant conf
+type Route53 struct {
+	api           route53iface.Route53API
+	zoneID        string
+	reverseZoneID string
+
+	// Name of the reverse hosted zone once it has been looked up
+	reverseMu   sync.Mutex
+	reverseZone string
+}
+
+// FromSession creates Route53 client instance using the default AWS credential chain.
+// Endpoint can be used to point the client to some other Route53 compatible API.
+func FromSession(region, endpoint, zoneID, reverseZoneID string) (*Route53, error) {
+	conf := aws.NewConfig()
+	if region != "" {
+		conf = conf.WithRegion(region)
+	}
+	if endpoint != "" {
+		conf = conf.WithEndpoint(endpoint)
+	}
+
+	sess, err := session.NewSession(conf)
+	if err != nil {
+		return nil, err
+	}
+
+	return New(route53.New(sess), zoneID, reverseZoneID), nil
+}
+
+// New creates Route53 client instance from an existing API client
+func New(api route53iface.Route53API, zoneID, reverseZoneID string) *Route53 {
+	return &Route53{
+		api:           api,
+		zoneID:        zoneID,
+		reverseZoneID: reverseZoneID,
+	}
+}
+
+func (c *Route53) applyChange(zoneID string, changes []*route53.Change) error {
+	ctx, cancel := context.WithTimeout(context.Background(), changeTimeout)
+	defer cancel()
+
+	start := time.Now()
+	out, err := c.api.ChangeResourceRecordSetsWithContext(ctx, &route53.ChangeResourceRecordSetsInput{
+		HostedZoneId: aws.String(zoneID),
+		ChangeBatch: &route53.ChangeBatch{
+			Changes: changes,
+		},
+	})
+	metrics.ObserveCall(providerName, "change", zoneID, start, err)
+	if err != nil {
+		return err
+	}
+
+	// wait for change to be acknowledged
+	chg := out.ChangeInfo
+	for aws.StringValue(chg.Status) == route53.ChangeStatusPending {
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("Change %s in %s is still pending: %w", aws.StringValue(chg.Id), zoneID, ctx.Err())
+		case <-time.After(time.Second):
+		}
+
+		start = time.Now()
+		res, err := c.api.GetChangeWithContext(ctx, &route53.GetChangeInput{Id: chg.Id})
+		metrics.ObserveCall(providerName, "get-change", zoneID, start, err)
+		if err != nil {
+			return err
+		}
+		chg = res.ChangeInfo
+	}
+	return nil
+}
+
+// checkForRec returns the record set with the same name and type from given zone.
+// Route53 lists records starting from the given name so the result needs to be matched.
+func (c *Route53) checkForRec(zoneID string, rec *route53.ResourceRecordSet) *route53.ResourceRecordSet {
+	start := time.Now()
+	list, err := c.api.ListResourceRecordSets(&route53.ListResourceRecordSetsInput{
+		HostedZoneId:    aws.String(zoneID),
+		StartRecordName: rec.Name,
+		StartRecordType: rec.Type,
+		MaxItems:        aws.String("1"),
+	})
+	metrics.ObserveCall(providerName, "get", zoneID, start, err)
+	if err != nil {
+		klog.Errorln(err)
+		return nil
+	}
+
+	if len(list.ResourceRecordSets) < 1 {
+		return nil
+	}
+
+	oldRec := list.ResourceRecordSets[0]
+	if !sameRecordSet(oldRec, rec) {
+		return nil
+	}
+	return oldRec
+}
+
+// reverseZoneName returns the name of the reverse hosted zone.
+// Name is looked up once as only the zone ID is configured.
+func (c *Route53) reverseZoneName() (string, error) {
+	c.reverseMu.Lock()
+	defer c.reverseMu.Unlock()
+
+	if c.reverseZone != "" {
+		return c.reverseZone, nil
+	}
+
+	start := time.Now()
+	out, err := c.api.GetHostedZone(&route53.GetHostedZoneInput{Id: aws.String(c.reverseZoneID)})
+	metrics.ObserveCall(providerName, "get-zone", c.reverseZoneID, start, err)
+	if err != nil {
+		return "", err
+	}
+	c.reverseZone = aws.StringValue(out.HostedZone.Name)
+	return c.reverseZone, nil
+}
+
+// CheckHealth gets the forward hosted zone to verify the API access
+func (c *Route53) CheckHealth() error {
+	start := time.Now()
+	_, err := c.api.GetHostedZone(&route53.GetHostedZoneInput{Id: aws.String(c.zoneID)})
+	metrics.ObserveCall(providerName, "get-zone", c.zoneID, start, err)
+	return err
+}
+
+// ListRecords returns the record sets in the hosted zone under the given domain
+func (c *Route53) ListRecords(domain string) ([]pdns.Record, error) {
+	records := []pdns.Record{}
+	start := time.Now()
+	err := c.api.ListResourceRecordSetsPages(&route53.ListResourceRecordSetsInput{
+		HostedZoneId: aws.String(c.zoneID),
+	}, func(list *route53.ListResourceRecordSetsOutput, lastPage bool) bool {
+		for _, rec := range list.ResourceRecordSets {
+			name := strings.TrimSuffix(aws.StringValue(rec.Name), ".")
+			if !pdns.InDomain(name, domain) {
+				continue
+			}
+
+			values := []string{}
+			for _, r := range rec.ResourceRecords {
+				values = append(values, aws.StringValue(r.Value))
+			}
+			records = append(records, pdns.Record{Name: name, Type: aws.StringValue(rec.Type), Values: values})
+		}
+		return true
+	})
+	metrics.ObserveCall(providerName, "list", c.zoneID, start, err)
+	return records, err
+}
+
+// NewRequest creates a new DNS change request for the hosted zones
+func (c *Route53) NewRequest() pdns.DNSRequest {
+	return &DNSRequest{
+		client: c,
+	}
+}
+
+// DNSRequest holds the changes for the forward and reverse hosted zones
+type DNSRequest struct {
+	client    *Route53
+	ttl       pdns.TTL
+	changes   []*route53.Change
+	revChange []*route53.Change
+}
+
+// SetTTL sets the TTL of the records added in the request
+func (d *DNSRequest) SetTTL(ttl pdns.TTL) {
+	d.ttl = ttl
+}
+
+// Do makes the request with all the attached changes
+// No error would be returned when no changes have been added
+func (d *DNSRequest) Do() (err error) {
+	defer metrics.ObserveRequest(providerName, time.Now(), &err)
+
+	if len(d.changes) > 0 {
+		if err = d.client.applyChange(d.client.zoneID, d.changes); err != nil {
+			return err
+		}
+	}
+
+	if len(d.revChange) > 0 {
+		if err = d.client.applyChange(d.client.reverseZoneID, d.revChange); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Changes returns the record set changes of the forward and reverse hosted zones
+func (d *DNSRequest) Changes() []string {
+	return append(describeChanges(d.client.zoneID, d.changes), describeChanges(d.client.reverseZoneID, d.revChange)...)
+}
+
+func describeChanges(zoneID string, changes []*route53.Change) []string {
+	described := []string{}
+	for _, chg := range changes {
+		rec := chg.ResourceRecordSet
+		values := []string{}
+		for _, r := range rec.ResourceRecords {
+			values = append(values, aws.StringValue(r.Value))
+		}
+		described = append(described, fmt.Sprintf("%s %s %s %d %v in %s", strings.ToLower(aws.StringValue(chg.Action)),
+			aws.StringValue(rec.Name), aws.StringValue(rec.Type), aws.Int64Value(rec.TTL), values, zoneID))
+	}
+	return described
+}
+
+// checkForRec returns the record set as it will be after the changes already in the request
+func (d *DNSRequest) checkForRec(zoneID string, changes []*route53.Change,
+	rec *route53.ResourceRecordSet) *route53.ResourceRecordSet {
+
+	for i := len(changes) - 1; i >= 0; i-- {
+		if !sameRecordSet(changes[i].ResourceRecordSet, rec) {
+			continue
+		}
+		if aws.StringValue(changes[i].Action) == route53.ChangeActionCreate {
+			return changes[i].ResourceRecordSet
+		}
+		return nil
+	}
+	return d.client.checkForRec(zoneID, rec)
+}
+
+// deletion drops the record set if it was created in the same request.
+// Otherwise the existing record set gets deleted.
+func (d *DNSRequest) deletion(rec *route53.ResourceRecordSet) {
+	if changes, ok := withoutCreate(d.changes, rec); ok {
+		d.changes = changes
+		return
+	}
+	d.changes = append(d.changes, &route53.Change{
+		Action:            aws.String(route53.ChangeActionDelete),
+		ResourceRecordSet: rec,
+	})
+}
+
+func (d *DNSRequest) addition(rec *route53.ResourceRecordSet) {
+	d.changes = append(d.changes, &route53.Change{
+		Action:            aws.String(route53.ChangeActionCreate),
+		ResourceRecordSet: rec,
+	})
+}
+
+func (d *DNSRequest) revDeletion(rec *route53.ResourceRecordSet) {
+	if changes, ok := withoutCreate(d.revChange, rec); ok {
+		d.revChange = changes
+		return
+	}
+	d.revChange = append(d.revChange, &route53.Change{
+		Action:            aws.String(route53.ChangeActionDelete),
+		ResourceRecordSet: rec,
+	})
+}
+
+func (d *DNSRequest) revAddition(rec *route53.ResourceRecordSet) {
+	d.revChange = append(d.revChange, &route53.Change{
+		Action:            aws.String(route53.ChangeActionCreate),
+		ResourceRecordSet: rec,
+	})
+}
+
+// AddRecord adds A or AAAA record with single IP
+func (d *DNSRequest) AddRecord(domain, ip string) {
+	rec := newRecordSet(fmt.Sprintf("%s.", domain), pdns.AddressType(ip), d.ttl.For(pdns.KindPod), ip)
+
+	oldRec := d.checkForRec(d.client.zoneID, d.changes, rec)
+
+	if oldRec != nil && dataContains(oldRec, ip) {
+		klog.V(2).Infof("Record exists: %s\n", rec)
+		return
+	}
+
+	// Just a safeguard for case there is some stale record
+	// as it would fail the API request
+	if oldRec != nil {
+		klog.V(2).Infof("Stale record found: %s\n", oldRec)
+		d.deletion(oldRec)
+	}
+	d.addition(rec)
+	if d.client.reverseZoneID != "" {
+		d.AddReverseRecord(domain, ip)
+	}
+}
+
+// RemoveRecord deletes A or AAAA record with a single IP
+func (d *DNSRequest) RemoveRecord(domain, ip string) {
+	rec := newRecordSet(fmt.Sprintf("%s.", domain), pdns.AddressType(ip), d.ttl.For(pdns.KindPod), ip)
+
+	oldRec := d.checkForRec(d.client.zoneID, d.changes, rec)
+	if oldRec == nil {
+		klog.V(2).Infof("No DNS record found for %s/%s", aws.StringValue(rec.Name), ip)
+		return
+	}
+
+	// If records and pods have somehow got into inconsistent state
+	// we avoid deleting records that don't match the event.
+	if !dataContains(oldRec, ip) {
+		klog.V(2).Infof("No DNS record found for %s with the same IP (%s)", aws.StringValue(rec.Name), ip)
+		return
+	}
+	d.deletion(oldRec)
+
+	if d.client.reverseZoneID != "" {
+		d.RemoveReverseRecord(domain, ip)
+	}
+}
+
+// reverseRecordSet returns the PTR record set of the IP.
+// Nil is returned when the reverse name is not in the reverse hosted zone.
+func (d *DNSRequest) reverseRecordSet(domain, ip string) *route53.ResourceRecordSet {
+	name, ok := pdns.ReverseName(ip)
+	if !ok {
+		klog.Errorf("Invalid IP address for %s: %s\n", domain, ip)
+		return nil
+	}
+
+	zone, err := d.client.reverseZoneName()
+	if err != nil {
+		klog.Errorf("Failed to get reverse hosted zone %s: %s\n", d.client.reverseZoneID, err)
+		return nil
+	}
+	if !pdns.InDomain(name, zone) {
+		klog.V(2).Infof("%s is not in %s reverse zone. Not writing PTR record.\n", name, zone)
+		return nil
+	}
+	return newRecordSet(fmt.Sprintf("%s.", name), route53.RRTypePtr, d.ttl.For(pdns.KindPTR), fmt.Sprintf("%s.", domain))
+}
+
+// AddReverseRecord adds a PTR record for the reverse lookup
+func (d *DNSRequest) AddReverseRecord(domain, ip string) {
+	rec := d.reverseRecordSet(domain, ip)
+	if rec == nil {
+		return
+	}
+
+	oldRec := d.checkForRec(d.client.reverseZoneID, d.revChange, rec)
+
+	if oldRec != nil && dataContains(oldRec, aws.StringValue(rec.ResourceRecords[0].Value)) {
+		klog.V(2).Infof("Record exists: %s\n", rec)
+		return
+	}
+
+	if oldRec != nil {
+		klog.V(2).Infof("Stale record found: %s\n", oldRec)
+		d.revDeletion(oldRec)
+	}
+	d.revAddition(rec)
+}
+
+// RemoveReverseRecord removes a PTR record from the reverse lookup zone
+func (d *DNSRequest) RemoveReverseRecord(domain, ip string) {
+	rec := d.reverseRecordSet(domain, ip)
+	if rec == nil {
+		return
+	}
+
+	oldRec := d.checkForRec(d.client.reverseZoneID, d.revChange, rec)
+	if oldRec == nil {
+		klog.V(2).Infof("No PTR record found for %s/%s", aws.StringValue(rec.Name), ip)
+		return
+	}
+
+	// If records and pods have somehow got into inconsistent state
+	// we avoid deleting records that don't match the event.
+	if !dataContains(oldRec, aws.StringValue(rec.ResourceRecords[0].Value)) {
+		klog.V(2).Infof("No PTR record found for %s with the same domain (%s)", aws.StringValue(rec.Name), domain)
+		return
+	}
+	d.revDeletion(oldRec)
+}
+
+// AddToService adds the given IP to A or AAAA record with multiple IPs
+func (d *DNSRequest) AddToService(domain, ip string) {
+	rec := newRecordSet(fmt.Sprintf("%s.", domain), pdns.AddressType(ip), d.ttl.For(pdns.KindService), ip)
+
+	oldRec := d.checkForRec(d.client.zoneID, d.changes, rec)
+
+	if oldRec != nil && dataContains(oldRec, ip) {
+		klog.V(2).Infof("Service %s record exists and contains %s\n", domain, ip)
+		return
+	}
+
+	// Service exists and we need to add the IP
+	if oldRec != nil {
+		rec.ResourceRecords = append(rec.ResourceRecords, oldRec.ResourceRecords...)
+		d.deletion(oldRec)
+	}
+	d.addition(rec)
+}
+
+// RemoveFromService removes given IP from an A or AAAA record with multiple IPs
+func (d *DNSRequest) RemoveFromService(domain, ip string) {
+	rec := newRecordSet(fmt.Sprintf("%s.", domain), pdns.AddressType(ip), d.ttl.For(pdns.KindService))
+
+	oldRec := d.checkForRec(d.client.zoneID, d.changes, rec)
+	if oldRec == nil {
+		klog.V(2).Infof("No record exists for %s\n", domain)
+		return
+	}
+
+	newRec, ok := removeData(oldRec, ip)
+	if !ok {
+		klog.V(2).Infof("%s service doesn't include %s\n", domain, ip)
+		return
+	}
+
+	d.deletion(oldRec)
+	if newRec != nil {
+		d.addition(newRec)
+	}
+}
+
+// AddToSRV adds target to SRV record.
+// Target with other priority, weight or port gets replaced.
+func (d *DNSRequest) AddToSRV(srv string, data pdns.SRV) {
+	value := data.String()
+	rec := newRecordSet(fmt.Sprintf("%s.", srv), route53.RRTypeSrv, d.ttl.For(pdns.KindSRV), value)
+
+	oldRec := d.checkForRec(d.client.zoneID, d.changes, rec)
+
+	if oldRec != nil {
+		kept := oldRec
+		if old, found := srvTarget(oldRec, data.Target); found {
+			if old == value {
+				klog.V(2).Infof("Record exists: %s\n", oldRec)
+				return
+			}
+			kept, _ = removeData(oldRec, old)
+		}
+
+		// We need to add the new endpoint
+		if kept != nil {
+			rec.ResourceRecords = append(rec.ResourceRecords, kept.ResourceRecords...)
+		}
+		d.deletion(oldRec)
+	}
+	d.addition(rec)
+}
+
+// RemoveFromSRV removes domain from SRV record
+func (d *DNSRequest) RemoveFromSRV(srv, domain string) {
+	rec := newRecordSet(fmt.Sprintf("%s.", srv), route53.RRTypeSrv, d.ttl.For(pdns.KindSRV))
+
+	oldRec := d.checkForRec(d.client.zoneID, d.changes, rec)
+	if oldRec == nil {
+		klog.V(2).Infof("No record exists for %s\n", srv)
+		return
+	}
+
+	value, found := srvTarget(oldRec, domain)
+	if !found {
+		klog.V(2).Infof("%s doesn't include %s\n", srv, domain)
+		return
+	}
+
+	newRec, _ := removeData(oldRec, value)
+	d.deletion(oldRec)
+	if newRec != nil {
+		d.addition(newRec)
+	}
+}
+
+// UTILS
+func newRecordSet(name, recType string, ttl int64, values ...string) *route53.ResourceRecordSet {
+	rec := &route53.ResourceRecordSet{
+		Name: aws.String(name),
+		TTL:  aws.Int64(ttl),
+		Type: aws.String(recType),
+	}
+	for _, v := range values {
+		rec.ResourceRecords = append(rec.ResourceRecords, &route53.ResourceRecord{Value: aws.String(v)})
+	}
+	return rec
+}
+
+func sameRecordSet(a, b *route53.ResourceRecordSet) bool {
+	return strings.EqualFold(aws.StringValue(a.Name), aws.StringValue(b.Name)) &&
+		aws.StringValue(a.Type) == aws.StringValue(b.Type)
+}
+
+// withoutCreate removes the pending creation of the record set from the changes
+func withoutCreate(changes []*route53.Change, rec *route53.ResourceRecordSet) ([]*route53.Change, bool) {
+	for i, c := range changes {
+		if aws.StringValue(c.Action) == route53.ChangeActionCreate && sameRecordSet(c.ResourceRecordSet, rec) {
+			return append(changes[:i], changes[i+1:]...), true
+		}
+	}
+	return changes, false
+}
+
+// srvTarget returns the SRV record value pointing to the given domain
+func srvTarget(rec *route53.ResourceRecordSet, domain string) (string, bool) {
+	for _, r := range rec.ResourceRecords {
+		fields := strings.Fields(aws.StringValue(r.Value))
+		if len(fields) == 4 && strings.TrimSuffix(fields[3], ".") == domain {
+			return aws.StringValue(r.Value), true
+		}
+	}
+	return "", false
+}
+
+func dataContains(rec *route53.ResourceRecordSet, data string) bool {
+	for _, r := range rec.ResourceRecords {
+		if aws.StringValue(r.Value) == data {
+			return true
+		}
+	}
+	return false
+}
+
+// removeData returns a copy of the record without the given value.
+// Returned record is nil when no values would be left.
+func removeData(rec *route53.ResourceRecordSet, data string) (*route53.ResourceRecordSet, bool) {
+	newRec := *rec
+	newRec.ResourceRecords = []*route53.ResourceRecord{}
+
+	found := false
+	for _, r := range rec.ResourceRecords {
+		if aws.StringValue(r.Value) == data {
+			found = true
+			continue
+		}
+		newRec.ResourceRecords = append(newRec.ResourceRecords, r)
+	}
+
+	if len(newRec.ResourceRecords) == 0 {
+		return nil, found
+	}
+	return &newRec, found
+}
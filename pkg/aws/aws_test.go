@@ -0,0 +1,280 @@
+package aws
+
+import (
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/route53"
+	"github.com/tanelmae/private-dns/internal/pdns/pdnstest"
+)
+
+const (
+	testZone    = "ZFORWARD"
+	testRevZone = "ZREVERSE"
+)
+
+var zoneNames = map[string]string{testZone: "example.com.", testRevZone: "10.in-addr.arpa."}
+
+type xmlRecord struct {
+	Value string `xml:"Value"`
+}
+
+type xmlRecordSet struct {
+	Name    string      `xml:"Name"`
+	Type    string      `xml:"Type"`
+	TTL     int64       `xml:"TTL"`
+	Records []xmlRecord `xml:"ResourceRecords>ResourceRecord"`
+}
+
+type xmlChange struct {
+	Action string       `xml:"Action"`
+	RecSet xmlRecordSet `xml:"ResourceRecordSet"`
+}
+
+type xmlChangeRequest struct {
+	Changes []xmlChange `xml:"ChangeBatch>Changes>Change"`
+}
+
+type xmlChangeInfo struct {
+	ID     string `xml:"Id"`
+	Status string `xml:"Status"`
+}
+
+type xmlChangeResponse struct {
+	XMLName    xml.Name
+	ChangeInfo xmlChangeInfo `xml:"ChangeInfo"`
+}
+
+type xmlHostedZone struct {
+	ID   string `xml:"Id"`
+	Name string `xml:"Name"`
+}
+
+type xmlHostedZoneResponse struct {
+	XMLName    xml.Name
+	HostedZone xmlHostedZone `xml:"HostedZone"`
+}
+
+type xmlListResponse struct {
+	XMLName     xml.Name
+	Sets        []xmlRecordSet `xml:"ResourceRecordSets>ResourceRecordSet"`
+	IsTruncated bool           `xml:"IsTruncated"`
+	MaxItems    string         `xml:"MaxItems"`
+}
+
+// fakeRoute53 is a minimal local stand-in for the Route53 REST API
+type fakeRoute53 struct {
+	mu       sync.Mutex
+	zones    map[string]map[string]xmlRecordSet
+	changes  int
+	requests int
+}
+
+func newFakeRoute53() *fakeRoute53 {
+	return &fakeRoute53{
+		zones: map[string]map[string]xmlRecordSet{
+			testZone:    {},
+			testRevZone: {},
+		},
+	}
+}
+
+func recKey(name, recType string) string {
+	return fmt.Sprintf("%s|%s", strings.ToLower(name), recType)
+}
+
+func (f *fakeRoute53) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.requests++
+
+	parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	switch {
+	case len(parts) == 3 && parts[1] == "change":
+		writeXML(w, xmlChangeResponse{
+			XMLName:    responseName("GetChangeResponse"),
+			ChangeInfo: xmlChangeInfo{ID: "/change/" + parts[2], Status: route53.ChangeStatusInsync},
+		})
+	case len(parts) == 3 && parts[1] == "hostedzone":
+		writeXML(w, xmlHostedZoneResponse{
+			XMLName:    responseName("GetHostedZoneResponse"),
+			HostedZone: xmlHostedZone{ID: "/hostedzone/" + parts[2], Name: zoneNames[parts[2]]},
+		})
+	case len(parts) == 4 && parts[1] == "hostedzone" && r.Method == http.MethodGet:
+		f.list(w, parts[2], r.URL.Query().Get("name"), r.URL.Query().Get("type"))
+	case len(parts) == 4 && parts[1] == "hostedzone" && r.Method == http.MethodPost:
+		f.change(w, r, parts[2])
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func (f *fakeRoute53) list(w http.ResponseWriter, zone, name, recType string) {
+	keys := []string{}
+	for k := range f.zones[zone] {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	// Whole zone is listed when no start name is given
+	sets := []xmlRecordSet{}
+	for _, k := range keys {
+		if name == "" {
+			sets = append(sets, f.zones[zone][k])
+			continue
+		}
+		if k >= recKey(name, recType) {
+			sets = append(sets, f.zones[zone][k])
+			break
+		}
+	}
+	writeXML(w, xmlListResponse{
+		XMLName:  responseName("ListResourceRecordSetsResponse"),
+		Sets:     sets,
+		MaxItems: fmt.Sprint(len(sets)),
+	})
+}
+
+func (f *fakeRoute53) change(w http.ResponseWriter, r *http.Request, zone string) {
+	req := xmlChangeRequest{}
+	if err := xml.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// Changes in a batch are applied atomically
+	records := map[string]xmlRecordSet{}
+	for k, v := range f.zones[zone] {
+		records[k] = v
+	}
+	for _, c := range req.Changes {
+		key := recKey(c.RecSet.Name, c.RecSet.Type)
+		existing, exists := records[key]
+		switch c.Action {
+		case route53.ChangeActionCreate:
+			if exists {
+				http.Error(w, "InvalidChangeBatch: record exists", http.StatusBadRequest)
+				return
+			}
+			records[key] = c.RecSet
+		case route53.ChangeActionDelete:
+			if !exists || fmt.Sprint(existing) != fmt.Sprint(c.RecSet) {
+				http.Error(w, "InvalidChangeBatch: record not found", http.StatusBadRequest)
+				return
+			}
+			delete(records, key)
+		}
+	}
+	f.zones[zone] = records
+
+	// Only the first change stays pending to keep the tests fast
+	f.changes++
+	id := fmt.Sprintf("C%d", f.changes)
+	status := route53.ChangeStatusInsync
+	if f.changes == 1 {
+		status = route53.ChangeStatusPending
+	}
+	writeXML(w, xmlChangeResponse{
+		XMLName:    responseName("ChangeResourceRecordSetsResponse"),
+		ChangeInfo: xmlChangeInfo{ID: "/change/" + id, Status: status},
+	})
+}
+
+func (f *fakeRoute53) values(zone, name, recType string) []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	rec, ok := f.zones[zone][recKey(name, recType)]
+	if !ok {
+		return nil
+	}
+	values := []string{}
+	for _, v := range rec.Records {
+		values = append(values, v.Value)
+	}
+	sort.Strings(values)
+	return values
+}
+
+func writeXML(w http.ResponseWriter, body interface{}) {
+	out, err := xml.Marshal(body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/xml")
+	w.Write(out)
+}
+
+func responseName(name string) xml.Name {
+	return xml.Name{Space: "https://route53.amazonaws.com/doc/2013-04-01/", Local: name}
+}
+
+func newTestClient(t *testing.T) (*Route53, *fakeRoute53) {
+	fake := newFakeRoute53()
+	srv := httptest.NewServer(fake)
+	t.Cleanup(srv.Close)
+
+	sess := session.Must(session.NewSession(aws.NewConfig().
+		WithEndpoint(srv.URL).
+		WithRegion("us-east-1").
+		WithCredentials(credentials.NewStaticCredentials("id", "secret", "")).
+		WithMaxRetries(0)))
+
+	return New(route53.New(sess), testZone, testRevZone), fake
+}
+
+func TestProvider(t *testing.T) {
+	pdnstest.Run(t, func(t *testing.T) pdnstest.Fake {
+		client, fake := newTestClient(t)
+		return pdnstest.Fake{
+			Provider: client,
+			Values: func(name, recType string) []string {
+				if strings.HasSuffix(name, zoneNames[testRevZone]) {
+					return fake.values(testRevZone, name, recType)
+				}
+				return fake.values(testZone, name, recType)
+			},
+			Writes: func() int { return fake.changes },
+		}
+	})
+}
+
+func TestDualStack(t *testing.T) {
+	client, fake := newTestClient(t)
+
+	req := client.NewRequest()
+	req.AddRecord("pod-0.app.example.com", "10.0.0.1")
+	req.AddRecord("pod-0.app.example.com", "fd00::1")
+	req.AddRecord("pod-1.app.example.com", "192.168.0.1")
+	pdnstest.Do(t, req)
+	pdnstest.AssertValues(t, fake.values(testZone, "pod-0.app.example.com.", "A"), "10.0.0.1")
+	pdnstest.AssertValues(t, fake.values(testZone, "pod-0.app.example.com.", "AAAA"), "fd00::1")
+	pdnstest.AssertValues(t, fake.values(testZone, "pod-1.app.example.com.", "A"), "192.168.0.1")
+
+	// Only the IPs in the reverse zone get PTR records
+	pdnstest.AssertValues(t, fake.values(testRevZone, "1.0.0.10.in-addr.arpa.", "PTR"), "pod-0.app.example.com.")
+	if len(fake.zones[testRevZone]) != 1 {
+		t.Fatalf("expected a single PTR record, got %v", fake.zones[testRevZone])
+	}
+}
+
+func TestEmptyRequest(t *testing.T) {
+	client, fake := newTestClient(t)
+
+	if err := client.NewRequest().Do(); err != nil {
+		t.Fatal(err)
+	}
+	if fake.requests != 0 {
+		t.Fatalf("expected no API requests, got %d", fake.requests)
+	}
+}
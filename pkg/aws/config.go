@@ -0,0 +1,41 @@
+package aws
+
+import (
+	"errors"
+	"flag"
+
+	"github.com/tanelmae/private-dns/internal/pdns"
+)
+
+func init() {
+	pdns.Register(providerName, func() pdns.ProviderConfig { return &Options{} })
+}
+
+// Options holds Route53 provider configuration
+type Options struct {
+	ZoneID        string `json:"zone-id"`
+	ReverseZoneID string `json:"reverse-zone-id"`
+	Region        string `json:"region"`
+	Endpoint      string `json:"endpoint"`
+}
+
+// RegisterFlags adds Route53 flags to the flag set
+func (o *Options) RegisterFlags(fs *flag.FlagSet) {
+	fs.StringVar(&o.ZoneID, "aws-zone-id", "", "Route53 private hosted zone ID where to write the records")
+	fs.StringVar(&o.ReverseZoneID, "aws-reverse-zone-id", "", "Route53 private hosted zone ID where to write the reverse lookup records")
+	fs.StringVar(&o.Region, "aws-region", "", "AWS region for the Route53 client. Defaults to the AWS SDK configuration.")
+	fs.StringVar(&o.Endpoint, "aws-endpoint", "", "Custom Route53 API endpoint")
+}
+
+// New creates Route53 client from the options.
+// Credentials are resolved with the default AWS SDK chain.
+func (o *Options) New() (pdns.DNSProvider, error) {
+	if o.ZoneID == "" {
+		return nil, errors.New("-aws-zone-id is required")
+	}
+	client, err := FromSession(o.Region, o.Endpoint, o.ZoneID, o.ReverseZoneID)
+	if err != nil {
+		return nil, err
+	}
+	return client, nil
+}
@@ -0,0 +1,610 @@
+package azure
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/services/privatedns/mgmt/2018-09-01/privatedns"
+	"github.com/Azure/go-autorest/autorest/azure/auth"
+	"github.com/Azure/go-autorest/autorest/to"
+	"github.com/tanelmae/private-dns/internal/metrics"
+	"github.com/tanelmae/private-dns/internal/pdns"
+	"k8s.io/klog/v2"
+)
+
+const (
+	providerName = "azure"
+
+	// Used with If-None-Match to fail if record set has been created meanwhile
+	etagAny = "*"
+
+	// Request that hasn't finished all its changes in this time fails
+	requestTimeout = 5 * time.Minute
+)
+
+// PrivateZones is a wrapper for Azure SDK api to hold relevant conf
+type PrivateZones struct {
+	api           privatedns.RecordSetsClient
+	resourceGroup string
+	zone          string
+	reverseZone   string
+}
+
+// FromEnvironment creates Azure Private DNS client instance.
+// Credentials are resolved from the environment (client secret, certificate or managed identity).
+func FromEnvironment(subscription, resourceGroup, zone, reverseZone string) (*PrivateZones, error) {
+	authorizer, err := auth.NewAuthorizerFromEnvironment()
+	if err != nil {
+		return nil, err
+	}
+
+	api := privatedns.NewRecordSetsClient(subscription)
+	api.Authorizer = authorizer
+
+	return New(api, resourceGroup, zone, reverseZone), nil
+}
+
+// New creates Azure Private DNS client instance from an existing API client
+func New(api privatedns.RecordSetsClient, resourceGroup, zone, reverseZone string) *PrivateZones {
+	return &PrivateZones{
+		api:           api,
+		resourceGroup: resourceGroup,
+		zone:          zone,
+		reverseZone:   reverseZone,
+	}
+}
+
+// recordChange is a pending change to a single record set.
+// Nil set means the record set is deleted.
+type recordChange struct {
+	zone       string
+	recordType privatedns.RecordType
+	name       string
+	set        *privatedns.RecordSet
+	etag       string
+}
+
+func (c *PrivateZones) applyChange(ctx context.Context, chg recordChange) error {
+	start := time.Now()
+	if chg.set == nil {
+		_, err := c.api.Delete(ctx, c.resourceGroup, chg.zone, chg.recordType, chg.name, chg.etag)
+		metrics.ObserveCall(providerName, "delete", chg.zone, start, err)
+		return err
+	}
+
+	// Record set is expected to be unchanged since it was read
+	// or not to exist at all when it wasn't found.
+	ifNoneMatch := ""
+	if chg.etag == "" {
+		ifNoneMatch = etagAny
+	}
+	_, err := c.api.CreateOrUpdate(ctx, c.resourceGroup, chg.zone, chg.recordType, chg.name, *chg.set, chg.etag, ifNoneMatch)
+	metrics.ObserveCall(providerName, "update", chg.zone, start, err)
+	return err
+}
+
+// checkForRec returns the values and ETag of an existing record set.
+// Nil values are returned when the record set doesn't exist.
+func (c *PrivateZones) checkForRec(zone string, recordType privatedns.RecordType, name string) ([]string, string) {
+	start := time.Now()
+	rs, err := c.api.Get(context.Background(), c.resourceGroup, zone, recordType, name)
+	notFound := err != nil && rs.Response.Response != nil && rs.Response.StatusCode == http.StatusNotFound
+	if notFound {
+		metrics.ObserveCall(providerName, "get", zone, start, nil)
+		return nil, ""
+	}
+
+	metrics.ObserveCall(providerName, "get", zone, start, err)
+	if err != nil {
+		klog.Errorln(err)
+		return nil, ""
+	}
+
+	return recordValues(recordType, rs.RecordSetProperties), to.String(rs.Etag)
+}
+
+// CheckHealth gets the SOA record set of the forward zone to verify the API access.
+// Record sets client has no access to the zone itself.
+func (c *PrivateZones) CheckHealth() error {
+	start := time.Now()
+	_, err := c.api.Get(context.Background(), c.resourceGroup, c.zone, privatedns.SOA, "@")
+	metrics.ObserveCall(providerName, "get-zone", c.zone, start, err)
+	return err
+}
+
+// ListRecords returns the record sets in the zone under the given domain
+func (c *PrivateZones) ListRecords(domain string) ([]pdns.Record, error) {
+	ctx := context.Background()
+	records := []pdns.Record{}
+	start := time.Now()
+
+	list, err := c.api.ListComplete(ctx, c.resourceGroup, c.zone, nil, "")
+	for ; err == nil && list.NotDone(); err = list.NextWithContext(ctx) {
+		rs := list.Value()
+
+		// Type is given as Microsoft.Network/privateDnsZones/{type}
+		recordType := privatedns.RecordType(path.Base(to.String(rs.Type)))
+		name := c.zone
+		if n := to.String(rs.Name); n != "@" {
+			name = fmt.Sprintf("%s.%s", n, c.zone)
+		}
+
+		if pdns.InDomain(name, domain) {
+			records = append(records, pdns.Record{
+				Name:   strings.TrimSuffix(name, "."),
+				Type:   string(recordType),
+				Values: recordValues(recordType, rs.RecordSetProperties),
+			})
+		}
+	}
+	metrics.ObserveCall(providerName, "list", c.zone, start, err)
+	return records, err
+}
+
+// NewRequest creates a new DNS change request for the private zones
+func (c *PrivateZones) NewRequest() pdns.DNSRequest {
+	return &DNSRequest{
+		client: c,
+	}
+}
+
+// DNSRequest holds the record set changes for the forward and reverse zones.
+// Azure has no batch API so every record set is changed separately
+// and ETags are used to avoid overwriting concurrent changes.
+type DNSRequest struct {
+	client  *PrivateZones
+	ttl     pdns.TTL
+	changes []recordChange
+}
+
+// SetTTL sets the TTL of the records added in the request
+func (d *DNSRequest) SetTTL(ttl pdns.TTL) {
+	d.ttl = ttl
+}
+
+// Do makes the requests for all the attached changes
+// No error would be returned when no changes have been added
+func (d *DNSRequest) Do() (err error) {
+	defer metrics.ObserveRequest(providerName, time.Now(), &err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), requestTimeout)
+	defer cancel()
+	for _, chg := range d.changes {
+		if err = d.client.applyChange(ctx, chg); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Changes returns the record sets the request would delete or replace
+func (d *DNSRequest) Changes() []string {
+	changes := []string{}
+	for _, chg := range d.changes {
+		if chg.set == nil {
+			changes = append(changes, fmt.Sprintf("delete %s %s in %s", chg.name, chg.recordType, chg.zone))
+			continue
+		}
+		changes = append(changes, fmt.Sprintf("set %s %s %d %v in %s", chg.name, chg.recordType,
+			to.Int64(chg.set.TTL), recordValues(chg.recordType, chg.set.RecordSetProperties), chg.zone))
+	}
+	return changes
+}
+
+// pending returns the index of the change already made to the record set in this request
+func (d *DNSRequest) pending(zone string, recordType privatedns.RecordType, name string) int {
+	for i, chg := range d.changes {
+		if chg.zone == zone && chg.recordType == recordType && strings.EqualFold(chg.name, name) {
+			return i
+		}
+	}
+	return -1
+}
+
+// checkForRec returns the record set values as they will be after the changes already in the request.
+// ETag is the one read before the first change so the record set is written only once.
+func (d *DNSRequest) checkForRec(zone string, recordType privatedns.RecordType, name string) ([]string, string) {
+	if i := d.pending(zone, recordType, name); i >= 0 {
+		chg := d.changes[i]
+		if chg.set == nil {
+			return nil, chg.etag
+		}
+		return recordValues(recordType, chg.set.RecordSetProperties), chg.etag
+	}
+	return d.client.checkForRec(zone, recordType, name)
+}
+
+// change replaces the earlier change of the same record set
+func (d *DNSRequest) change(chg recordChange) {
+	if i := d.pending(chg.zone, chg.recordType, chg.name); i >= 0 {
+		d.changes[i] = chg
+		return
+	}
+	d.changes = append(d.changes, chg)
+}
+
+func (d *DNSRequest) update(zone string, recordType privatedns.RecordType, name, etag string, ttl int64, values []string) {
+	d.change(recordChange{
+		zone:       zone,
+		recordType: recordType,
+		name:       name,
+		etag:       etag,
+		set: &privatedns.RecordSet{
+			RecordSetProperties: recordProperties(recordType, ttl, values),
+		},
+	})
+}
+
+func (d *DNSRequest) deletion(zone string, recordType privatedns.RecordType, name, etag string) {
+	// Record set created in the same request doesn't need to be deleted
+	if i := d.pending(zone, recordType, name); i >= 0 && etag == "" {
+		d.changes = append(d.changes[:i], d.changes[i+1:]...)
+		return
+	}
+
+	d.change(recordChange{
+		zone:       zone,
+		recordType: recordType,
+		name:       name,
+		etag:       etag,
+	})
+}
+
+// AddRecord adds A or AAAA record with single IP
+func (d *DNSRequest) AddRecord(domain, ip string) {
+	name, ok := relativeName(domain, d.client.zone)
+	if !ok {
+		klog.Errorf("%s is not in %s zone\n", domain, d.client.zone)
+		return
+	}
+
+	recType := addressType(ip)
+	oldRec, etag := d.checkForRec(d.client.zone, recType, name)
+
+	if len(oldRec) == 1 && oldRec[0] == ip {
+		klog.V(2).Infof("Record exists: %s/%s\n", domain, ip)
+		return
+	}
+
+	// Stale record gets overwritten
+	if oldRec != nil {
+		klog.V(2).Infof("Stale record found: %s/%v\n", domain, oldRec)
+	}
+	d.update(d.client.zone, recType, name, etag, d.ttl.For(pdns.KindPod), []string{ip})
+
+	if d.client.reverseZone != "" {
+		d.AddReverseRecord(domain, ip)
+	}
+}
+
+// RemoveRecord deletes A or AAAA record with a single IP
+func (d *DNSRequest) RemoveRecord(domain, ip string) {
+	name, ok := relativeName(domain, d.client.zone)
+	if !ok {
+		klog.Errorf("%s is not in %s zone\n", domain, d.client.zone)
+		return
+	}
+
+	recType := addressType(ip)
+	oldRec, etag := d.checkForRec(d.client.zone, recType, name)
+	if oldRec == nil {
+		klog.V(2).Infof("No DNS record found for %s/%s", domain, ip)
+		return
+	}
+
+	// If records and pods have somehow got into inconsistent state
+	// we avoid deleting records that don't match the event.
+	if !contains(oldRec, ip) {
+		klog.V(2).Infof("No DNS record found for %s with the same IP (%s)", domain, ip)
+		return
+	}
+	d.deletion(d.client.zone, recType, name, etag)
+
+	if d.client.reverseZone != "" {
+		d.RemoveReverseRecord(domain, ip)
+	}
+}
+
+// AddReverseRecord adds a PTR record for the reverse lookup
+func (d *DNSRequest) AddReverseRecord(domain, ip string) {
+	name, ok := d.reverseName(ip)
+	if !ok {
+		return
+	}
+
+	oldRec, etag := d.checkForRec(d.client.reverseZone, privatedns.PTR, name)
+
+	if len(oldRec) == 1 && oldRec[0] == domain {
+		klog.V(2).Infof("Record exists: %s/%s\n", ip, domain)
+		return
+	}
+
+	if oldRec != nil {
+		klog.V(2).Infof("Stale record found: %s/%v\n", ip, oldRec)
+	}
+	d.update(d.client.reverseZone, privatedns.PTR, name, etag, d.ttl.For(pdns.KindPTR), []string{domain})
+}
+
+// RemoveReverseRecord removes a PTR record from the reverse lookup zone
+func (d *DNSRequest) RemoveReverseRecord(domain, ip string) {
+	name, ok := d.reverseName(ip)
+	if !ok {
+		return
+	}
+
+	oldRec, etag := d.checkForRec(d.client.reverseZone, privatedns.PTR, name)
+	if oldRec == nil {
+		klog.V(2).Infof("No PTR record found for %s/%s", name, ip)
+		return
+	}
+
+	// If records and pods have somehow got into inconsistent state
+	// we avoid deleting records that don't match the event.
+	if !contains(oldRec, domain) {
+		klog.V(2).Infof("No PTR record found for %s with the same domain (%s)", name, domain)
+		return
+	}
+	d.deletion(d.client.reverseZone, privatedns.PTR, name, etag)
+}
+
+// reverseName returns the PTR record set name relative to the reverse zone.
+// Addresses outside of the reverse zone have no PTR records.
+func (d *DNSRequest) reverseName(ip string) (string, bool) {
+	if !pdns.InReverseZone(ip, d.client.reverseZone) {
+		klog.V(2).Infof("%s is not in %s reverse zone. Not writing PTR record.\n", ip, d.client.reverseZone)
+		return "", false
+	}
+
+	name, _ := pdns.ReverseName(ip)
+	return relativeName(name, d.client.reverseZone)
+}
+
+// AddToService adds the given IP to A or AAAA record with multiple IPs
+func (d *DNSRequest) AddToService(domain, ip string) {
+	name, ok := relativeName(domain, d.client.zone)
+	if !ok {
+		klog.Errorf("%s is not in %s zone\n", domain, d.client.zone)
+		return
+	}
+
+	recType := addressType(ip)
+	oldRec, etag := d.checkForRec(d.client.zone, recType, name)
+
+	if contains(oldRec, ip) {
+		klog.V(2).Infof("Service %s record exists and contains %s\n", domain, ip)
+		return
+	}
+
+	d.update(d.client.zone, recType, name, etag, d.ttl.For(pdns.KindService), append(oldRec, ip))
+}
+
+// RemoveFromService removes given IP from an A or AAAA record with multiple IPs
+func (d *DNSRequest) RemoveFromService(domain, ip string) {
+	name, ok := relativeName(domain, d.client.zone)
+	if !ok {
+		klog.Errorf("%s is not in %s zone\n", domain, d.client.zone)
+		return
+	}
+
+	recType := addressType(ip)
+	oldRec, etag := d.checkForRec(d.client.zone, recType, name)
+	if oldRec == nil {
+		klog.V(2).Infof("No record exists for %s\n", domain)
+		return
+	}
+
+	if !contains(oldRec, ip) {
+		klog.V(2).Infof("%s service doesn't include %s\n", domain, ip)
+		return
+	}
+
+	values := remove(oldRec, ip)
+	if len(values) == 0 {
+		d.deletion(d.client.zone, recType, name, etag)
+		return
+	}
+	d.update(d.client.zone, recType, name, etag, d.ttl.For(pdns.KindService), values)
+}
+
+// AddToSRV adds target to SRV record.
+// Target with other priority, weight or port gets replaced.
+func (d *DNSRequest) AddToSRV(srv string, data pdns.SRV) {
+	name, ok := relativeName(srv, d.client.zone)
+	if !ok {
+		klog.Errorf("%s is not in %s zone\n", srv, d.client.zone)
+		return
+	}
+
+	oldRec, etag := d.checkForRec(d.client.zone, privatedns.SRV, name)
+
+	value := fmt.Sprintf("%d %d %d %s", data.Priority, data.Weight, data.Port, data.Target)
+	if old, found := srvTarget(oldRec, data.Target); found {
+		if old == value {
+			klog.V(2).Infof("Record exists: %s/%v\n", srv, oldRec)
+			return
+		}
+		oldRec = remove(oldRec, old)
+	}
+
+	d.update(d.client.zone, privatedns.SRV, name, etag, d.ttl.For(pdns.KindSRV), append(oldRec, value))
+}
+
+// RemoveFromSRV removes domain from SRV record
+func (d *DNSRequest) RemoveFromSRV(srv, domain string) {
+	name, ok := relativeName(srv, d.client.zone)
+	if !ok {
+		klog.Errorf("%s is not in %s zone\n", srv, d.client.zone)
+		return
+	}
+
+	oldRec, etag := d.checkForRec(d.client.zone, privatedns.SRV, name)
+	if oldRec == nil {
+		klog.V(2).Infof("No record exists for %s\n", srv)
+		return
+	}
+
+	value, found := srvTarget(oldRec, domain)
+	if !found {
+		klog.V(2).Infof("%s doesn't include %s\n", srv, domain)
+		return
+	}
+
+	values := remove(oldRec, value)
+	if len(values) == 0 {
+		d.deletion(d.client.zone, privatedns.SRV, name, etag)
+		return
+	}
+	d.update(d.client.zone, privatedns.SRV, name, etag, d.ttl.For(pdns.KindSRV), values)
+}
+
+// UTILS
+
+// relativeName returns the record set name relative to the zone as Azure expects it
+func relativeName(domain, zone string) (string, bool) {
+	domain = strings.TrimSuffix(domain, ".")
+	zone = strings.TrimSuffix(zone, ".")
+
+	if strings.EqualFold(domain, zone) {
+		return "@", true
+	}
+
+	suffix := "." + zone
+	if len(domain) <= len(suffix) || !strings.EqualFold(domain[len(domain)-len(suffix):], suffix) {
+		return "", false
+	}
+	return domain[:len(domain)-len(suffix)], true
+}
+
+// addressType returns the address record type for the IP
+func addressType(ip string) privatedns.RecordType {
+	if pdns.IsIPv6(ip) {
+		return privatedns.AAAA
+	}
+	return privatedns.A
+}
+
+// recordValues converts record set data into plain string values.
+// SRV records are represented as "priority weight port target".
+func recordValues(recordType privatedns.RecordType, props *privatedns.RecordSetProperties) []string {
+	values := []string{}
+	if props == nil {
+		return values
+	}
+
+	switch recordType {
+	case privatedns.A:
+		if props.ARecords != nil {
+			for _, r := range *props.ARecords {
+				values = append(values, to.String(r.Ipv4Address))
+			}
+		}
+	case privatedns.AAAA:
+		if props.AaaaRecords != nil {
+			for _, r := range *props.AaaaRecords {
+				values = append(values, to.String(r.Ipv6Address))
+			}
+		}
+	case privatedns.PTR:
+		if props.PtrRecords != nil {
+			for _, r := range *props.PtrRecords {
+				values = append(values, strings.TrimSuffix(to.String(r.Ptrdname), "."))
+			}
+		}
+	case privatedns.SRV:
+		if props.SrvRecords != nil {
+			for _, r := range *props.SrvRecords {
+				values = append(values, fmt.Sprintf("%d %d %d %s", to.Int32(r.Priority),
+					to.Int32(r.Weight), to.Int32(r.Port), strings.TrimSuffix(to.String(r.Target), ".")))
+			}
+		}
+	}
+	return values
+}
+
+// recordProperties converts plain string values into record set data
+func recordProperties(recordType privatedns.RecordType, ttl int64, values []string) *privatedns.RecordSetProperties {
+	props := &privatedns.RecordSetProperties{
+		TTL: to.Int64Ptr(ttl),
+	}
+
+	switch recordType {
+	case privatedns.A:
+		records := []privatedns.ARecord{}
+		for _, v := range values {
+			records = append(records, privatedns.ARecord{Ipv4Address: to.StringPtr(v)})
+		}
+		props.ARecords = &records
+	case privatedns.AAAA:
+		records := []privatedns.AaaaRecord{}
+		for _, v := range values {
+			records = append(records, privatedns.AaaaRecord{Ipv6Address: to.StringPtr(v)})
+		}
+		props.AaaaRecords = &records
+	case privatedns.PTR:
+		records := []privatedns.PtrRecord{}
+		for _, v := range values {
+			records = append(records, privatedns.PtrRecord{Ptrdname: to.StringPtr(v)})
+		}
+		props.PtrRecords = &records
+	case privatedns.SRV:
+		records := []privatedns.SrvRecord{}
+		for _, v := range values {
+			fields := strings.Fields(v)
+			if len(fields) != 4 {
+				klog.Errorf("Invalid SRV record data: %s\n", v)
+				continue
+			}
+			records = append(records, privatedns.SrvRecord{
+				Priority: to.Int32Ptr(atoi32(fields[0])),
+				Weight:   to.Int32Ptr(atoi32(fields[1])),
+				Port:     to.Int32Ptr(atoi32(fields[2])),
+				Target:   to.StringPtr(fields[3]),
+			})
+		}
+		props.SrvRecords = &records
+	}
+	return props
+}
+
+// srvTarget returns the SRV record value pointing to the given domain
+func srvTarget(values []string, domain string) (string, bool) {
+	for _, v := range values {
+		fields := strings.Fields(v)
+		if len(fields) == 4 && fields[3] == domain {
+			return v, true
+		}
+	}
+	return "", false
+}
+
+func atoi32(s string) int32 {
+	i, err := strconv.ParseInt(s, 10, 32)
+	if err != nil {
+		klog.Errorln(err)
+	}
+	return int32(i)
+}
+
+func contains(values []string, data string) bool {
+	for _, v := range values {
+		if v == data {
+			return true
+		}
+	}
+	return false
+}
+
+func remove(values []string, data string) []string {
+	newValues := []string{}
+	for _, v := range values {
+		if v != data {
+			newValues = append(newValues, v)
+		}
+	}
+	return newValues
+}
@@ -0,0 +1,224 @@
+package azure
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/Azure/azure-sdk-for-go/services/privatedns/mgmt/2018-09-01/privatedns"
+	"github.com/Azure/go-autorest/autorest"
+	"github.com/tanelmae/private-dns/internal/pdns/pdnstest"
+)
+
+const (
+	testZone    = "example.com"
+	testRevZone = "in-addr.arpa"
+)
+
+type storedSet struct {
+	Etag       string                          `json:"etag"`
+	Properties *privatedns.RecordSetProperties `json:"properties"`
+}
+
+// fakeARM is a minimal local stand-in for the Azure Private DNS record set API
+type fakeARM struct {
+	mu      sync.Mutex
+	sets    map[string]storedSet
+	version int
+}
+
+func (f *fakeARM) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	// .../privateDnsZones/{zone}/{type}/{name}
+	parts := strings.Split(r.URL.Path, "/")
+	if parts[len(parts)-1] == "ALL" {
+		f.list(w, parts[len(parts)-2])
+		return
+	}
+
+	key := strings.Join(parts[len(parts)-3:], "/")
+	existing, exists := f.sets[key]
+
+	if m := r.Header.Get("If-Match"); m != "" && (!exists || existing.Etag != m) {
+		w.WriteHeader(http.StatusPreconditionFailed)
+		return
+	}
+	if r.Header.Get("If-None-Match") == etagAny && exists {
+		w.WriteHeader(http.StatusPreconditionFailed)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		if !exists {
+			w.WriteHeader(http.StatusNotFound)
+			fmt.Fprint(w, `{"error":{"code":"NotFound"}}`)
+			return
+		}
+		json.NewEncoder(w).Encode(existing)
+	case http.MethodPut:
+		set := storedSet{}
+		if err := json.NewDecoder(r.Body).Decode(&set); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		f.version++
+		set.Etag = fmt.Sprintf("etag-%d", f.version)
+		f.sets[key] = set
+		json.NewEncoder(w).Encode(set)
+	case http.MethodDelete:
+		delete(f.sets, key)
+	}
+}
+
+// list returns all record sets in the zone as a single page
+func (f *fakeARM) list(w http.ResponseWriter, zone string) {
+	// SDK types don't marshal the read-only name and type
+	type listedSet struct {
+		storedSet
+		Name string `json:"name"`
+		Type string `json:"type"`
+	}
+
+	sets := []listedSet{}
+	for key, set := range f.sets {
+		parts := strings.Split(key, "/")
+		if parts[0] != zone {
+			continue
+		}
+		sets = append(sets, listedSet{
+			storedSet: set,
+			Name:      parts[2],
+			Type:      "Microsoft.Network/privateDnsZones/" + parts[1],
+		})
+	}
+	json.NewEncoder(w).Encode(map[string]interface{}{"value": sets})
+}
+
+func (f *fakeARM) values(zone string, recordType privatedns.RecordType, name string) []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	set, ok := f.sets[fmt.Sprintf("%s/%s/%s", zone, recordType, name)]
+	if !ok {
+		return nil
+	}
+	values := recordValues(recordType, set.Properties)
+	sort.Strings(values)
+	return values
+}
+
+func newTestClient(t *testing.T) (*PrivateZones, *fakeARM) {
+	// Every zone has the SOA record set that the health check gets
+	fake := &fakeARM{sets: map[string]storedSet{
+		fmt.Sprintf("%s/%s/@", testZone, privatedns.SOA): {
+			Etag:       "etag-0",
+			Properties: &privatedns.RecordSetProperties{SoaRecord: &privatedns.SoaRecord{}},
+		},
+	}}
+	srv := httptest.NewServer(fake)
+	t.Cleanup(srv.Close)
+
+	api := privatedns.NewRecordSetsClientWithBaseURI(srv.URL, "sub")
+	api.Authorizer = autorest.NullAuthorizer{}
+	api.RetryAttempts = 1
+
+	return New(api, "rg", testZone, testRevZone), fake
+}
+
+func TestProvider(t *testing.T) {
+	pdnstest.Run(t, func(t *testing.T) pdnstest.Fake {
+		client, fake := newTestClient(t)
+		return pdnstest.Fake{
+			Provider: client,
+			Values: func(name, recType string) []string {
+				zone := testZone
+				if strings.HasSuffix(name, testRevZone+".") {
+					zone = testRevZone
+				}
+				return fake.values(zone, privatedns.RecordType(recType), strings.TrimSuffix(name, "."+zone+"."))
+			},
+			Writes: func() int { return fake.version },
+		}
+	})
+}
+
+func TestAAAARecord(t *testing.T) {
+	client, fake := newTestClient(t)
+
+	req := client.NewRequest()
+	req.AddRecord("pod-0.app.example.com", "10.0.0.1")
+	req.AddRecord("pod-0.app.example.com", "fd00::1")
+	pdnstest.Do(t, req)
+	pdnstest.AssertValues(t, fake.values(testZone, privatedns.A, "pod-0.app"), "10.0.0.1")
+	pdnstest.AssertValues(t, fake.values(testZone, privatedns.AAAA, "pod-0.app"), "fd00::1")
+	// IPv6 reverse name is not in the IPv4 reverse zone
+	pdnstest.AssertValues(t, fake.values(testRevZone, privatedns.PTR, "1.0.0.10"), "pod-0.app.example.com")
+
+	req = client.NewRequest()
+	req.RemoveRecord("pod-0.app.example.com", "fd00::1")
+	pdnstest.Do(t, req)
+	pdnstest.AssertValues(t, fake.values(testZone, privatedns.A, "pod-0.app"), "10.0.0.1")
+	pdnstest.AssertValues(t, fake.values(testZone, privatedns.AAAA, "pod-0.app"))
+}
+
+func TestConcurrentChange(t *testing.T) {
+	client, _ := newTestClient(t)
+
+	req := client.NewRequest()
+	req.AddToService("app.example.com", "10.0.0.1")
+	pdnstest.Do(t, req)
+
+	// Both requests read the same record set version
+	first := client.NewRequest()
+	first.AddToService("app.example.com", "10.0.0.2")
+	second := client.NewRequest()
+	second.AddToService("app.example.com", "10.0.0.3")
+
+	pdnstest.Do(t, first)
+	if err := second.Do(); err == nil {
+		t.Fatal("expected ETag mismatch to fail the request")
+	}
+}
+
+func TestCreatedAndRemoved(t *testing.T) {
+	client, fake := newTestClient(t)
+
+	// Record set created and removed in the same request is not written at all
+	req := client.NewRequest()
+	req.AddRecord("pod-0.app.example.com", "10.0.0.1")
+	req.RemoveRecord("pod-0.app.example.com", "10.0.0.1")
+	pdnstest.Do(t, req)
+	pdnstest.AssertValues(t, fake.values(testZone, privatedns.A, "pod-0.app"))
+
+	if fake.version != 0 {
+		t.Fatalf("expected no writes, got %d", fake.version)
+	}
+}
+
+func TestRelativeName(t *testing.T) {
+	cases := []struct {
+		domain, zone, name string
+		ok                 bool
+	}{
+		{"pod-0.app.example.com", "example.com", "pod-0.app", true},
+		{"pod-0.app.example.com.", "example.com.", "pod-0.app", true},
+		{"example.com", "example.com", "@", true},
+		{"pod-0.app.otherexample.com", "example.com", "", false},
+		{"pod-0.app.example.org", "example.com", "", false},
+	}
+
+	for _, c := range cases {
+		name, ok := relativeName(c.domain, c.zone)
+		if name != c.name || ok != c.ok {
+			t.Errorf("relativeName(%s, %s) = %s, %v", c.domain, c.zone, name, ok)
+		}
+	}
+}
@@ -0,0 +1,48 @@
+package azure
+
+import (
+	"errors"
+	"flag"
+
+	"github.com/tanelmae/private-dns/internal/pdns"
+)
+
+func init() {
+	pdns.Register(providerName, func() pdns.ProviderConfig { return &Options{} })
+}
+
+// Options holds Azure Private DNS provider configuration
+type Options struct {
+	Subscription  string `json:"subscription"`
+	ResourceGroup string `json:"resource-group"`
+	Zone          string `json:"zone"`
+	ReverseZone   string `json:"reverse-zone"`
+}
+
+// RegisterFlags adds Azure flags to the flag set
+func (o *Options) RegisterFlags(fs *flag.FlagSet) {
+	fs.StringVar(&o.Subscription, "azure-subscription", "", "Azure subscription of the private DNS zone. Defaults to the same as AKS cluster.")
+	fs.StringVar(&o.ResourceGroup, "azure-resource-group", "", "Azure resource group of the private DNS zone")
+	fs.StringVar(&o.Zone, "azure-zone", "", "Azure private DNS zone where to write the records")
+	fs.StringVar(&o.ReverseZone, "azure-reverse-zone", "", "Azure private DNS zone where to write the reverse lookup records")
+}
+
+// New creates Azure Private DNS client from the options
+func (o *Options) New() (pdns.DNSProvider, error) {
+	if o.Zone == "" || o.ResourceGroup == "" {
+		return nil, errors.New("-azure-zone and -azure-resource-group are required")
+	}
+
+	if o.Subscription == "" {
+		o.Subscription, _ = GetSubscription()
+	}
+
+	if o.Subscription == "" {
+		return nil, errors.New("Failed to resolve Azure subscription")
+	}
+	client, err := FromEnvironment(o.Subscription, o.ResourceGroup, o.Zone, o.ReverseZone)
+	if err != nil {
+		return nil, err
+	}
+	return client, nil
+}
@@ -0,0 +1,113 @@
+package azure
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const (
+	metadataURL        = "http://169.254.169.254/metadata/instance/compute?api-version=2019-08-15"
+	aksClusterTag      = "aks-managed-cluster-name"
+	aksNodeGroupPrefix = "MC_"
+)
+
+// computeMetadata is the subset of instance metadata the controller needs
+type computeMetadata struct {
+	Location          string `json:"location"`
+	ResourceGroupName string `json:"resourceGroupName"`
+	SubscriptionID    string `json:"subscriptionId"`
+	TagsList          []struct {
+		Name  string `json:"name"`
+		Value string `json:"value"`
+	} `json:"tagsList"`
+}
+
+// GetSubscription returns Azure subscription ID of the node
+func GetSubscription() (string, error) {
+	m, err := getMetadata()
+	if err != nil {
+		return "", err
+	}
+	return m.SubscriptionID, nil
+}
+
+// GetClusterName returns AKS cluster name.
+// Resolved from the node tags or from the node resource group name
+// which AKS creates as MC_<resource-group>_<cluster>_<location>.
+func GetClusterName() (string, error) {
+	m, err := getMetadata()
+	if err != nil {
+		return "", err
+	}
+
+	for _, t := range m.TagsList {
+		if t.Name == aksClusterTag && t.Value != "" {
+			return t.Value, nil
+		}
+	}
+
+	parts := strings.Split(m.ResourceGroupName, "_")
+	if !strings.HasPrefix(m.ResourceGroupName, aksNodeGroupPrefix) || len(parts) < 4 {
+		return "", fmt.Errorf("Failed to resolve AKS cluster name from %s resource group", m.ResourceGroupName)
+	}
+	return parts[len(parts)-2], nil
+}
+
+// GetClusterLocation returns AKS cluster location
+func GetClusterLocation() (string, error) {
+	m, err := getMetadata()
+	if err != nil {
+		return "", err
+	}
+	return m.Location, nil
+}
+
+// ClusterName returns AKS cluster name for subdomains
+func (c *PrivateZones) ClusterName() (string, error) {
+	return GetClusterName()
+}
+
+// ClusterLocation returns AKS cluster location for subdomains
+func (c *PrivateZones) ClusterLocation() (string, error) {
+	return GetClusterLocation()
+}
+
+func metadataRequest() (*computeMetadata, error) {
+	client := &http.Client{}
+	req, err := http.NewRequest("GET", metadataURL, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	req.Header.Add("Metadata", "true")
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("Azure metadata service returned %d", resp.StatusCode)
+	}
+
+	m := &computeMetadata{}
+	if err := json.NewDecoder(resp.Body).Decode(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func getMetadata() (*computeMetadata, error) {
+	for i := 1; i <= 3; i++ {
+		m, err := metadataRequest()
+		if err == nil {
+			return m, nil
+		}
+		time.Sleep(time.Second * time.Duration(i))
+	}
+	return nil, fmt.Errorf("Failed to resolve metadata from %s", metadataURL)
+}
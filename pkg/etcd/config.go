@@ -0,0 +1,62 @@
+package etcd
+
+import (
+	"errors"
+	"flag"
+	"os"
+	"strings"
+
+	"github.com/tanelmae/private-dns/internal/pdns"
+)
+
+func init() {
+	pdns.Register(providerName, func() pdns.ProviderConfig { return &Options{Prefix: defaultPrefix} })
+}
+
+// Options holds CoreDNS etcd provider configuration
+type Options struct {
+	Endpoints string `json:"endpoints"`
+	Prefix    string `json:"prefix"`
+	Reverse   bool   `json:"reverse"`
+	Username  string `json:"username"`
+	Password  string `json:"password"`
+	CertFile  string `json:"cert"`
+	KeyFile   string `json:"key"`
+	CAFile    string `json:"ca"`
+}
+
+// RegisterFlags adds etcd flags to the flag set
+func (o *Options) RegisterFlags(fs *flag.FlagSet) {
+	fs.StringVar(&o.Endpoints, "etcd-endpoints", "", "Comma separated etcd endpoints used by CoreDNS etcd plugin")
+	fs.StringVar(&o.Prefix, "etcd-prefix", o.Prefix, "Path prefix configured for CoreDNS etcd plugin")
+	fs.BoolVar(&o.Reverse, "etcd-reverse", false, "Write PTR records for the reverse lookup")
+	fs.StringVar(&o.Username, "etcd-username", "", "etcd username")
+	fs.StringVar(&o.Password, "etcd-password", "", "etcd password. Defaults to ETCD_PASSWORD environment variable.")
+	fs.StringVar(&o.CertFile, "etcd-cert", "", "Path to etcd client certificate")
+	fs.StringVar(&o.KeyFile, "etcd-key", "", "Path to etcd client key")
+	fs.StringVar(&o.CAFile, "etcd-ca", "", "Path to etcd CA certificate")
+}
+
+// New connects to etcd with the options
+func (o *Options) New() (pdns.DNSProvider, error) {
+	if o.Endpoints == "" {
+		return nil, errors.New("-etcd-endpoints is required")
+	}
+
+	if o.Password == "" {
+		o.Password = os.Getenv("ETCD_PASSWORD")
+	}
+
+	client, err := Connect(Config{
+		Endpoints: strings.Split(o.Endpoints, ","),
+		Username:  o.Username,
+		Password:  o.Password,
+		CertFile:  o.CertFile,
+		KeyFile:   o.KeyFile,
+		CAFile:    o.CAFile,
+	}, o.Prefix, o.Reverse)
+	if err != nil {
+		return nil, err
+	}
+	return client, nil
+}
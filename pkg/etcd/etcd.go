@@ -0,0 +1,362 @@
+package etcd
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/tanelmae/private-dns/internal/metrics"
+	"github.com/tanelmae/private-dns/internal/pdns"
+	"go.etcd.io/etcd/clientv3"
+	"go.etcd.io/etcd/pkg/transport"
+	"k8s.io/klog/v2"
+)
+
+const (
+	providerName = "etcd"
+
+	defaultPrefix = "/skydns"
+
+	requestTimeout = 10 * time.Second
+
+	// Key under the pod record for its IPv6 address
+	ipv6Entry = "aaaa"
+)
+
+// service is the SkyDNS message format read by CoreDNS etcd plugin
+type service struct {
+	Host     string `json:"host,omitempty"`
+	Port     int    `json:"port,omitempty"`
+	Priority int    `json:"priority,omitempty"`
+	Weight   int    `json:"weight,omitempty"`
+	TTL      uint32 `json:"ttl,omitempty"`
+}
+
+// Config holds etcd connection settings
+type Config struct {
+	Endpoints []string
+	Username  string
+	Password  string
+	CertFile  string
+	KeyFile   string
+	CAFile    string
+}
+
+// SkyDNS writes records as SkyDNS keys for CoreDNS etcd plugin
+type SkyDNS struct {
+	api     *clientv3.Client
+	prefix  string
+	reverse bool
+}
+
+// Connect creates etcd client with given configuration
+func Connect(conf Config, prefix string, reverse bool) (*SkyDNS, error) {
+	clientConf := clientv3.Config{
+		Endpoints:   conf.Endpoints,
+		Username:    conf.Username,
+		Password:    conf.Password,
+		DialTimeout: requestTimeout,
+	}
+
+	if conf.CertFile != "" || conf.CAFile != "" {
+		tlsInfo := transport.TLSInfo{
+			CertFile:      conf.CertFile,
+			KeyFile:       conf.KeyFile,
+			TrustedCAFile: conf.CAFile,
+		}
+		tlsConf, err := tlsInfo.ClientConfig()
+		if err != nil {
+			return nil, err
+		}
+		clientConf.TLS = tlsConf
+	}
+
+	api, err := clientv3.New(clientConf)
+	if err != nil {
+		return nil, err
+	}
+
+	return New(api, prefix, reverse), nil
+}
+
+// New creates SkyDNS client instance from an existing etcd client.
+// PTR records are only written when reverse is enabled.
+func New(api *clientv3.Client, prefix string, reverse bool) *SkyDNS {
+	if prefix == "" {
+		prefix = defaultPrefix
+	}
+
+	return &SkyDNS{
+		api:     api,
+		prefix:  path.Clean("/" + prefix),
+		reverse: reverse,
+	}
+}
+
+// key returns the SkyDNS path for the domain. Labels are reversed
+// so pod-0.app.example.com is stored in /skydns/com/example/app/pod-0.
+func (c *SkyDNS) key(domain string) string {
+	labels := strings.Split(strings.ToLower(strings.TrimSuffix(domain, ".")), ".")
+	for i, j := 0, len(labels)-1; i < j; i, j = i+1, j-1 {
+		labels[i], labels[j] = labels[j], labels[i]
+	}
+	return path.Join(append([]string{c.prefix}, labels...)...)
+}
+
+// addressKey returns the SkyDNS path for the pod record with the IP.
+// IPv4 address is stored in the key of the domain and IPv6 address
+// in a key under it so a dual-stack pod resolves with both.
+func (c *SkyDNS) addressKey(domain, ip string) string {
+	if pdns.IsIPv6(ip) {
+		return path.Join(c.key(domain), ipv6Entry)
+	}
+	return c.key(domain)
+}
+
+// reverseKey returns the SkyDNS path for the reverse lookup of the IP.
+// 10.0.0.1 resolves as 1.0.0.10.in-addr.arpa. which is stored in /skydns/arpa/in-addr/10/0/0/1.
+// IPv6 addresses are stored under /skydns/arpa/ip6 one nibble per label.
+func (c *SkyDNS) reverseKey(ip string) (string, bool) {
+	name, ok := pdns.ReverseName(ip)
+	if !ok {
+		return "", false
+	}
+	return c.key(name), true
+}
+
+// checkForRec returns the stored message and its modification revision.
+// Nil is returned when the key doesn't exist.
+func (c *SkyDNS) checkForRec(key string) (*service, int64) {
+	ctx, cancel := context.WithTimeout(context.Background(), requestTimeout)
+	defer cancel()
+
+	start := time.Now()
+	resp, err := c.api.Get(ctx, key)
+	metrics.ObserveCall(providerName, "get", c.prefix, start, err)
+	if err != nil {
+		klog.Errorln(err)
+		return nil, 0
+	}
+
+	if len(resp.Kvs) == 0 {
+		return nil, 0
+	}
+
+	svc := &service{}
+	if err := json.Unmarshal(resp.Kvs[0].Value, svc); err != nil {
+		klog.Errorf("Invalid SkyDNS message in %s: %s\n", key, err)
+		return nil, 0
+	}
+	return svc, resp.Kvs[0].ModRevision
+}
+
+// CheckHealth reads a single key under the prefix to verify the connection and the credentials
+func (c *SkyDNS) CheckHealth() error {
+	ctx, cancel := context.WithTimeout(context.Background(), requestTimeout)
+	defer cancel()
+
+	start := time.Now()
+	_, err := c.api.Get(ctx, c.prefix, clientv3.WithPrefix(), clientv3.WithKeysOnly(), clientv3.WithLimit(1))
+	metrics.ObserveCall(providerName, "get", c.prefix, start, err)
+	return err
+}
+
+// NewRequest creates a new etcd transaction for the records
+func (c *SkyDNS) NewRequest() pdns.DNSRequest {
+	return &DNSRequest{
+		client: c,
+	}
+}
+
+// DNSRequest holds the changes that are written in a single etcd transaction.
+// Records that were read to decide on a change must be unmodified for the transaction to succeed.
+type DNSRequest struct {
+	client *SkyDNS
+	ttl    pdns.TTL
+	cmps   []clientv3.Cmp
+	ops    []clientv3.Op
+}
+
+// SetTTL sets the TTL of the records added in the request
+func (d *DNSRequest) SetTTL(ttl pdns.TTL) {
+	d.ttl = ttl
+}
+
+// Do commits the transaction with all the attached changes
+// No error would be returned when no changes have been added
+func (d *DNSRequest) Do() (err error) {
+	if len(d.ops) == 0 {
+		return nil
+	}
+	defer metrics.ObserveRequest(providerName, time.Now(), &err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), requestTimeout)
+	defer cancel()
+
+	start := time.Now()
+	resp, err := d.client.api.Txn(ctx).If(d.cmps...).Then(d.ops...).Commit()
+	metrics.ObserveCall(providerName, "txn", d.client.prefix, start, err)
+	if err != nil {
+		return err
+	}
+
+	if !resp.Succeeded {
+		return fmt.Errorf("Records under %s were modified concurrently", d.client.prefix)
+	}
+	return nil
+}
+
+// Changes returns the keys the transaction would put and delete
+func (d *DNSRequest) Changes() []string {
+	changes := []string{}
+	for _, op := range d.ops {
+		if op.IsDelete() {
+			changes = append(changes, fmt.Sprintf("delete %s", op.KeyBytes()))
+			continue
+		}
+		changes = append(changes, fmt.Sprintf("put %s %s", op.KeyBytes(), op.ValueBytes()))
+	}
+	return changes
+}
+
+// unmodified guards the change with the revision the decision was based on
+func (d *DNSRequest) unmodified(key string, rev int64) {
+	d.cmps = append(d.cmps, clientv3.Compare(clientv3.ModRevision(key), "=", rev))
+}
+
+func (d *DNSRequest) put(key string, kind pdns.RecordKind, svc *service) {
+	svc.TTL = uint32(d.ttl.For(kind))
+	value, err := json.Marshal(svc)
+	if err != nil {
+		klog.Errorln(err)
+		return
+	}
+	d.ops = append(d.ops, clientv3.OpPut(key, string(value)))
+}
+
+func (d *DNSRequest) delete(key string) {
+	d.ops = append(d.ops, clientv3.OpDelete(key))
+}
+
+// AddRecord adds A or AAAA record with single IP
+func (d *DNSRequest) AddRecord(domain, ip string) {
+	key := d.client.addressKey(domain, ip)
+
+	oldRec, rev := d.client.checkForRec(key)
+
+	if oldRec != nil && oldRec.Host == ip {
+		klog.V(2).Infof("Record exists: %s/%s\n", domain, ip)
+		return
+	}
+
+	// Stale record gets overwritten
+	if oldRec != nil {
+		klog.V(2).Infof("Stale record found: %s/%s\n", domain, oldRec.Host)
+	}
+	d.unmodified(key, rev)
+	d.put(key, pdns.KindPod, &service{Host: ip})
+
+	if d.client.reverse {
+		d.AddReverseRecord(domain, ip)
+	}
+}
+
+// RemoveRecord deletes A or AAAA record with a single IP
+func (d *DNSRequest) RemoveRecord(domain, ip string) {
+	key := d.client.addressKey(domain, ip)
+
+	oldRec, rev := d.client.checkForRec(key)
+	if oldRec == nil {
+		klog.V(2).Infof("No DNS record found for %s/%s", domain, ip)
+		return
+	}
+
+	// If records and pods have somehow got into inconsistent state
+	// we avoid deleting records that don't match the event.
+	if oldRec.Host != ip {
+		klog.V(2).Infof("No DNS record found for %s with the same IP (%s)", domain, ip)
+		return
+	}
+	d.unmodified(key, rev)
+	d.delete(key)
+
+	if d.client.reverse {
+		d.RemoveReverseRecord(domain, ip)
+	}
+}
+
+// AddReverseRecord adds a PTR record for the reverse lookup
+func (d *DNSRequest) AddReverseRecord(domain, ip string) {
+	key, ok := d.client.reverseKey(ip)
+	if !ok {
+		klog.Errorf("Invalid IP address for %s: %s\n", domain, ip)
+		return
+	}
+
+	oldRec, rev := d.client.checkForRec(key)
+
+	if oldRec != nil && oldRec.Host == domain {
+		klog.V(2).Infof("Record exists: %s/%s\n", ip, domain)
+		return
+	}
+
+	d.unmodified(key, rev)
+	d.put(key, pdns.KindPTR, &service{Host: domain})
+}
+
+// RemoveReverseRecord removes a PTR record from the reverse lookup zone
+func (d *DNSRequest) RemoveReverseRecord(domain, ip string) {
+	key, ok := d.client.reverseKey(ip)
+	if !ok {
+		klog.Errorf("Invalid IP address for %s: %s\n", domain, ip)
+		return
+	}
+
+	oldRec, rev := d.client.checkForRec(key)
+	if oldRec == nil || oldRec.Host != domain {
+		klog.V(2).Infof("No PTR record found for %s with the same domain (%s)", ip, domain)
+		return
+	}
+	d.unmodified(key, rev)
+	d.delete(key)
+}
+
+// AddToService adds the given IP to A or AAAA record with multiple IPs.
+// Every IP is a separate key under the service name.
+func (d *DNSRequest) AddToService(domain, ip string) {
+	d.put(path.Join(d.client.key(domain), entryID(ip)), pdns.KindService, &service{Host: ip})
+}
+
+// RemoveFromService removes given IP from an A or AAAA record with multiple IPs
+func (d *DNSRequest) RemoveFromService(domain, ip string) {
+	d.delete(path.Join(d.client.key(domain), entryID(ip)))
+}
+
+// AddToSRV adds target to SRV record
+// Every target is a separate key under the SRV name.
+func (d *DNSRequest) AddToSRV(srv string, data pdns.SRV) {
+	d.put(path.Join(d.client.key(srv), entryID(data.Target)), pdns.KindSRV, &service{
+		Host:     data.Target,
+		Port:     data.Port,
+		Priority: data.Priority,
+		Weight:   data.Weight,
+	})
+}
+
+// RemoveFromSRV removes domain from SRV record
+func (d *DNSRequest) RemoveFromSRV(srv, domain string) {
+	d.delete(path.Join(d.client.key(srv), entryID(domain)))
+}
+
+// UTILS
+
+// entryID returns a stable key for one of the multiple values of a name
+func entryID(value string) string {
+	h := fnv.New32a()
+	h.Write([]byte(strings.ToLower(value)))
+	return fmt.Sprintf("%x", h.Sum32())
+}
@@ -0,0 +1,179 @@
+package etcd
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/tanelmae/private-dns/internal/pdns"
+	"github.com/tanelmae/private-dns/internal/pdns/pdnstest"
+	"go.etcd.io/etcd/clientv3"
+	"go.etcd.io/etcd/embed"
+)
+
+func freeURL(t *testing.T) url.URL {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	return url.URL{Scheme: "http", Host: l.Addr().String()}
+}
+
+func newTestClient(t *testing.T) *SkyDNS {
+	cfg := embed.NewConfig()
+	cfg.Dir = t.TempDir()
+	if err := os.Chmod(cfg.Dir, 0700); err != nil {
+		t.Fatal(err)
+	}
+	cfg.Logger = "zap"
+	cfg.LogLevel = "error"
+	cfg.LCUrls = []url.URL{freeURL(t)}
+	cfg.ACUrls = cfg.LCUrls
+	cfg.LPUrls = []url.URL{freeURL(t)}
+	cfg.APUrls = cfg.LPUrls
+	cfg.InitialCluster = cfg.InitialClusterFromName(cfg.Name)
+
+	e, err := embed.StartEtcd(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(e.Close)
+
+	select {
+	case <-e.Server.ReadyNotify():
+	case <-time.After(30 * time.Second):
+		t.Fatal("embedded etcd didn't start")
+	}
+
+	api, err := clientv3.New(clientv3.Config{
+		Endpoints:   []string{cfg.LCUrls[0].String()},
+		DialTimeout: 5 * time.Second,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { api.Close() })
+
+	return New(api, "", true)
+}
+
+// entries returns the hosts stored in the key and under it
+func entries(t *testing.T, c *SkyDNS, key string) []string {
+	t.Helper()
+	resp, err := c.api.Get(context.Background(), key, clientv3.WithPrefix())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	values := []string{}
+	for _, kv := range resp.Kvs {
+		svc := service{}
+		if err := json.Unmarshal(kv.Value, &svc); err != nil {
+			t.Fatal(err)
+		}
+		if svc.TTL != pdns.DefaultTTL {
+			t.Fatalf("expected TTL %d in %s", pdns.DefaultTTL, kv.Value)
+		}
+		values = append(values, fmt.Sprintf("%s %s", kv.Key, svc.Host))
+	}
+	sort.Strings(values)
+	return values
+}
+
+func TestKeys(t *testing.T) {
+	c := New(nil, "", false)
+
+	if key := c.key("pod-0.app.Example.com."); key != "/skydns/com/example/app/pod-0" {
+		t.Fatalf("unexpected key %s", key)
+	}
+	if key, _ := c.reverseKey("10.0.0.1"); key != "/skydns/arpa/in-addr/10/0/0/1" {
+		t.Fatalf("unexpected reverse key %s", key)
+	}
+	if key, _ := c.reverseKey("fd00::1"); key != "/skydns/arpa/ip6/f/d/0/0/0/0/0/0/0/0/0/0/0/0/0/0/0/0/0/0/0/0/0/0/0/0/0/0/0/0/0/1" {
+		t.Fatalf("unexpected IPv6 reverse key %s", key)
+	}
+	if key := c.addressKey("pod-0.app.example.com", "fd00::1"); key != "/skydns/com/example/app/pod-0/aaaa" {
+		t.Fatalf("unexpected IPv6 key %s", key)
+	}
+	if key := New(nil, "coredns/", false).key("example.com"); key != "/coredns/com/example" {
+		t.Fatalf("unexpected key with custom prefix %s", key)
+	}
+}
+
+func TestRecords(t *testing.T) {
+	c := newTestClient(t)
+
+	req := c.NewRequest()
+	req.AddRecord("pod-0.app.example.com", "10.0.0.1")
+	pdnstest.Do(t, req)
+	pdnstest.AssertValues(t, entries(t, c, "/skydns/com/example/app/pod-0"), "/skydns/com/example/app/pod-0 10.0.0.1")
+	pdnstest.AssertValues(t, entries(t, c, "/skydns/arpa/in-addr/10/0/0/1"), "/skydns/arpa/in-addr/10/0/0/1 pod-0.app.example.com")
+
+	// Stale record gets replaced
+	req = c.NewRequest()
+	req.AddRecord("pod-0.app.example.com", "10.0.0.2")
+	pdnstest.Do(t, req)
+	pdnstest.AssertValues(t, entries(t, c, "/skydns/com/example/app/pod-0"), "/skydns/com/example/app/pod-0 10.0.0.2")
+
+	// Record with other IP is left alone
+	req = c.NewRequest()
+	req.RemoveRecord("pod-0.app.example.com", "10.0.0.1")
+	pdnstest.Do(t, req)
+	pdnstest.AssertValues(t, entries(t, c, "/skydns/com/example/app/pod-0"), "/skydns/com/example/app/pod-0 10.0.0.2")
+
+	req = c.NewRequest()
+	req.RemoveRecord("pod-0.app.example.com", "10.0.0.2")
+	pdnstest.Do(t, req)
+	pdnstest.AssertValues(t, entries(t, c, "/skydns/com/example/app/pod-0"))
+	pdnstest.AssertValues(t, entries(t, c, "/skydns/arpa/in-addr/10/0/0/2"))
+}
+
+func TestServiceAndSRV(t *testing.T) {
+	c := newTestClient(t)
+
+	for i, ip := range []string{"10.0.0.1", "10.0.0.2"} {
+		req := c.NewRequest()
+		req.AddToService("app.example.com", ip)
+		req.AddToSRV("_http._tcp.example.com", pdns.SRV{Priority: 1, Target: fmt.Sprintf("pod-%d.app.example.com", i)})
+		pdnstest.Do(t, req)
+	}
+
+	svc := fmt.Sprintf("/skydns/com/example/app/%s", entryID("10.0.0.1"))
+	pdnstest.AssertValues(t, entries(t, c, "/skydns/com/example/app/"),
+		svc+" 10.0.0.1", fmt.Sprintf("/skydns/com/example/app/%s 10.0.0.2", entryID("10.0.0.2")))
+	pdnstest.AssertValues(t, entries(t, c, "/skydns/com/example/_tcp/_http/"),
+		fmt.Sprintf("/skydns/com/example/_tcp/_http/%s pod-0.app.example.com", entryID("pod-0.app.example.com")),
+		fmt.Sprintf("/skydns/com/example/_tcp/_http/%s pod-1.app.example.com", entryID("pod-1.app.example.com")))
+
+	req := c.NewRequest()
+	req.RemoveFromService("app.example.com", "10.0.0.2")
+	req.RemoveFromSRV("_http._tcp.example.com", "pod-1.app.example.com")
+	pdnstest.Do(t, req)
+	pdnstest.AssertValues(t, entries(t, c, "/skydns/com/example/app/"), svc+" 10.0.0.1")
+	pdnstest.AssertValues(t, entries(t, c, "/skydns/com/example/_tcp/_http/"),
+		fmt.Sprintf("/skydns/com/example/_tcp/_http/%s pod-0.app.example.com", entryID("pod-0.app.example.com")))
+}
+
+func TestConcurrentChange(t *testing.T) {
+	c := newTestClient(t)
+
+	req := c.NewRequest()
+	req.AddRecord("pod-0.app.example.com", "10.0.0.1")
+
+	// Record is changed after the request has checked it
+	other := c.NewRequest()
+	other.AddRecord("pod-0.app.example.com", "10.0.0.2")
+	pdnstest.Do(t, other)
+
+	if err := req.Do(); err == nil {
+		t.Fatal("expected concurrent change to fail the transaction")
+	}
+	pdnstest.AssertValues(t, entries(t, c, "/skydns/com/example/app/pod-0"), "/skydns/com/example/app/pod-0 10.0.0.2")
+}
@@ -0,0 +1,111 @@
+package fanout
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+
+	"github.com/tanelmae/private-dns/internal/pdns"
+	"sigs.k8s.io/yaml"
+)
+
+func init() {
+	pdns.Register(providerName, func() pdns.ProviderConfig { return &Options{} })
+}
+
+// Config is the fan-out configuration file
+type Config struct {
+	Policy    Policy         `json:"policy"`
+	Providers []ProviderSpec `json:"providers"`
+}
+
+// ProviderSpec configures one of the backends.
+// Config has the same fields as the provider flags without the provider prefix.
+type ProviderSpec struct {
+	Name     string          `json:"name"`
+	Provider string          `json:"provider"`
+	Config   json.RawMessage `json:"config"`
+}
+
+// Options holds fan-out provider configuration
+type Options struct {
+	ConfigFile string `json:"config"`
+}
+
+// RegisterFlags adds fan-out flags to the flag set
+func (o *Options) RegisterFlags(fs *flag.FlagSet) {
+	fs.StringVar(&o.ConfigFile, "fanout-config", "", "Path to YAML or JSON file with the DNS providers where to publish the records")
+}
+
+// New creates all the configured providers
+func (o *Options) New() (pdns.DNSProvider, error) {
+	if o.ConfigFile == "" {
+		return nil, errors.New("-fanout-config is required")
+	}
+
+	data, err := ioutil.ReadFile(o.ConfigFile)
+	if err != nil {
+		return nil, err
+	}
+
+	conf := Config{}
+	if err := yaml.Unmarshal(data, &conf); err != nil {
+		return nil, fmt.Errorf("Invalid config file %s: %s", o.ConfigFile, err)
+	}
+	return FromConfig(conf)
+}
+
+// FromConfig creates fan-out provider with the backends in the configuration
+func FromConfig(conf Config) (pdns.DNSProvider, error) {
+	if conf.Policy == "" {
+		conf.Policy = BestEffort
+	}
+
+	if conf.Policy != BestEffort && conf.Policy != AllOrNothing {
+		return nil, fmt.Errorf("Unknown policy %q. Supported policies: %s, %s", conf.Policy, BestEffort, AllOrNothing)
+	}
+
+	if len(conf.Providers) == 0 {
+		return nil, errors.New("No DNS providers configured")
+	}
+
+	backends := []Backend{}
+	names := make(map[string]bool)
+	for _, spec := range conf.Providers {
+		if spec.Name == "" {
+			spec.Name = spec.Provider
+		}
+
+		if names[spec.Name] {
+			return nil, fmt.Errorf("DNS provider name %s is used more than once", spec.Name)
+		}
+		names[spec.Name] = true
+
+		provider, err := newProvider(spec)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to create %s DNS provider: %s", spec.Name, err)
+		}
+		backends = append(backends, Backend{Name: spec.Name, Provider: provider})
+	}
+	return clusterAware(New(conf.Policy, backends...)), nil
+}
+
+func newProvider(spec ProviderSpec) (pdns.DNSProvider, error) {
+	providerConf, err := pdns.NewConfig(spec.Provider)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(spec.Config) > 0 {
+		// Typos in the field names would otherwise go unnoticed
+		dec := json.NewDecoder(bytes.NewReader(spec.Config))
+		dec.DisallowUnknownFields()
+		if err := dec.Decode(providerConf); err != nil {
+			return nil, err
+		}
+	}
+	return providerConf.New()
+}
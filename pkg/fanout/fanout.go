@@ -0,0 +1,501 @@
+package fanout
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/tanelmae/private-dns/internal/metrics"
+	"github.com/tanelmae/private-dns/internal/pdns"
+	"k8s.io/klog/v2"
+)
+
+const providerName = "fanout"
+
+// Policy decides what happens when some of the providers fail
+type Policy string
+
+const (
+	// BestEffort applies the changes to every provider it can
+	BestEffort Policy = "best-effort"
+	// AllOrNothing reverts the changes when any of the providers fails
+	AllOrNothing Policy = "all-or-nothing"
+)
+
+// Backend is a named DNS provider the records are published to
+type Backend struct {
+	Name     string
+	Provider pdns.DNSProvider
+}
+
+// ProviderError is a failure of a single provider
+type ProviderError struct {
+	Provider string
+	Err      error
+}
+
+func (e ProviderError) Error() string {
+	return fmt.Sprintf("%s: %s", e.Provider, e.Err)
+}
+
+// Errors holds the failures of all the providers of a request
+type Errors []ProviderError
+
+func (e Errors) Error() string {
+	msgs := []string{}
+	for _, err := range e {
+		msgs = append(msgs, err.Error())
+	}
+	return strings.Join(msgs, "; ")
+}
+
+// FanOut publishes the same records to several DNS providers
+type FanOut struct {
+	backends []Backend
+	policy   Policy
+}
+
+// New creates fan-out provider for the backends.
+// Changes are applied to the backends in the given order.
+func New(policy Policy, backends ...Backend) *FanOut {
+	if policy == "" {
+		policy = BestEffort
+	}
+
+	return &FanOut{
+		backends: backends,
+		policy:   policy,
+	}
+}
+
+// CheckHealth checks all the backends that support it.
+// With best-effort policy the provider is healthy while any of the backends is.
+func (c *FanOut) CheckHealth() error {
+	errs := Errors{}
+	checked := 0
+
+	for _, b := range c.backends {
+		checker, ok := b.Provider.(pdns.HealthChecker)
+		if !ok {
+			continue
+		}
+		checked++
+
+		if err := checker.CheckHealth(); err != nil {
+			klog.Warningf("DNS provider %s is not healthy: %s\n", b.Name, err)
+			errs = append(errs, ProviderError{Provider: b.Name, Err: err})
+		}
+	}
+
+	if len(errs) == 0 || (c.policy == BestEffort && len(errs) < checked) {
+		return nil
+	}
+	return errs
+}
+
+// ReplicaLocal tells if any of the backends is local to this replica
+func (c *FanOut) ReplicaLocal() bool {
+	for _, b := range c.backends {
+		if local, ok := b.Provider.(pdns.ReplicaLocal); ok && local.ReplicaLocal() {
+			return true
+		}
+	}
+	return false
+}
+
+// NewRequest creates a request for every backend
+func (c *FanOut) NewRequest() pdns.DNSRequest {
+	d := &DNSRequest{client: c}
+	for _, b := range c.backends {
+		d.requests = append(d.requests, b.Provider.NewRequest())
+	}
+	return d
+}
+
+// ListRecords returns the records found in every backend that can list the zone.
+// Record missing from some backend is left out so the reconciliation adds it again.
+// Stale record left in only some of the backends is removed by the garbage collection.
+func (c *FanOut) ListRecords(domain string) ([]pdns.Record, error) {
+	var found map[string]int
+	var records map[string]pdns.Record
+	listed := 0
+
+	for _, b := range c.backends {
+		lister, ok := b.Provider.(pdns.RecordLister)
+		if !ok {
+			continue
+		}
+
+		backendRecords, err := lister.ListRecords(domain)
+		if err != nil {
+			return nil, ProviderError{Provider: b.Name, Err: err}
+		}
+		listed++
+
+		existing := newRecordSet(backendRecords)
+		if found == nil {
+			found = make(map[string]int)
+			records = make(map[string]pdns.Record)
+			for key, r := range existing {
+				records[key] = r
+			}
+		}
+		for key := range existing {
+			found[key]++
+		}
+	}
+
+	if listed == 0 {
+		return nil, fmt.Errorf("None of the DNS providers can list the records")
+	}
+
+	merged := map[string]*pdns.Record{}
+	keys := []string{}
+	for key, r := range records {
+		if found[key] != listed {
+			continue
+		}
+		setKey := fmt.Sprintf("%s|%s", strings.ToLower(r.Name), r.Type)
+		if _, ok := merged[setKey]; !ok {
+			merged[setKey] = &pdns.Record{Name: r.Name, Type: r.Type}
+			keys = append(keys, setKey)
+		}
+		merged[setKey].Values = append(merged[setKey].Values, r.Values...)
+	}
+
+	sort.Strings(keys)
+	list := []pdns.Record{}
+	for _, key := range keys {
+		list = append(list, *merged[key])
+	}
+	return list, nil
+}
+
+// OwnerID returns the owner ID of the first backend that keeps the record ownership
+func (c *FanOut) OwnerID() string {
+	for _, b := range c.backends {
+		if registry, ok := b.Provider.(pdns.OwnerRegistry); ok && registry.OwnerID() != "" {
+			return registry.OwnerID()
+		}
+	}
+	return ""
+}
+
+// OwnedRecords returns the owned records of all the backends that keep the record ownership.
+// Values found in several backends are returned once.
+func (c *FanOut) OwnedRecords() ([]pdns.OwnedRecord, error) {
+	records := []pdns.OwnedRecord{}
+	index := map[string]int{}
+	seen := map[string]bool{}
+
+	for _, b := range c.backends {
+		registry, ok := b.Provider.(pdns.OwnerRegistry)
+		if !ok || registry.OwnerID() == "" {
+			continue
+		}
+
+		owned, err := registry.OwnedRecords()
+		if err != nil {
+			return nil, ProviderError{Provider: b.Name, Err: err}
+		}
+
+		for _, r := range owned {
+			key := fmt.Sprintf("%s|%s|%s", strings.ToLower(r.Name), r.Type, r.Owner)
+			i, ok := index[key]
+			if !ok {
+				i = len(records)
+				index[key] = i
+				records = append(records, pdns.OwnedRecord{Record: pdns.Record{Name: r.Name, Type: r.Type}, Owner: r.Owner})
+			}
+
+			for _, v := range r.Values {
+				if vk := valueKey(r.Name, r.Type, v); !seen[key+"|"+vk] {
+					seen[key+"|"+vk] = true
+					records[i].Values = append(records[i].Values, v)
+				}
+			}
+		}
+	}
+	return records, nil
+}
+
+// recordSet maps every value in the records to a single value record
+type recordSet map[string]pdns.Record
+
+func newRecordSet(records []pdns.Record) recordSet {
+	set := recordSet{}
+	for _, r := range records {
+		for _, v := range r.Values {
+			set[valueKey(r.Name, r.Type, v)] = pdns.Record{Name: r.Name, Type: r.Type, Values: []string{v}}
+		}
+	}
+	return set
+}
+
+// valueKey identifies the record value. SRV records are compared by the target
+// as the providers store the rest of the data differently.
+func valueKey(name, recType, value string) string {
+	if recType == "SRV" {
+		value = pdns.SRVTarget(value)
+	}
+	return fmt.Sprintf("%s|%s|%s", strings.ToLower(strings.TrimSuffix(name, ".")), recType, value)
+}
+
+// operation is a change and the change that reverts it.
+// Removals are not reverted as that would publish IPs of pods that are gone.
+// Name, type and value are of the record the change adds. Change is reverted only
+// when the record didn't exist before so the shared service and SRV records keep
+// the values of the other pods.
+type operation struct {
+	apply   func(pdns.DNSRequest)
+	undo    func(pdns.DNSRequest)
+	name    string
+	recType string
+	value   string
+}
+
+// DNSRequest passes every change to the requests of all the backends
+type DNSRequest struct {
+	client    *FanOut
+	requests  []pdns.DNSRequest
+	ops       []operation
+	created   [][]operation
+	namespace string
+	name      string
+}
+
+// SetResource passes the PrivateDNS resource to the backends that track the record ownership
+func (d *DNSRequest) SetResource(namespace, name string) {
+	d.namespace = namespace
+	d.name = name
+	for _, req := range d.requests {
+		d.setResource(req)
+	}
+}
+
+func (d *DNSRequest) setResource(req pdns.DNSRequest) {
+	if owned, ok := req.(pdns.OwnedRequest); ok && d.name != "" {
+		owned.SetResource(d.namespace, d.name)
+	}
+}
+
+// SetTTL passes the TTL of the added records to the requests of all the backends
+func (d *DNSRequest) SetTTL(ttl pdns.TTL) {
+	for _, req := range d.requests {
+		req.SetTTL(ttl)
+	}
+}
+
+func (d *DNSRequest) add(op operation) {
+	for _, req := range d.requests {
+		op.apply(req)
+	}
+	d.ops = append(d.ops, op)
+}
+
+// Do makes the requests of all the backends in order.
+// With all-or-nothing policy the first failure stops the request
+// and the changes are reverted on the backends where they succeeded.
+func (d *DNSRequest) Do() (err error) {
+	defer metrics.ObserveRequest(providerName, time.Now(), &err)
+	errs := Errors{}
+
+	for i, req := range d.requests {
+		name := d.client.backends[i].Name
+
+		if d.client.policy == AllOrNothing {
+			d.created = append(d.created, d.newRecords(d.client.backends[i]))
+		}
+
+		err := req.Do()
+		if err == nil {
+			continue
+		}
+
+		klog.Errorf("DNS provider %s failed: %s\n", name, err)
+		errs = append(errs, ProviderError{Provider: name, Err: err})
+
+		if d.client.policy == AllOrNothing {
+			errs = append(errs, d.rollback(i-1)...)
+			break
+		}
+	}
+
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+// Changes returns the changes of every backend prefixed with the backend name.
+// Only the number of the requested changes is known of backends that can't describe theirs.
+func (d *DNSRequest) Changes() []string {
+	changes := []string{}
+	for i, req := range d.requests {
+		name := d.client.backends[i].Name
+		if describer, ok := req.(pdns.ChangeDescriber); ok {
+			for _, c := range describer.Changes() {
+				changes = append(changes, fmt.Sprintf("%s: %s", name, c))
+			}
+			continue
+		}
+		changes = append(changes, fmt.Sprintf("%s: make %d requested changes", name, len(d.ops)))
+	}
+	return changes
+}
+
+// newRecords returns the revertable operations that add records the backend doesn't have yet.
+// Nothing is reverted on backends that can't list their records.
+func (d *DNSRequest) newRecords(b Backend) []operation {
+	lister, ok := b.Provider.(pdns.RecordLister)
+	if !ok {
+		return nil
+	}
+
+	existing := recordSet{}
+	listed := map[string]bool{}
+	for _, op := range d.ops {
+		if op.undo == nil || listed[op.name] {
+			continue
+		}
+		listed[op.name] = true
+
+		records, err := lister.ListRecords(op.name)
+		if err != nil {
+			klog.Errorf("Failed to list %s records in DNS provider %s. Changes can't be reverted: %s\n",
+				op.name, b.Name, err)
+			return nil
+		}
+		for key, r := range newRecordSet(records) {
+			existing[key] = r
+		}
+	}
+
+	created := []operation{}
+	for _, op := range d.ops {
+		if op.undo == nil {
+			continue
+		}
+		if _, found := existing[valueKey(op.name, op.recType, op.value)]; !found {
+			created = append(created, op)
+		}
+	}
+	return created
+}
+
+// rollback reverts the additions on the backends up to the given one.
+// Only the records that didn't exist before the request are removed.
+func (d *DNSRequest) rollback(last int) Errors {
+	errs := Errors{}
+
+	for i := last; i >= 0; i-- {
+		b := d.client.backends[i]
+		created := d.created[i]
+		if len(created) == 0 {
+			continue
+		}
+		klog.Warningf("Reverting changes in DNS provider %s\n", b.Name)
+
+		req := b.Provider.NewRequest()
+		d.setResource(req)
+		for j := len(created) - 1; j >= 0; j-- {
+			created[j].undo(req)
+		}
+
+		if err := req.Do(); err != nil {
+			klog.Errorf("Failed to revert changes in DNS provider %s: %s\n", b.Name, err)
+			errs = append(errs, ProviderError{
+				Provider: b.Name,
+				Err:      fmt.Errorf("rollback failed: %s", err),
+			})
+		}
+	}
+	return errs
+}
+
+// AddRecord adds A or AAAA record with single IP
+func (d *DNSRequest) AddRecord(domain, ip string) {
+	d.add(operation{
+		apply:   func(r pdns.DNSRequest) { r.AddRecord(domain, ip) },
+		undo:    func(r pdns.DNSRequest) { r.RemoveRecord(domain, ip) },
+		name:    domain,
+		recType: pdns.AddressType(ip),
+		value:   ip,
+	})
+}
+
+// RemoveRecord deletes A or AAAA record with a single IP
+func (d *DNSRequest) RemoveRecord(domain, ip string) {
+	d.add(operation{
+		apply: func(r pdns.DNSRequest) { r.RemoveRecord(domain, ip) },
+	})
+}
+
+// AddReverseRecord adds a PTR record for the reverse lookup.
+// Reverse zones are not listed so it is never reverted.
+func (d *DNSRequest) AddReverseRecord(domain, ip string) {
+	d.add(operation{
+		apply: func(r pdns.DNSRequest) { r.AddReverseRecord(domain, ip) },
+	})
+}
+
+// RemoveReverseRecord removes a PTR record from the reverse lookup zone
+func (d *DNSRequest) RemoveReverseRecord(domain, ip string) {
+	d.add(operation{
+		apply: func(r pdns.DNSRequest) { r.RemoveReverseRecord(domain, ip) },
+	})
+}
+
+// AddToService adds the given IP to A or AAAA record with multiple IPs
+func (d *DNSRequest) AddToService(domain, ip string) {
+	d.add(operation{
+		apply:   func(r pdns.DNSRequest) { r.AddToService(domain, ip) },
+		undo:    func(r pdns.DNSRequest) { r.RemoveFromService(domain, ip) },
+		name:    domain,
+		recType: pdns.AddressType(ip),
+		value:   ip,
+	})
+}
+
+// RemoveFromService removes given IP from an A or AAAA record with multiple IPs
+func (d *DNSRequest) RemoveFromService(domain, ip string) {
+	d.add(operation{
+		apply: func(r pdns.DNSRequest) { r.RemoveFromService(domain, ip) },
+	})
+}
+
+// AddToSRV adds target to SRV record
+func (d *DNSRequest) AddToSRV(srv string, data pdns.SRV) {
+	d.add(operation{
+		apply:   func(r pdns.DNSRequest) { r.AddToSRV(srv, data) },
+		undo:    func(r pdns.DNSRequest) { r.RemoveFromSRV(srv, data.Target) },
+		name:    srv,
+		recType: "SRV",
+		value:   pdns.SRVTarget(data.Target),
+	})
+}
+
+// RemoveFromSRV removes domain from SRV record
+func (d *DNSRequest) RemoveFromSRV(srv, domain string) {
+	d.add(operation{
+		apply: func(r pdns.DNSRequest) { r.RemoveFromSRV(srv, domain) },
+	})
+}
+
+// withCluster is a fan-out provider that resolves cluster details
+// from the first backend supporting them
+type withCluster struct {
+	*FanOut
+	pdns.ClusterInfo
+}
+
+// clusterAware adds cluster details to the provider when some backend has them
+func clusterAware(c *FanOut) pdns.DNSProvider {
+	for _, b := range c.backends {
+		if cluster, ok := b.Provider.(pdns.ClusterInfo); ok {
+			return withCluster{FanOut: c, ClusterInfo: cluster}
+		}
+	}
+	return c
+}
@@ -0,0 +1,365 @@
+package fanout
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/tanelmae/private-dns/internal/pdns"
+	"github.com/tanelmae/private-dns/internal/pdns/pdnstest"
+)
+
+// fakeProvider keeps A records in a map and can be made to fail
+type fakeProvider struct {
+	records map[string]string
+	fail    bool
+}
+
+func newFake() *fakeProvider {
+	return &fakeProvider{records: make(map[string]string)}
+}
+
+func (f *fakeProvider) NewRequest() pdns.DNSRequest {
+	return &fakeRequest{provider: f}
+}
+
+func (f *fakeProvider) CheckHealth() error {
+	if f.fail {
+		return errors.New("API unavailable")
+	}
+	return nil
+}
+
+func (f *fakeProvider) ListRecords(domain string) ([]pdns.Record, error) {
+	if f.fail {
+		return nil, errors.New("API unavailable")
+	}
+
+	records := []pdns.Record{}
+	for name, ip := range f.records {
+		if pdns.InDomain(name, domain) {
+			records = append(records, pdns.Record{Name: name, Type: "A", Values: []string{ip}})
+		}
+	}
+	return records, nil
+}
+
+func (f *fakeProvider) values() []string {
+	values := []string{}
+	for name, ip := range f.records {
+		values = append(values, fmt.Sprintf("%s/%s", name, ip))
+	}
+	sort.Strings(values)
+	return values
+}
+
+type fakeRequest struct {
+	provider *fakeProvider
+	changes  []func()
+}
+
+func (r *fakeRequest) AddRecord(domain, ip string) {
+	r.changes = append(r.changes, func() { r.provider.records[domain] = ip })
+}
+
+func (r *fakeRequest) RemoveRecord(domain, ip string) {
+	if r.provider.records[domain] != ip {
+		return
+	}
+	r.changes = append(r.changes, func() { delete(r.provider.records, domain) })
+}
+
+func (r *fakeRequest) AddReverseRecord(domain, ip string)    {}
+func (r *fakeRequest) RemoveReverseRecord(domain, ip string) {}
+func (r *fakeRequest) AddToService(domain, ip string)        {}
+func (r *fakeRequest) RemoveFromService(domain, ip string)   {}
+
+func (r *fakeRequest) AddToSRV(srv string, data pdns.SRV) {}
+func (r *fakeRequest) RemoveFromSRV(srv, domain string)   {}
+func (r *fakeRequest) SetTTL(ttl pdns.TTL)                {}
+
+func (r *fakeRequest) Do() error {
+	if r.provider.fail {
+		return errors.New("API unavailable")
+	}
+	for _, change := range r.changes {
+		change()
+	}
+	return nil
+}
+
+func TestBestEffort(t *testing.T) {
+	first, failing, last := newFake(), newFake(), newFake()
+	failing.fail = true
+
+	client := New(BestEffort,
+		Backend{Name: "first", Provider: first},
+		Backend{Name: "failing", Provider: failing},
+		Backend{Name: "last", Provider: last},
+	)
+
+	req := client.NewRequest()
+	req.AddRecord("pod-0.example.com", "10.0.0.1")
+	err := req.Do()
+
+	errs, ok := err.(Errors)
+	if !ok || len(errs) != 1 || errs[0].Provider != "failing" {
+		t.Fatalf("expected failure of a single provider, got %v", err)
+	}
+	pdnstest.AssertValues(t, first.values(), "pod-0.example.com/10.0.0.1")
+	pdnstest.AssertValues(t, last.values(), "pod-0.example.com/10.0.0.1")
+}
+
+func TestAllOrNothing(t *testing.T) {
+	first, second, failing, last := newFake(), newFake(), newFake(), newFake()
+	failing.fail = true
+	first.records["pod-1.example.com"] = "10.0.0.2"
+
+	client := New(AllOrNothing,
+		Backend{Name: "first", Provider: first},
+		Backend{Name: "second", Provider: second},
+		Backend{Name: "failing", Provider: failing},
+		Backend{Name: "last", Provider: last},
+	)
+
+	req := client.NewRequest()
+	req.AddRecord("pod-0.example.com", "10.0.0.1")
+	req.RemoveRecord("pod-1.example.com", "10.0.0.2")
+	err := req.Do()
+
+	if err == nil || !strings.Contains(err.Error(), "failing: API unavailable") {
+		t.Fatalf("expected failing provider error, got %v", err)
+	}
+
+	// Additions are reverted but removals are not
+	pdnstest.AssertValues(t, first.values())
+	pdnstest.AssertValues(t, second.values())
+
+	// Providers after the failed one are not changed
+	pdnstest.AssertValues(t, last.values())
+}
+
+func TestAllOrNothingKeepsExistingRecords(t *testing.T) {
+	first, failing := newFake(), newFake()
+	failing.fail = true
+	first.records["pod-0.example.com"] = "10.0.0.1"
+
+	client := New(AllOrNothing,
+		Backend{Name: "first", Provider: first},
+		Backend{Name: "failing", Provider: failing},
+	)
+
+	req := client.NewRequest()
+	req.AddRecord("pod-0.example.com", "10.0.0.1")
+	req.AddRecord("pod-1.example.com", "10.0.0.2")
+	if err := req.Do(); err == nil {
+		t.Fatal("expected failing provider error")
+	}
+
+	// Only the record created by the request is reverted
+	pdnstest.AssertValues(t, first.values(), "pod-0.example.com/10.0.0.1")
+}
+
+func TestListRecords(t *testing.T) {
+	first, second := newFake(), newFake()
+	first.records["pod-0.example.com"] = "10.0.0.1"
+	first.records["pod-1.example.com"] = "10.0.0.2"
+	second.records["pod-0.example.com"] = "10.0.0.1"
+	second.records["pod-1.example.com"] = "10.0.0.3"
+
+	client := New(BestEffort,
+		Backend{Name: "first", Provider: first},
+		Backend{Name: "second", Provider: second},
+	)
+
+	records, err := client.ListRecords("example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Records that differ between the backends are left out
+	values := []string{}
+	for _, r := range records {
+		values = append(values, fmt.Sprintf("%s %s %v", r.Name, r.Type, r.Values))
+	}
+	pdnstest.AssertValues(t, values, "pod-0.example.com A [10.0.0.1]")
+
+	second.fail = true
+	if _, err := client.ListRecords("example.com"); err == nil || !strings.Contains(err.Error(), "second") {
+		t.Fatalf("expected second provider error, got %v", err)
+	}
+}
+
+func TestRollbackFailure(t *testing.T) {
+	first, failing := newFake(), newFake()
+	failing.fail = true
+
+	client := New(AllOrNothing,
+		Backend{Name: "first", Provider: first},
+		Backend{Name: "failing", Provider: failing},
+	)
+
+	req := client.NewRequest()
+	req.AddRecord("pod-0.example.com", "10.0.0.1")
+
+	// First provider fails once the changes are applied
+	firstReq := req.(*DNSRequest).requests[0].(*fakeRequest)
+	firstReq.changes = append(firstReq.changes, func() { first.fail = true })
+
+	errs, ok := req.Do().(Errors)
+	if !ok || len(errs) != 2 || errs[1].Provider != "first" ||
+		!strings.Contains(errs[1].Error(), "rollback failed") {
+		t.Fatalf("expected rollback failure, got %v", errs)
+	}
+}
+
+func TestCheckHealth(t *testing.T) {
+	healthy, failing := newFake(), newFake()
+	failing.fail = true
+	backends := []Backend{{Name: "healthy", Provider: healthy}, {Name: "failing", Provider: failing}}
+
+	if err := New(BestEffort, backends...).CheckHealth(); err != nil {
+		t.Fatalf("expected best-effort to be healthy with a healthy backend, got %v", err)
+	}
+
+	err := New(AllOrNothing, backends...).CheckHealth()
+	if errs, ok := err.(Errors); !ok || len(errs) != 1 || errs[0].Provider != "failing" {
+		t.Fatalf("expected all-or-nothing to fail with the failing backend, got %v", err)
+	}
+
+	healthy.fail = true
+	if err := New(BestEffort, backends...).CheckHealth(); err == nil {
+		t.Fatal("expected best-effort to fail without healthy backends")
+	}
+}
+
+type fakeConfig struct {
+	Zone string `json:"zone"`
+}
+
+func (c *fakeConfig) RegisterFlags(fs *flag.FlagSet) {}
+
+func (c *fakeConfig) New() (pdns.DNSProvider, error) {
+	if c.Zone == "" {
+		return nil, errors.New("zone is required")
+	}
+	return newFake(), nil
+}
+
+func TestConfigFile(t *testing.T) {
+	pdns.Register("fake", func() pdns.ProviderConfig { return &fakeConfig{} })
+
+	load := func(data string) (pdns.DNSProvider, error) {
+		path := filepath.Join(t.TempDir(), "fanout.yaml")
+		if err := ioutil.WriteFile(path, []byte(data), 0600); err != nil {
+			t.Fatal(err)
+		}
+		return (&Options{ConfigFile: path}).New()
+	}
+
+	provider, err := load(`
+policy: all-or-nothing
+providers:
+- name: cloud
+  provider: fake
+  config:
+    zone: example.com
+- provider: fake
+  config:
+    zone: example.com
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	client := provider.(*FanOut)
+	if client.policy != AllOrNothing || len(client.backends) != 2 || client.backends[1].Name != "fake" {
+		t.Fatalf("unexpected provider %+v", client)
+	}
+
+	for _, tc := range []struct{ config, msg string }{
+		{"providers: []", "No DNS providers configured"},
+		{"policy: some\nproviders: [{provider: fake}]", "Unknown policy"},
+		{"providers: [{provider: other}]", "Unknown DNS provider"},
+		{"providers: [{provider: fake}]", "zone is required"},
+		{"providers: [{provider: fake, config: {zon: x}}]", "unknown field"},
+		{"providers: [{provider: fake, config: {zone: x}}, {provider: fake, config: {zone: y}}]", "used more than once"},
+	} {
+		if _, err := load(tc.config); err == nil || !strings.Contains(err.Error(), tc.msg) {
+			t.Fatalf("expected %q error for %q, got %v", tc.msg, tc.config, err)
+		}
+	}
+}
+
+// fakeRegistry is a provider that keeps the record ownership
+type fakeRegistry struct {
+	*fakeProvider
+	id    string
+	owned []pdns.OwnedRecord
+}
+
+func (f fakeRegistry) OwnerID() string { return f.id }
+
+func (f fakeRegistry) OwnedRecords() ([]pdns.OwnedRecord, error) { return f.owned, nil }
+
+func TestOwnedRecords(t *testing.T) {
+	owner := pdns.Owner{ID: "sauna", Namespace: "default", Name: "app", Kind: pdns.KindPod}
+	first := fakeRegistry{fakeProvider: newFake(), id: "sauna", owned: []pdns.OwnedRecord{
+		{Record: pdns.Record{Name: "pod-0.example.com", Type: "A", Values: []string{"10.0.0.1"}}, Owner: owner},
+	}}
+	second := fakeRegistry{fakeProvider: newFake(), id: "sauna", owned: []pdns.OwnedRecord{
+		{Record: pdns.Record{Name: "pod-0.example.com", Type: "A", Values: []string{"10.0.0.1"}}, Owner: owner},
+		{Record: pdns.Record{Name: "pod-1.example.com", Type: "A", Values: []string{"10.0.0.2"}}, Owner: owner},
+	}}
+
+	client := New(BestEffort,
+		Backend{Name: "plain", Provider: newFake()},
+		Backend{Name: "first", Provider: first},
+		Backend{Name: "second", Provider: second},
+	)
+	if id := client.OwnerID(); id != "sauna" {
+		t.Fatalf("expected owner ID of the backends, got %q", id)
+	}
+
+	records, err := client.OwnedRecords()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	values := []string{}
+	for _, r := range records {
+		values = append(values, fmt.Sprintf("%s %s %v", r.Name, r.Type, r.Values))
+	}
+	pdnstest.AssertValues(t, values, "pod-0.example.com A [10.0.0.1]", "pod-1.example.com A [10.0.0.2]")
+
+	if id := New(BestEffort, Backend{Name: "plain", Provider: newFake()}).OwnerID(); id != "" {
+		t.Fatalf("expected no owner ID without registries, got %q", id)
+	}
+}
+
+// localProvider serves the records only from this replica
+type localProvider struct {
+	*fakeProvider
+}
+
+func (localProvider) ReplicaLocal() bool { return true }
+
+func TestReplicaLocal(t *testing.T) {
+	client := New(BestEffort, Backend{Name: "first", Provider: newFake()})
+	if client.ReplicaLocal() {
+		t.Fatal("expected shared backends not to be local to the replica")
+	}
+
+	client = New(BestEffort,
+		Backend{Name: "first", Provider: newFake()},
+		Backend{Name: "local", Provider: localProvider{newFake()}},
+	)
+	if !client.ReplicaLocal() {
+		t.Fatal("expected fan-out with a replica-local backend to be local to the replica")
+	}
+}
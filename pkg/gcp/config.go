@@ -0,0 +1,75 @@
+package gcp
+
+import (
+	"errors"
+	"flag"
+	"strings"
+
+	"github.com/tanelmae/private-dns/internal/pdns"
+)
+
+func init() {
+	pdns.Register(providerName, func() pdns.ProviderConfig { return &Options{} })
+}
+
+// Options holds CloudDNS provider configuration
+type Options struct {
+	Project     string `json:"project"`
+	Zone        string `json:"zone"`
+	ReverseZone string `json:"reverse-zone"`
+	Credentials string `json:"cred"`
+	OwnerID     string `json:"owner-id"`
+	Cluster     string `json:"cluster"`
+
+	DiscoverReverseZones bool `json:"discover-reverse-zones"`
+}
+
+// RegisterFlags adds CloudDNS flags to the flag set
+func (o *Options) RegisterFlags(fs *flag.FlagSet) {
+	fs.StringVar(&o.Project, "gcp-project", "", "GCP project where the DNS zone is. Defaults to the same as GKE cluster.")
+	fs.StringVar(&o.Zone, "gcp-zone", "", "GCP DNS zone where to write the records")
+	fs.StringVar(&o.ReverseZone, "gcp-reverse-zone", "", "Comma separated GCP DNS zones where to write the reverse lookup records. PTR record goes to the zone with the longest matching DNS name.")
+	fs.BoolVar(&o.DiscoverReverseZones, "gcp-discover-reverse-zones", false, "Write the reverse lookup records to the in-addr.arpa and ip6.arpa zones found in the GCP project")
+	fs.StringVar(&o.Credentials, "gcp-cred", "", "Path to GCP service account credentials")
+	fs.StringVar(&o.OwnerID, "gcp-owner-id", "", "Owner ID written to TXT ownership records. Records of other owners are never replaced or deleted. Empty disables the ownership registry.")
+	fs.StringVar(&o.Cluster, "gcp-cluster", "", "Cluster name written to TXT ownership records. Defaults to the GKE cluster name.")
+}
+
+// New creates CloudDNS client from the options
+func (o *Options) New() (pdns.DNSProvider, error) {
+	if o.Zone == "" {
+		return nil, errors.New("-gcp-zone is required")
+	}
+
+	if o.Project == "" {
+		o.Project, _ = GetProject()
+	}
+
+	if o.Project == "" {
+		return nil, errors.New("Failed to resolve GCP project")
+	}
+
+	if o.OwnerID != "" && o.Cluster == "" {
+		o.Cluster, _ = GetClusterName()
+	}
+
+	// JSON key file for service account with DNS admin permissions
+	client, err := FromJSON(o.Credentials, o.Zone, reverseZones(o.ReverseZone), o.Project)
+	if err != nil {
+		return nil, err
+	}
+	client.owner = pdns.Owner{ID: o.OwnerID, Cluster: o.Cluster}
+	client.discoverReverse = o.DiscoverReverseZones
+	return client, nil
+}
+
+// reverseZones splits the comma separated reverse zone names
+func reverseZones(value string) []string {
+	zones := []string{}
+	for _, zone := range strings.Split(value, ",") {
+		if zone = strings.TrimSpace(zone); zone != "" {
+			zones = append(zones, zone)
+		}
+	}
+	return zones
+}
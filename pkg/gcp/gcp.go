@@ -3,8 +3,12 @@ package gcp
 import (
 	"context"
 	"fmt"
+	"sort"
+	"strings"
+	"sync"
 	"time"
 
+	"github.com/tanelmae/private-dns/internal/metrics"
 	"github.com/tanelmae/private-dns/internal/pdns"
 	"google.golang.org/api/dns/v1"
 	"google.golang.org/api/option"
@@ -12,49 +16,80 @@ import (
 )
 
 const (
-	typeA   = "A"
+	providerName = "gcp"
+
 	typeSRV = "SRV"
 	typePTR = "PTR"
-
-	defaultTTL int64 = 60
+	typeTXT = "TXT"
 
 	statusPending = "pending"
+
+	// Change that is still pending after this fails the request
+	changeTimeout = 5 * time.Minute
 )
 
-// CloudDNS is a wrapper for GCP SDK api to hold relevant conf
+// CloudDNS is a wrapper for GCP SDK api to hold relevant conf.
+// Records are only replaced and deleted when their TXT ownership record
+// names the same owner ID. Empty owner ID disables the ownership registry.
 type CloudDNS struct {
-	api         *dns.Service
-	zone        string
-	reverseZone string
-	project     string
+	api          *dns.Service
+	zone         string
+	reverseZones []string
+	project      string
+	owner        pdns.Owner
+
+	// Reverse zones are discovered from the project by their DNS names
+	discoverReverse bool
+
+	// DNS names of the reverse zones and when they were last looked up
+	reverseMu      sync.Mutex
+	reverseNames   map[string]string
+	reverseFetched time.Time
 }
 
 // FromJSON creaties DNS client instance with JSON key file
-func FromJSON(filePath, zone, reverseZone, project string) *CloudDNS {
+func FromJSON(filePath, zone string, reverseZones []string, project string) (*CloudDNS, error) {
 	dnsSvc, err := dns.NewService(context.Background(), option.WithCredentialsFile(filePath))
 	if err != nil {
-		klog.Fatalln(err)
+		return nil, err
 	}
 
+	return New(dnsSvc, zone, reverseZones, project), nil
+}
+
+// New creates DNS client instance from an existing API client.
+// PTR records are written to the reverse zone with the longest matching DNS name.
+func New(api *dns.Service, zone string, reverseZones []string, project string) *CloudDNS {
 	return &CloudDNS{
-		api:         dnsSvc,
-		zone:        zone,
-		reverseZone: reverseZone,
-		project:     project,
+		api:          api,
+		zone:         zone,
+		reverseZones: reverseZones,
+		project:      project,
 	}
 }
 
-func (c *CloudDNS) applyChange(changes *dns.Change) error {
-	chg, err := c.api.Changes.Create(c.project, c.zone, changes).Do()
+func (c *CloudDNS) applyChange(zone string, changes *dns.Change) error {
+	ctx, cancel := context.WithTimeout(context.Background(), changeTimeout)
+	defer cancel()
+
+	start := time.Now()
+	chg, err := c.api.Changes.Create(c.project, zone, changes).Context(ctx).Do()
+	metrics.ObserveCall(providerName, "change", zone, start, err)
 	if err != nil {
 		return err
 	}
 
 	// wait for change to be acknowledged
 	for chg.Status == statusPending {
-		time.Sleep(time.Second)
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("Change %s in %s is still pending: %w", chg.Id, zone, ctx.Err())
+		case <-time.After(time.Second):
+		}
 
-		chg, err = c.api.Changes.Get(c.project, c.zone, chg.Id).Do()
+		start = time.Now()
+		chg, err = c.api.Changes.Get(c.project, zone, chg.Id).Context(ctx).Do()
+		metrics.ObserveCall(providerName, "get-change", zone, start, err)
 		if err != nil {
 			return err
 		}
@@ -62,100 +97,352 @@ func (c *CloudDNS) applyChange(changes *dns.Change) error {
 	return nil
 }
 
-func (c *CloudDNS) applyRevChange(changes *dns.Change) error {
-	chg, err := c.api.Changes.Create(c.project, c.reverseZone, changes).Do()
+func (c *CloudDNS) checkForRec(zone string, rec *dns.ResourceRecordSet) *dns.ResourceRecordSet {
+	start := time.Now()
+	list, err := c.api.ResourceRecordSets.List(c.project, zone).Name(rec.Name).Type(rec.Type).MaxResults(1).Do()
+	metrics.ObserveCall(providerName, "get", zone, start, err)
 	if err != nil {
-		return err
+		klog.Errorln(err)
+		return nil
 	}
 
-	// wait for change to be acknowledged
-	for chg.Status == statusPending {
-		time.Sleep(time.Second)
+	if len(list.Rrsets) < 1 {
+		return nil
+	}
+
+	return list.Rrsets[0]
+}
 
-		chg, err = c.api.Changes.Get(c.project, c.reverseZone, chg.Id).Do()
+// CheckHealth gets the forward zone to verify the API access
+func (c *CloudDNS) CheckHealth() error {
+	start := time.Now()
+	_, err := c.api.ManagedZones.Get(c.project, c.zone).Do()
+	metrics.ObserveCall(providerName, "get-zone", c.zone, start, err)
+	return err
+}
+
+// ListRecords returns the record sets in the zone under the given domain
+func (c *CloudDNS) ListRecords(domain string) ([]pdns.Record, error) {
+	records := []pdns.Record{}
+	start := time.Now()
+	err := c.api.ResourceRecordSets.List(c.project, c.zone).Pages(context.Background(),
+		func(list *dns.ResourceRecordSetsListResponse) error {
+			for _, rec := range list.Rrsets {
+				name := strings.TrimSuffix(rec.Name, ".")
+				if pdns.InDomain(name, domain) {
+					records = append(records, pdns.Record{Name: name, Type: rec.Type, Values: rec.Rrdatas})
+				}
+			}
+			return nil
+		})
+	metrics.ObserveCall(providerName, "list", c.zone, start, err)
+	return records, err
+}
+
+// OwnerID returns the owner ID written to the TXT ownership records
+func (c *CloudDNS) OwnerID() string {
+	return c.owner.ID
+}
+
+// OwnedRecords returns the record sets in the forward and reverse zones
+// with TXT ownership record of the same owner ID and cluster
+func (c *CloudDNS) OwnedRecords() ([]pdns.OwnedRecord, error) {
+	if c.owner.ID == "" {
+		return nil, fmt.Errorf("Ownership registry is not enabled")
+	}
+
+	records, err := c.ownedRecords(c.zone)
+	if err != nil || !c.reverseEnabled() {
+		return records, err
+	}
+
+	zones, err := c.reverseDNSNames()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, zone := range sortedZones(zones) {
+		reverse, err := c.ownedRecords(zone)
 		if err != nil {
-			return err
+			return nil, err
 		}
+		records = append(records, reverse...)
 	}
-	return nil
+	return records, nil
 }
 
-func (c *CloudDNS) checkForRec(rec *dns.ResourceRecordSet) *dns.ResourceRecordSet {
-	list, err := c.api.ResourceRecordSets.List(c.project, c.zone).Name(rec.Name).Type(rec.Type).MaxResults(1).Do()
+func (c *CloudDNS) ownedRecords(zone string) ([]pdns.OwnedRecord, error) {
+	owners := make(map[string]pdns.Owner)
+	sets := []*dns.ResourceRecordSet{}
+	start := time.Now()
+	err := c.api.ResourceRecordSets.List(c.project, zone).Pages(context.Background(),
+		func(list *dns.ResourceRecordSetsListResponse) error {
+			for _, rec := range list.Rrsets {
+				name, isOwnerRecord := pdns.OwnedName(strings.ToLower(rec.Name))
+				if !isOwnerRecord || rec.Type != typeTXT {
+					sets = append(sets, rec)
+					continue
+				}
+
+				for _, data := range rec.Rrdatas {
+					owner, ok := pdns.ParseOwner(data)
+					if ok && owner.ID == c.owner.ID && owner.Cluster == c.owner.Cluster {
+						owners[name] = owner
+					}
+				}
+			}
+			return nil
+		})
+	metrics.ObserveCall(providerName, "list", zone, start, err)
 	if err != nil {
-		klog.Errorln(err)
-		return nil
+		return nil, err
 	}
 
-	if len(list.Rrsets) < 1 {
-		return nil
+	records := []pdns.OwnedRecord{}
+	for _, rec := range sets {
+		if owner, ok := owners[strings.ToLower(rec.Name)]; ok {
+			records = append(records, pdns.OwnedRecord{
+				Record: pdns.Record{Name: strings.TrimSuffix(rec.Name, "."), Type: rec.Type, Values: rec.Rrdatas},
+				Owner:  owner,
+			})
+		}
 	}
-
-	return list.Rrsets[0]
+	return records, nil
 }
 
 func (c *CloudDNS) NewRequest() pdns.DNSRequest {
 	return &DNSRequest{
-		client:    c,
-		change:    &dns.Change{},
-		revChange: &dns.Change{},
+		client:     c,
+		owner:      c.owner,
+		change:     &dns.Change{},
+		revChanges: make(map[string]*dns.Change),
 	}
 }
 
 type DNSRequest struct {
-	client    *CloudDNS
-	change    *dns.Change
-	revChange *dns.Change
+	client     *CloudDNS
+	owner      pdns.Owner
+	ttl        pdns.TTL
+	change     *dns.Change
+	revChanges map[string]*dns.Change
+	conflicts  []string
+	// First error preparing the changes fails the request so it is retried
+	err error
+}
+
+// SetResource sets the PrivateDNS resource written to the TXT ownership records
+func (d *DNSRequest) SetResource(namespace, name string) {
+	d.owner.Namespace = namespace
+	d.owner.Name = name
+}
+
+// SetTTL sets the TTL of the records added in the request
+func (d *DNSRequest) SetTTL(ttl pdns.TTL) {
+	d.ttl = ttl
 }
 
 // Do makes the request with all the attached changes
 // No error would be returned when no changes have been added
-func (d *DNSRequest) Do() error {
-	var err error
+func (d *DNSRequest) Do() (err error) {
+	defer metrics.ObserveRequest(providerName, time.Now(), &err)
+
+	if d.err != nil {
+		return d.err
+	}
 
-	if len(d.change.Deletions) > 1 || len(d.change.Additions) > 1 {
-		err = d.client.applyChange(d.change)
+	if len(d.change.Deletions) > 0 || len(d.change.Additions) > 0 {
+		err = d.client.applyChange(d.client.zone, d.change)
 		if err != nil {
 			return err
 		}
 	}
 
-	if len(d.revChange.Deletions) > 1 || len(d.revChange.Additions) > 1 {
-		err = d.client.applyRevChange(d.revChange)
-		if err != nil {
-			return err
+	for _, zone := range sortedChanges(d.revChanges) {
+		change := d.revChanges[zone]
+		if len(change.Deletions) > 0 || len(change.Additions) > 0 {
+			err = d.client.applyChange(zone, change)
+			if err != nil {
+				return err
+			}
 		}
 	}
+
+	if len(d.conflicts) > 0 {
+		return fmt.Errorf("Records not owned by %s were not changed: %s", d.owner.ID, strings.Join(d.conflicts, ", "))
+	}
 	return err
 }
 
-func (d *DNSRequest) deletion(rec *dns.ResourceRecordSet) {
-	d.change.Deletions = append(d.change.Deletions, rec)
+// Changes returns the record set deletions and additions of every zone
+// and the records left unchanged as they are not owned
+func (d *DNSRequest) Changes() []string {
+	changes := describeChange(d.client.zone, d.change)
+	for _, zone := range sortedChanges(d.revChanges) {
+		changes = append(changes, describeChange(zone, d.revChanges[zone])...)
+	}
+	for _, name := range d.conflicts {
+		changes = append(changes, fmt.Sprintf("skip %s not owned by %s", name, d.owner.ID))
+	}
+	return changes
+}
+
+func describeChange(zone string, change *dns.Change) []string {
+	changes := []string{}
+	for _, rec := range change.Deletions {
+		changes = append(changes, fmt.Sprintf("delete %s %s %v in %s", rec.Name, rec.Type, rec.Rrdatas, zone))
+	}
+	for _, rec := range change.Additions {
+		changes = append(changes, fmt.Sprintf("add %s %s %d %v in %s", rec.Name, rec.Type, rec.Ttl, rec.Rrdatas, zone))
+	}
+	return changes
+}
+
+// sortedChanges returns the zones of the changes in a stable order
+func sortedChanges(changes map[string]*dns.Change) []string {
+	zones := make([]string, 0, len(changes))
+	for zone := range changes {
+		zones = append(zones, zone)
+	}
+	sort.Strings(zones)
+	return zones
+}
+
+// zoneChange returns the change of the forward zone or one of the reverse zones
+func (d *DNSRequest) zoneChange(zone string) *dns.Change {
+	if zone == d.client.zone {
+		return d.change
+	}
+
+	change, ok := d.revChanges[zone]
+	if !ok {
+		change = &dns.Change{}
+		d.revChanges[zone] = change
+	}
+	return change
+}
+
+// owned checks that the TXT ownership record of the name has the same owner ID
+func (d *DNSRequest) owned(zone string, name string) bool {
+	if d.owner.ID == "" {
+		return true
+	}
+
+	txt := d.checkForRec(zone, &dns.ResourceRecordSet{Name: pdns.OwnerRecordName(name), Type: typeTXT})
+	if txt == nil {
+		return false
+	}
+
+	for _, data := range txt.Rrdatas {
+		if owner, ok := pdns.ParseOwner(data); ok && owner.ID == d.owner.ID {
+			return true
+		}
+	}
+	return false
 }
 
-func (d *DNSRequest) addition(rec *dns.ResourceRecordSet) {
-	d.change.Additions = append(d.change.Additions, rec)
+// conflict records a change that was refused as the record set is not owned
+func (d *DNSRequest) conflict(name string) {
+	klog.Errorf("Record %s is not owned by %s. Leaving it as it is.\n", name, d.owner.ID)
+	d.conflicts = append(d.conflicts, name)
 }
 
-func (d *DNSRequest) revDeletion(rec *dns.ResourceRecordSet) {
-	d.revChange.Deletions = append(d.revChange.Deletions, rec)
+// claim writes the TXT ownership record for the name
+func (d *DNSRequest) claim(zone string, name string, kind pdns.RecordKind) {
+	if d.owner.ID == "" {
+		return
+	}
+
+	owner := d.owner
+	owner.Kind = kind
+	rec := &dns.ResourceRecordSet{
+		Name:    pdns.OwnerRecordName(name),
+		Rrdatas: []string{fmt.Sprintf("%q", owner.String())},
+		Ttl:     d.ttl.For(kind),
+		Type:    typeTXT,
+	}
+
+	oldRec := d.checkForRec(zone, rec)
+	if oldRec != nil && dataContains(oldRec, rec.Rrdatas[0]) {
+		return
+	}
+
+	if oldRec != nil {
+		d.deletion(zone, oldRec)
+	}
+	d.addition(zone, rec)
 }
 
-func (d *DNSRequest) revAddition(rec *dns.ResourceRecordSet) {
-	d.revChange.Additions = append(d.revChange.Additions, rec)
+// release removes the TXT ownership record of the name
+func (d *DNSRequest) release(zone string, name string) {
+	if d.owner.ID == "" {
+		return
+	}
+
+	oldRec := d.checkForRec(zone, &dns.ResourceRecordSet{Name: pdns.OwnerRecordName(name), Type: typeTXT})
+	if oldRec != nil {
+		d.deletion(zone, oldRec)
+	}
 }
 
-// AddRecord adds A record with single IP
+// releaseAddress removes the TXT ownership record of the address record name
+// unless a record of the other address family is left under the same name
+func (d *DNSRequest) releaseAddress(name, recType string) {
+	other := pdns.TypeAAAA
+	if recType == pdns.TypeAAAA {
+		other = pdns.TypeA
+	}
+	if d.checkForRec(d.client.zone, &dns.ResourceRecordSet{Name: name, Type: other}) != nil {
+		return
+	}
+	d.release(d.client.zone, name)
+}
+
+// checkForRec returns the record set as it will be after the changes already in the request
+func (d *DNSRequest) checkForRec(zone string, rec *dns.ResourceRecordSet) *dns.ResourceRecordSet {
+	change := d.zoneChange(zone)
+	for _, r := range change.Additions {
+		if sameRecordSet(r, rec) {
+			return r
+		}
+	}
+
+	for _, r := range change.Deletions {
+		if sameRecordSet(r, rec) {
+			return nil
+		}
+	}
+	return d.client.checkForRec(zone, rec)
+}
+
+// deletion drops the record set if it was added in the same request.
+// Otherwise the existing record set gets deleted.
+func (d *DNSRequest) deletion(zone string, rec *dns.ResourceRecordSet) {
+	change := d.zoneChange(zone)
+	for i, r := range change.Additions {
+		if sameRecordSet(r, rec) {
+			change.Additions = append(change.Additions[:i], change.Additions[i+1:]...)
+			return
+		}
+	}
+	change.Deletions = append(change.Deletions, rec)
+}
+
+func (d *DNSRequest) addition(zone string, rec *dns.ResourceRecordSet) {
+	change := d.zoneChange(zone)
+	change.Additions = append(change.Additions, rec)
+}
+
+// AddRecord adds A or AAAA record with single IP
 func (d *DNSRequest) AddRecord(domain, ip string) {
 
 	rec := &dns.ResourceRecordSet{
 		Name:    fmt.Sprintf("%s.", domain),
 		Rrdatas: []string{ip},
-		Ttl:     defaultTTL,
-		Type:    typeA,
+		Ttl:     d.ttl.For(pdns.KindPod),
+		Type:    pdns.AddressType(ip),
 	}
 
-	oldRec := d.client.checkForRec(rec)
+	oldRec := d.checkForRec(d.client.zone, rec)
 
 	if oldRec != nil && rec.Rrdatas[0] == oldRec.Rrdatas[0] {
 		klog.V(2).Infof("Record exists: %+v\n", rec)
@@ -166,121 +453,158 @@ func (d *DNSRequest) AddRecord(domain, ip string) {
 	// as it would fail the API request
 	if oldRec != nil && rec.Rrdatas[0] != oldRec.Rrdatas[0] {
 		klog.V(2).Infof("Stale record found: %+v\n", oldRec)
-		d.deletion(rec)
+		if !d.owned(d.client.zone, rec.Name) {
+			d.conflict(rec.Name)
+			return
+		}
+		d.deletion(d.client.zone, oldRec)
 	}
-	d.addition(rec)
-	if d.client.reverseZone != "" {
+	d.addition(d.client.zone, rec)
+	d.claim(d.client.zone, rec.Name, pdns.KindPod)
+
+	if d.client.reverseEnabled() {
 		d.AddReverseRecord(domain, ip)
 	}
 }
 
-// RemoveRecord deletes A record with a single IP
+// RemoveRecord deletes A or AAAA record with a single IP
 func (d *DNSRequest) RemoveRecord(domain, ip string) {
 
 	rec := &dns.ResourceRecordSet{
 		Name:    fmt.Sprintf("%s.", domain),
 		Rrdatas: []string{ip},
-		Ttl:     defaultTTL,
-		Type:    typeA,
+		Ttl:     d.ttl.For(pdns.KindPod),
+		Type:    pdns.AddressType(ip),
 	}
 
 	// We get the existing record from the DNS zone to check if it exists
-	list, err := d.client.api.ResourceRecordSets.List(
-		d.client.project, d.client.zone).Name(rec.Name).Type(rec.Type).MaxResults(1).Do()
-
-	if err != nil {
-		klog.V(2).Infoln(err)
-		return
-	}
-
-	if len(list.Rrsets) == 0 {
+	oldRec := d.checkForRec(d.client.zone, rec)
+	if oldRec == nil {
 		klog.V(2).Infof("No DNS record found for %s/%s", rec.Name, ip)
 		return
 	}
 
 	// If records and pods have somehow got into inconsistent state
 	// we avoid deleting records that don't match the event.
-	if ip != list.Rrsets[0].Rrdatas[0] {
+	if ip != oldRec.Rrdatas[0] {
 		klog.V(2).Infof("No DNS record found for %s with the same IP (%s)", rec.Name, ip)
 		return
 	}
-	d.deletion(rec)
 
-	if d.client.reverseZone != "" {
+	// Records of others are not ours to remove
+	if !d.owned(d.client.zone, rec.Name) {
+		klog.Warningf("Record %s is not owned by %s. Not removing it.\n", rec.Name, d.owner.ID)
+		return
+	}
+	d.deletion(d.client.zone, oldRec)
+	d.releaseAddress(rec.Name, rec.Type)
+
+	if d.client.reverseEnabled() {
 		d.RemoveReverseRecord(domain, ip)
 	}
 }
 
-// AddReverseRecord adds a PTR record for the reverse lookup
-func (d *DNSRequest) AddReverseRecord(domain, ip string) {
+// reverseRecordSet returns the PTR record set of the IP and the reverse zone it belongs to.
+// Nil is returned when the reverse name is in none of the reverse zones.
+// Failing to look the reverse zones up fails the whole request.
+func (d *DNSRequest) reverseRecordSet(domain, ip string) (string, *dns.ResourceRecordSet) {
+	name, ok := pdns.ReverseName(ip)
+	if !ok {
+		klog.Errorf("Invalid IP address for %s: %s\n", domain, ip)
+		return "", nil
+	}
 
-	rec := &dns.ResourceRecordSet{
-		Name:    fmt.Sprintf("%s.in-addr.arpa.", ip),
-		Rrdatas: []string{domain},
-		Ttl:     defaultTTL,
+	zone, err := d.client.reverseZoneOf(name)
+	if err != nil {
+		klog.Errorf("Failed to get reverse zones: %s\n", err)
+		if d.err == nil {
+			d.err = fmt.Errorf("Failed to get reverse zone of %s: %w", name, err)
+		}
+		return "", nil
+	}
+	if zone == "" {
+		klog.V(2).Infof("%s is not in any reverse zone. Not writing PTR record.\n", name)
+		return "", nil
+	}
+
+	return zone, &dns.ResourceRecordSet{
+		Name:    fmt.Sprintf("%s.", name),
+		Rrdatas: []string{fmt.Sprintf("%s.", strings.TrimSuffix(domain, "."))},
+		Ttl:     d.ttl.For(pdns.KindPTR),
 		Type:    typePTR,
 	}
+}
 
-	oldRec := d.client.checkForRec(rec)
+// AddReverseRecord adds a PTR record for the reverse lookup
+func (d *DNSRequest) AddReverseRecord(domain, ip string) {
+	zone, rec := d.reverseRecordSet(domain, ip)
+	if rec == nil {
+		return
+	}
 
-	if oldRec != nil && rec.Rrdatas[0] == oldRec.Rrdatas[0] {
+	oldRec := d.checkForRec(zone, rec)
+
+	// Exact data is required as records written without the trailing dot get replaced
+	if oldRec != nil && len(oldRec.Rrdatas) == 1 && rec.Rrdatas[0] == oldRec.Rrdatas[0] {
 		klog.V(2).Infof("Record exists: %+v\n", rec)
 		return
 	}
 
 	// Just a safeguard for case there is some stale record
 	// as it would fail the API request
-	if oldRec != nil && rec.Rrdatas[0] != oldRec.Rrdatas[0] {
+	if oldRec != nil {
 		klog.V(2).Infof("Stale record found: %+v\n", oldRec)
-		d.deletion(rec)
+		if !d.owned(zone, rec.Name) {
+			d.conflict(rec.Name)
+			return
+		}
+		d.deletion(zone, oldRec)
 	}
-	d.revAddition(rec)
+	d.addition(zone, rec)
+	d.claim(zone, rec.Name, pdns.KindPTR)
 }
 
 // RemoveReverseRecord removes a PTR record from the reverse lookup zone
 func (d *DNSRequest) RemoveReverseRecord(domain, ip string) {
-
-	rec := &dns.ResourceRecordSet{
-		Name:    fmt.Sprintf("%s.in-addr.arpa.", ip),
-		Rrdatas: []string{domain},
-		Ttl:     defaultTTL,
-		Type:    typePTR,
-	}
-
-	// We get the existing record from the DNS zone to check if it exists
-	list, err := d.client.api.ResourceRecordSets.List(
-		d.client.project, d.client.reverseZone).Name(rec.Name).Type(rec.Type).MaxResults(1).Do()
-
-	if err != nil {
-		klog.V(2).Infoln(err)
+	zone, rec := d.reverseRecordSet(domain, ip)
+	if rec == nil {
 		return
 	}
 
-	if len(list.Rrsets) == 0 {
+	// We get the existing record from the DNS zone to check if it exists
+	oldRec := d.checkForRec(zone, rec)
+	if oldRec == nil {
 		klog.V(2).Infof("No PTR record found for %s/%s", rec.Name, ip)
 		return
 	}
 
 	// If records and pods have somehow got into inconsistent state
 	// we avoid deleting records that don't match the event.
-	if domain != list.Rrsets[0].Rrdatas[0] {
+	if !samePTR(domain, oldRec) {
 		klog.V(2).Infof("No PTR record found for %s with the same domain (%s)", rec.Name, domain)
 		return
 	}
-	d.revDeletion(rec)
+
+	// Records of others are not ours to remove
+	if !d.owned(zone, rec.Name) {
+		klog.Warningf("Record %s is not owned by %s. Not removing it.\n", rec.Name, d.owner.ID)
+		return
+	}
+	d.deletion(zone, oldRec)
+	d.release(zone, rec.Name)
 }
 
-// AddToService adds the given IP to A record with multiple IPs
+// AddToService adds the given IP to A or AAAA record with multiple IPs
 func (d *DNSRequest) AddToService(domain, ip string) {
 
 	rec := &dns.ResourceRecordSet{
 		Name:    fmt.Sprintf("%s.", domain),
 		Rrdatas: []string{ip},
-		Ttl:     defaultTTL,
-		Type:    typeA,
+		Ttl:     d.ttl.For(pdns.KindService),
+		Type:    pdns.AddressType(ip),
 	}
 
-	oldRec := d.client.checkForRec(rec)
+	oldRec := d.checkForRec(d.client.zone, rec)
 
 	if oldRec != nil && dataContains(oldRec, ip) {
 		klog.V(2).Infof("Service %s record exists and contains %s\n", domain, ip)
@@ -289,62 +613,90 @@ func (d *DNSRequest) AddToService(domain, ip string) {
 
 	// Service exists and we need to add the IP
 	if oldRec != nil {
+		if !d.owned(d.client.zone, rec.Name) {
+			d.conflict(rec.Name)
+			return
+		}
 		rec.Rrdatas = append(rec.Rrdatas, oldRec.Rrdatas...)
-		d.deletion(oldRec)
+		d.deletion(d.client.zone, oldRec)
 	}
-	d.addition(rec)
+	d.addition(d.client.zone, rec)
+	d.claim(d.client.zone, rec.Name, pdns.KindService)
 }
 
-// RemoveFromService removes given IP from an A record with multiple IPs
+// RemoveFromService removes given IP from an A or AAAA record with multiple IPs
 func (d *DNSRequest) RemoveFromService(domain, ip string) {
 	rec := &dns.ResourceRecordSet{
 		Name:    fmt.Sprintf("%s.", domain),
 		Rrdatas: nil,
-		Ttl:     defaultTTL,
-		Type:    typeA,
+		Ttl:     d.ttl.For(pdns.KindService),
+		Type:    pdns.AddressType(ip),
 	}
 
-	oldRec := d.client.checkForRec(rec)
+	oldRec := d.checkForRec(d.client.zone, rec)
 	if oldRec == nil {
 		klog.V(2).Infof("No record exists for %s\n", domain)
 		return
 	}
 
-	if newRec, ok := removeData(oldRec, ip); ok {
-		d.addition(newRec)
-	} else {
+	newRec, ok := removeData(oldRec, ip)
+	if !ok {
 		klog.V(2).Infof("%s service doesn't include %s\n", domain, ip)
+		return
+	}
+
+	if !d.owned(d.client.zone, rec.Name) {
+		klog.Warningf("Record %s is not owned by %s. Not removing %s from it.\n", rec.Name, d.owner.ID, ip)
+		return
 	}
 
-	d.deletion(oldRec)
+	d.deletion(d.client.zone, oldRec)
+	if newRec != nil {
+		d.addition(d.client.zone, newRec)
+	} else {
+		d.releaseAddress(rec.Name, rec.Type)
+	}
 }
 
-// AddToSRV adds domain to SRV record
-func (d *DNSRequest) AddToSRV(srv, domain string, priority int) {
+// AddToSRV adds target to SRV record.
+// Target with other priority, weight or port gets replaced.
+func (d *DNSRequest) AddToSRV(srv string, data pdns.SRV) {
 
 	rec := &dns.ResourceRecordSet{
 		Name:    fmt.Sprintf("%s.", srv),
-		Rrdatas: []string{domain},
-		Ttl:     defaultTTL,
+		Rrdatas: []string{data.String()},
+		Ttl:     d.ttl.For(pdns.KindSRV),
 		Type:    typeSRV,
 	}
 
-	oldRec := d.client.checkForRec(rec)
+	oldRec := d.checkForRec(d.client.zone, rec)
 
 	if oldRec != nil {
 		// Failsafe
-		if rec.Name == oldRec.Name && dataContains(oldRec, domain) {
+		if rec.Name == oldRec.Name && dataContains(oldRec, rec.Rrdatas[0]) {
 			klog.V(2).Infof("Record exists: %+v\n", oldRec)
 			return
 		}
 
-		// We need to add the new endpoint
+		// We need to add the new endpoint.
+		// Data written before the full SRV data was supported is only the target.
 		if rec.Name == oldRec.Name {
-			rec.Rrdatas = append(rec.Rrdatas, oldRec.Rrdatas...)
-			d.deletion(oldRec)
+			if !d.owned(d.client.zone, rec.Name) {
+				d.conflict(rec.Name)
+				return
+			}
+			kept := oldRec
+			if old, found := srvData(oldRec, data.Target); found {
+				kept, _ = removeData(oldRec, old)
+			}
+			if kept != nil {
+				rec.Rrdatas = append(rec.Rrdatas, kept.Rrdatas...)
+			}
+			d.deletion(d.client.zone, oldRec)
 		}
 	}
-	d.addition(rec)
+	d.addition(d.client.zone, rec)
+	d.claim(d.client.zone, rec.Name, pdns.KindSRV)
 }
 
 // RemoveFromSRV removes domain from SRV record
@@ -352,26 +704,47 @@ func (d *DNSRequest) RemoveFromSRV(srv, domain string) {
 	rec := &dns.ResourceRecordSet{
 		Name:    fmt.Sprintf("%s.", srv),
 		Rrdatas: nil,
-		Ttl:     defaultTTL,
+		Ttl:     d.ttl.For(pdns.KindSRV),
 		Type:    typeSRV,
 	}
 
-	oldRec := d.client.checkForRec(rec)
+	oldRec := d.checkForRec(d.client.zone, rec)
 	if oldRec == nil {
 		klog.V(2).Infof("No record exists for %s\n", srv)
 		return
 	}
 
-	if newRec, ok := removeData(rec, domain); ok {
-		d.addition(newRec)
-	} else {
-		klog.V(2).Infof("%s doesn't include  %s\n", srv, domain)
+	data, found := srvData(oldRec, domain)
+	if !found {
+		klog.V(2).Infof("%s doesn't include %s\n", srv, domain)
+		return
+	}
+	newRec, _ := removeData(oldRec, data)
+
+	if !d.owned(d.client.zone, rec.Name) {
+		klog.Warningf("Record %s is not owned by %s. Not removing %s from it.\n", rec.Name, d.owner.ID, domain)
+		return
 	}
 
-	d.deletion(oldRec)
+	d.deletion(d.client.zone, oldRec)
+	if newRec != nil {
+		d.addition(d.client.zone, newRec)
+	} else {
+		d.release(d.client.zone, rec.Name)
+	}
 }
 
 // UTILS
+// srvData returns the SRV record data pointing to the given domain
+func srvData(rec *dns.ResourceRecordSet, domain string) (string, bool) {
+	for _, d := range rec.Rrdatas {
+		if pdns.SRVTarget(d) == pdns.SRVTarget(domain) {
+			return d, true
+		}
+	}
+	return "", false
+}
+
 func dataContains(rec *dns.ResourceRecordSet, data string) bool {
 	for _, d := range rec.Rrdatas {
 		if d == data {
@@ -381,6 +754,17 @@ func dataContains(rec *dns.ResourceRecordSet, data string) bool {
 	return false
 }
 
+// samePTR tells if the PTR record set points to the domain.
+// Records written before the data had the trailing dot are matched too.
+func samePTR(domain string, rec *dns.ResourceRecordSet) bool {
+	if len(rec.Rrdatas) != 1 {
+		return false
+	}
+	return strings.EqualFold(strings.TrimSuffix(domain, "."), strings.TrimSuffix(rec.Rrdatas[0], "."))
+}
+
+// removeData returns a copy of the record set without the given data.
+// Nil is returned when nothing would be left. False is returned when the data wasn't found.
 func removeData(rec *dns.ResourceRecordSet, data string) (*dns.ResourceRecordSet, bool) {
 	newRec := *rec
 	newRec.Rrdatas = []string{}
@@ -388,8 +772,19 @@ func removeData(rec *dns.ResourceRecordSet, data string) (*dns.ResourceRecordSet
 	for _, v := range rec.Rrdatas {
 		if v != data {
 			newRec.Rrdatas = append(newRec.Rrdatas, v)
-			return &newRec, true
 		}
 	}
-	return nil, false
+
+	if len(newRec.Rrdatas) == len(rec.Rrdatas) {
+		return nil, false
+	}
+
+	if len(newRec.Rrdatas) == 0 {
+		return nil, true
+	}
+	return &newRec, true
+}
+
+func sameRecordSet(a, b *dns.ResourceRecordSet) bool {
+	return strings.EqualFold(a.Name, b.Name) && a.Type == b.Type
 }
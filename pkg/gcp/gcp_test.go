@@ -1,22 +1,461 @@
 package gcp
 
 import (
-	//"github.com/stretchr/testify/assert"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
 	"testing"
+
+	"github.com/tanelmae/private-dns/internal/pdns"
+	"github.com/tanelmae/private-dns/internal/pdns/pdnstest"
+	"google.golang.org/api/dns/v1"
+	"google.golang.org/api/option"
+)
+
+const (
+	testProject = "project"
+	testZone    = "forward"
+	testRevZone = "reverse"
+	// Reverse zone of the 10.1.0.0/16 pod CIDR
+	testPodRevZone = "reverse-pods"
+	testV6RevZone  = "reverse-v6"
 )
 
-func TestARecord(t *testing.T) {
-	// TODO
+var dnsNames = map[string]string{
+	testZone:       "example.com.",
+	testRevZone:    "10.in-addr.arpa.",
+	testPodRevZone: "1.10.in-addr.arpa.",
+	testV6RevZone:  "d.f.ip6.arpa.",
+}
+
+// fakeCloudDNS is a minimal local stand-in for CloudDNS API.
+// Changes are rejected like CloudDNS does when a deletion doesn't match
+// the existing record set or an addition already exists.
+type fakeCloudDNS struct {
+	mu      sync.Mutex
+	zones   map[string]map[string]*dns.ResourceRecordSet
+	changes int
 }
 
-func TestSRV(t *testing.T) {
-	// TODO
+func (f *fakeCloudDNS) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if r.URL.Path == fmt.Sprintf("/projects/%s/managedZones", testProject) && r.Method == http.MethodGet {
+		resp := &dns.ManagedZonesListResponse{ManagedZones: []*dns.ManagedZone{}}
+		for name := range f.zones {
+			resp.ManagedZones = append(resp.ManagedZones, &dns.ManagedZone{Name: name, DnsName: dnsNames[name]})
+		}
+		json.NewEncoder(w).Encode(resp)
+		return
+	}
+
+	prefix := fmt.Sprintf("/projects/%s/managedZones/", testProject)
+	parts := strings.Split(strings.TrimPrefix(r.URL.Path, prefix), "/")
+	records, ok := f.zones[parts[0]]
+	if !ok {
+		http.Error(w, "not found", http.StatusNotFound)
+		return
+	}
+
+	switch {
+	case len(parts) == 1 && r.Method == http.MethodGet:
+		json.NewEncoder(w).Encode(&dns.ManagedZone{Name: parts[0], DnsName: dnsNames[parts[0]]})
+	case len(parts) < 2:
+		http.Error(w, "not supported", http.StatusMethodNotAllowed)
+	case parts[1] == "rrsets" && r.Method == http.MethodGet:
+		resp := &dns.ResourceRecordSetsListResponse{Rrsets: []*dns.ResourceRecordSet{}}
+		for _, rec := range records {
+			if name := r.URL.Query().Get("name"); name != "" && name != rec.Name {
+				continue
+			}
+			if recType := r.URL.Query().Get("type"); recType != "" && recType != rec.Type {
+				continue
+			}
+			resp.Rrsets = append(resp.Rrsets, rec)
+		}
+		json.NewEncoder(w).Encode(resp)
+	case parts[1] == "changes" && r.Method == http.MethodPost:
+		chg := &dns.Change{}
+		if err := json.NewDecoder(r.Body).Decode(chg); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		for _, rec := range chg.Deletions {
+			old, exists := records[key(rec)]
+			if !exists || fmt.Sprint(old.Rrdatas) != fmt.Sprint(rec.Rrdatas) {
+				http.Error(w, "deletion doesn't match "+key(rec), http.StatusPreconditionFailed)
+				return
+			}
+		}
+		for _, rec := range chg.Deletions {
+			delete(records, key(rec))
+		}
+
+		for _, rec := range chg.Additions {
+			if _, exists := records[key(rec)]; exists {
+				http.Error(w, "already exists "+key(rec), http.StatusConflict)
+				return
+			}
+			records[key(rec)] = rec
+		}
+
+		f.changes++
+		chg.Id = fmt.Sprint(f.changes)
+		chg.Status = "done"
+		json.NewEncoder(w).Encode(chg)
+	default:
+		http.Error(w, "not supported", http.StatusMethodNotAllowed)
+	}
+}
+
+func key(rec *dns.ResourceRecordSet) string {
+	return fmt.Sprintf("%s|%s", rec.Name, rec.Type)
+}
+
+func (f *fakeCloudDNS) values(zone, name, recType string) []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	values := []string{}
+	if rec, ok := f.zones[zone][fmt.Sprintf("%s|%s", name, recType)]; ok {
+		values = append(values, rec.Rrdatas...)
+	}
+	sort.Strings(values)
+	return values
+}
+
+func newTestClient(t *testing.T) (*CloudDNS, *fakeCloudDNS) {
+	fake := &fakeCloudDNS{zones: map[string]map[string]*dns.ResourceRecordSet{
+		testZone:       {},
+		testRevZone:    {},
+		testPodRevZone: {},
+		testV6RevZone:  {},
+	}}
+	srv := httptest.NewServer(fake)
+	t.Cleanup(srv.Close)
+
+	api, err := dns.NewService(context.Background(),
+		option.WithEndpoint(srv.URL+"/projects/"), option.WithHTTPClient(srv.Client()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return New(api, testZone, []string{testRevZone}, testProject), fake
 }
 
-func TestService(t *testing.T) {
-	// TODO
+func TestProvider(t *testing.T) {
+	pdnstest.Run(t, func(t *testing.T) pdnstest.Fake {
+		client, fake := newTestClient(t)
+		return pdnstest.Fake{
+			Provider: client,
+			Values: func(name, recType string) []string {
+				if strings.HasSuffix(name, dnsNames[testRevZone]) {
+					return fake.values(testRevZone, name, recType)
+				}
+				return fake.values(testZone, name, recType)
+			},
+			Writes: func() int { return fake.changes },
+		}
+	})
+}
+
+func TestDualStack(t *testing.T) {
+	client, fake := newTestClient(t)
+	client.owner = pdns.Owner{ID: "cluster-a"}
+	txtName := "_private-dns.pod-0.app.example.com."
+
+	req := client.NewRequest()
+	req.AddRecord("pod-0.app.example.com", "10.0.0.1")
+	req.AddRecord("pod-0.app.example.com", "fd00::1")
+	pdnstest.Do(t, req)
+	pdnstest.AssertValues(t, fake.values(testZone, "pod-0.app.example.com.", pdns.TypeA), "10.0.0.1")
+	pdnstest.AssertValues(t, fake.values(testZone, "pod-0.app.example.com.", pdns.TypeAAAA), "fd00::1")
+	// IPv6 reverse name is not in the IPv4 reverse zone
+	pdnstest.AssertValues(t, fake.values(testRevZone, "1.0.0.10.in-addr.arpa.", typePTR), "pod-0.app.example.com.")
+
+	// Ownership record is shared by both address records
+	req = client.NewRequest()
+	req.RemoveRecord("pod-0.app.example.com", "10.0.0.1")
+	pdnstest.Do(t, req)
+	if len(fake.values(testZone, txtName, typeTXT)) != 1 {
+		t.Fatal("expected ownership record to be kept for the AAAA record")
+	}
+
+	req = client.NewRequest()
+	req.AddRecord("pod-0.app.example.com", "10.0.0.1")
+	pdnstest.Do(t, req)
+
+	req = client.NewRequest()
+	req.RemoveRecord("pod-0.app.example.com", "10.0.0.1")
+	req.RemoveRecord("pod-0.app.example.com", "fd00::1")
+	pdnstest.Do(t, req)
+	pdnstest.AssertValues(t, fake.values(testZone, "pod-0.app.example.com.", pdns.TypeA))
+	pdnstest.AssertValues(t, fake.values(testZone, "pod-0.app.example.com.", pdns.TypeAAAA))
+	pdnstest.AssertValues(t, fake.values(testZone, txtName, typeTXT))
+}
+
+func TestReverseZones(t *testing.T) {
+	client, fake := newTestClient(t)
+	client.reverseZones = []string{testRevZone, testPodRevZone}
+	client.owner = pdns.Owner{ID: "cluster-a"}
+
+	req := client.NewRequest()
+	req.AddRecord("pod-0.app.example.com", "10.0.0.1")
+	req.AddRecord("pod-1.app.example.com", "10.1.0.2")
+	req.AddRecord("pod-2.app.example.com", "fd00::3")
+	pdnstest.Do(t, req)
+
+	// Longest matching zone gets the PTR record with its TXT ownership record
+	pdnstest.AssertValues(t, fake.values(testRevZone, "1.0.0.10.in-addr.arpa.", typePTR), "pod-0.app.example.com.")
+	pdnstest.AssertValues(t, fake.values(testPodRevZone, "2.0.1.10.in-addr.arpa.", typePTR), "pod-1.app.example.com.")
+	pdnstest.AssertValues(t, fake.values(testRevZone, "2.0.1.10.in-addr.arpa.", typePTR))
+	if len(fake.values(testPodRevZone, "_private-dns.2.0.1.10.in-addr.arpa.", typeTXT)) != 1 {
+		t.Fatal("expected ownership record in the pod reverse zone")
+	}
+	if len(fake.zones[testV6RevZone]) != 0 {
+		t.Fatalf("unexpected records in IPv6 reverse zone: %v", fake.zones[testV6RevZone])
+	}
+
+	owned, err := client.OwnedRecords()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(owned) != 5 {
+		t.Fatalf("expected 3 address and 2 PTR records, got %v", owned)
+	}
+
+	req = client.NewRequest()
+	req.RemoveRecord("pod-1.app.example.com", "10.1.0.2")
+	pdnstest.Do(t, req)
+	pdnstest.AssertValues(t, fake.values(testPodRevZone, "2.0.1.10.in-addr.arpa.", typePTR))
+	pdnstest.AssertValues(t, fake.values(testPodRevZone, "_private-dns.2.0.1.10.in-addr.arpa.", typeTXT))
+	pdnstest.AssertValues(t, fake.values(testRevZone, "1.0.0.10.in-addr.arpa.", typePTR), "pod-0.app.example.com.")
+}
+
+func TestDiscoverReverseZones(t *testing.T) {
+	client, fake := newTestClient(t)
+	client.reverseZones = nil
+	client.discoverReverse = true
+
+	req := client.NewRequest()
+	req.AddRecord("pod-0.app.example.com", "10.1.0.1")
+	req.AddRecord("pod-0.app.example.com", "fd00::1")
+	pdnstest.Do(t, req)
+	pdnstest.AssertValues(t, fake.values(testPodRevZone, "1.0.1.10.in-addr.arpa.", typePTR), "pod-0.app.example.com.")
+	pdnstest.AssertValues(t, fake.values(testV6RevZone,
+		"1.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.d.f.ip6.arpa.", typePTR), "pod-0.app.example.com.")
+}
+
+func TestReverseZoneError(t *testing.T) {
+	client, fake := newTestClient(t)
+	client.reverseZones = []string{"missing"}
+
+	// Failed lookup fails the request so it gets retried
+	req := client.NewRequest()
+	req.AddRecord("pod-0.app.example.com", "10.0.0.1")
+	if err := req.Do(); err == nil {
+		t.Fatal("expected failed reverse zone lookup to fail the request")
+	}
+	pdnstest.AssertValues(t, fake.values(testZone, "pod-0.app.example.com.", pdns.TypeA))
+}
+
+func TestRefreshReverseZones(t *testing.T) {
+	client, fake := newTestClient(t)
+	client.reverseZones = nil
+	client.discoverReverse = true
+	delete(fake.zones, testPodRevZone)
+
+	req := client.NewRequest()
+	req.AddRecord("pod-0.app.example.com", "10.1.0.1")
+	pdnstest.Do(t, req)
+	pdnstest.AssertValues(t, fake.values(testRevZone, "1.0.1.10.in-addr.arpa.", typePTR), "pod-0.app.example.com.")
+
+	// Zone created later is found once the zones are looked up again
+	fake.mu.Lock()
+	fake.zones[testPodRevZone] = map[string]*dns.ResourceRecordSet{}
+	fake.mu.Unlock()
+	client.reverseFetched = client.reverseFetched.Add(-reverseRefresh)
+
+	req = client.NewRequest()
+	req.AddRecord("pod-1.app.example.com", "10.1.0.2")
+	pdnstest.Do(t, req)
+	pdnstest.AssertValues(t, fake.values(testPodRevZone, "2.0.1.10.in-addr.arpa.", typePTR), "pod-1.app.example.com.")
+}
+
+func TestLegacyPTR(t *testing.T) {
+	client, fake := newTestClient(t)
+
+	// PTR data written without the trailing dot is replaced and removed
+	fake.zones[testRevZone]["1.0.0.10.in-addr.arpa.|PTR"] = &dns.ResourceRecordSet{
+		Name: "1.0.0.10.in-addr.arpa.", Type: typePTR, Rrdatas: []string{"pod-0.app.example.com"},
+	}
+	req := client.NewRequest()
+	req.AddReverseRecord("pod-0.app.example.com", "10.0.0.1")
+	pdnstest.Do(t, req)
+	pdnstest.AssertValues(t, fake.values(testRevZone, "1.0.0.10.in-addr.arpa.", typePTR), "pod-0.app.example.com.")
+
+	fake.zones[testRevZone]["1.0.0.10.in-addr.arpa.|PTR"].Rrdatas = []string{"pod-0.app.example.com"}
+	req = client.NewRequest()
+	req.RemoveReverseRecord("pod-0.app.example.com", "10.0.0.1")
+	pdnstest.Do(t, req)
+	pdnstest.AssertValues(t, fake.values(testRevZone, "1.0.0.10.in-addr.arpa.", typePTR))
+}
+
+func TestOwnership(t *testing.T) {
+	client, fake := newTestClient(t)
+	client.owner = pdns.Owner{ID: "cluster-a", Cluster: "sauna"}
+
+	// Records created by someone else
+	fake.zones[testZone]["app.example.com.|A"] = &dns.ResourceRecordSet{
+		Name: "app.example.com.", Type: pdns.TypeA, Rrdatas: []string{"10.0.1.1"}}
+	fake.zones[testZone]["pod-1.app.example.com.|A"] = &dns.ResourceRecordSet{
+		Name: "pod-1.app.example.com.", Type: pdns.TypeA, Rrdatas: []string{"10.0.1.2"}}
+
+	req := client.NewRequest()
+	req.(pdns.OwnedRequest).SetResource("default", "app")
+	req.AddRecord("pod-0.app.example.com", "10.0.0.1")
+	req.AddRecord("pod-1.app.example.com", "10.0.0.2")
+	req.AddToService("app.example.com", "10.0.0.1")
+	if err := req.Do(); err == nil || !strings.Contains(err.Error(), "pod-1.app.example.com.") {
+		t.Fatalf("expected ownership conflict, got %v", err)
+	}
+
+	// Owned record and its TXT record were written
+	pdnstest.AssertValues(t, fake.values(testZone, "pod-0.app.example.com.", pdns.TypeA), "10.0.0.1")
+	txt := fake.values(testZone, "_private-dns.pod-0.app.example.com.", typeTXT)
+	owner, ok := pdns.ParseOwner(txt[0])
+	if !ok || owner != (pdns.Owner{ID: "cluster-a", Cluster: "sauna", Namespace: "default", Name: "app", Kind: pdns.KindPod}) {
+		t.Fatalf("unexpected owner record %v", txt)
+	}
+
+	// Records of others were left alone
+	pdnstest.AssertValues(t, fake.values(testZone, "pod-1.app.example.com.", pdns.TypeA), "10.0.1.2")
+	pdnstest.AssertValues(t, fake.values(testZone, "app.example.com.", pdns.TypeA), "10.0.1.1")
+
+	req = client.NewRequest()
+	req.RemoveRecord("pod-1.app.example.com", "10.0.1.2")
+	req.RemoveFromService("app.example.com", "10.0.1.1")
+	req.RemoveRecord("pod-0.app.example.com", "10.0.0.1")
+	pdnstest.Do(t, req)
+
+	pdnstest.AssertValues(t, fake.values(testZone, "pod-1.app.example.com.", pdns.TypeA), "10.0.1.2")
+	pdnstest.AssertValues(t, fake.values(testZone, "app.example.com.", pdns.TypeA), "10.0.1.1")
+	pdnstest.AssertValues(t, fake.values(testZone, "pod-0.app.example.com.", pdns.TypeA))
+	pdnstest.AssertValues(t, fake.values(testZone, "_private-dns.pod-0.app.example.com.", typeTXT))
+}
+
+func TestOwnedRecords(t *testing.T) {
+	client, fake := newTestClient(t)
+	client.owner = pdns.Owner{ID: "cluster-a", Cluster: "sauna"}
+
+	req := client.NewRequest()
+	req.(pdns.OwnedRequest).SetResource("default", "app")
+	req.AddRecord("pod-0.app.example.com", "10.0.0.1")
+	req.AddToSRV("_http._tcp.example.com", pdns.SRV{Priority: 1, Target: "app.example.com"})
+	pdnstest.Do(t, req)
+
+	// Records of other owner and unowned records are not listed
+	other := pdns.Owner{ID: "cluster-b", Cluster: "sauna", Namespace: "default", Name: "app", Kind: pdns.KindPod}
+	fake.zones[testZone]["pod-1.app.example.com.|A"] = &dns.ResourceRecordSet{
+		Name: "pod-1.app.example.com.", Type: pdns.TypeA, Rrdatas: []string{"10.0.0.2"}}
+	fake.zones[testZone]["_private-dns.pod-1.app.example.com.|TXT"] = &dns.ResourceRecordSet{
+		Name: "_private-dns.pod-1.app.example.com.", Type: typeTXT, Rrdatas: []string{`"` + other.String() + `"`}}
+	fake.zones[testZone]["pod-2.app.example.com.|A"] = &dns.ResourceRecordSet{
+		Name: "pod-2.app.example.com.", Type: pdns.TypeA, Rrdatas: []string{"10.0.0.3"}}
+
+	records, err := client.OwnedRecords()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	names := []string{}
+	for _, r := range records {
+		names = append(names, fmt.Sprintf("%s %s %s/%s %s", r.Name, r.Type, r.Owner.Namespace, r.Owner.Name, r.Owner.Kind))
+	}
+	sort.Strings(names)
+	pdnstest.AssertValues(t, names,
+		"1.0.0.10.in-addr.arpa PTR default/app ptr",
+		"_http._tcp.example.com SRV default/app srv",
+		"pod-0.app.example.com A default/app pod",
+	)
+}
+
+func TestReverseOwnership(t *testing.T) {
+	client, fake := newTestClient(t)
+	client.owner = pdns.Owner{ID: "cluster-a", Cluster: "sauna"}
+
+	// PTR record created by someone else
+	fake.zones[testRevZone]["2.0.0.10.in-addr.arpa.|PTR"] = &dns.ResourceRecordSet{
+		Name: "2.0.0.10.in-addr.arpa.", Type: typePTR, Rrdatas: []string{"other.example.com"}}
+
+	req := client.NewRequest()
+	req.(pdns.OwnedRequest).SetResource("default", "app")
+	req.AddRecord("pod-0.app.example.com", "10.0.0.1")
+	req.AddReverseRecord("pod-1.app.example.com", "10.0.0.2")
+	if err := req.Do(); err == nil || !strings.Contains(err.Error(), "2.0.0.10.in-addr.arpa.") {
+		t.Fatalf("expected ownership conflict, got %v", err)
+	}
+
+	// Owned PTR and its TXT record are in the reverse zone
+	pdnstest.AssertValues(t, fake.values(testRevZone, "1.0.0.10.in-addr.arpa.", typePTR), "pod-0.app.example.com.")
+	if txt := fake.values(testRevZone, "_private-dns.1.0.0.10.in-addr.arpa.", typeTXT); len(txt) != 1 {
+		t.Fatalf("expected owner record in the reverse zone, got %v", txt)
+	}
+	pdnstest.AssertValues(t, fake.values(testRevZone, "2.0.0.10.in-addr.arpa.", typePTR), "other.example.com")
+
+	// Stale owned PTR is replaced
+	req = client.NewRequest()
+	req.(pdns.OwnedRequest).SetResource("default", "app")
+	req.AddReverseRecord("pod-1.app.example.com", "10.0.0.1")
+	pdnstest.Do(t, req)
+	pdnstest.AssertValues(t, fake.values(testRevZone, "1.0.0.10.in-addr.arpa.", typePTR), "pod-1.app.example.com.")
+
+	req = client.NewRequest()
+	req.RemoveReverseRecord("other.example.com", "10.0.0.2")
+	req.RemoveReverseRecord("pod-1.app.example.com", "10.0.0.1")
+	pdnstest.Do(t, req)
+	pdnstest.AssertValues(t, fake.values(testRevZone, "2.0.0.10.in-addr.arpa.", typePTR), "other.example.com")
+	pdnstest.AssertValues(t, fake.values(testRevZone, "1.0.0.10.in-addr.arpa.", typePTR))
+	pdnstest.AssertValues(t, fake.values(testRevZone, "_private-dns.1.0.0.10.in-addr.arpa.", typeTXT))
+}
+
+func TestCheckHealthMissingZone(t *testing.T) {
+	client, fake := newTestClient(t)
+	delete(fake.zones, testZone)
+	if err := client.CheckHealth(); err == nil {
+		t.Fatal("expected missing zone to fail the health check")
+	}
+}
+
+func TestChanges(t *testing.T) {
+	client, _ := newTestClient(t)
+
+	req := client.NewRequest()
+	req.AddRecord("pod-0.app.example.com", "10.0.0.1")
+	pdnstest.Do(t, req)
+
+	req = client.NewRequest()
+	req.AddRecord("pod-0.app.example.com", "10.0.0.2")
+	want := []string{
+		"delete pod-0.app.example.com. A [10.0.0.1] in forward",
+		"add pod-0.app.example.com. A 60 [10.0.0.2] in forward",
+		"add 2.0.0.10.in-addr.arpa. PTR 60 [pod-0.app.example.com.] in reverse",
+	}
+	if changes := req.(pdns.ChangeDescriber).Changes(); fmt.Sprint(changes) != fmt.Sprint(want) {
+		t.Fatalf("expected %v, got %v", want, changes)
+	}
 }
 
-func TestPTR(t *testing.T) {
-	// TODO
+func TestOptionsBadCredentials(t *testing.T) {
+	opts := &Options{Project: testProject, Zone: testZone, Credentials: filepath.Join(t.TempDir(), "missing.json")}
+	if _, err := opts.New(); err == nil {
+		t.Fatal("expected missing credentials file to fail")
+	}
 }
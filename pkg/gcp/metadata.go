@@ -22,6 +22,16 @@ func GetClusterLocation() (string, error) {
 	return getMetadata("instance/attributes/cluster-location")
 }
 
+// ClusterName returns GKE cluster name for subdomains
+func (c *CloudDNS) ClusterName() (string, error) {
+	return GetClusterName()
+}
+
+// ClusterLocation returns GKE cluster location for subdomains
+func (c *CloudDNS) ClusterLocation() (string, error) {
+	return GetClusterLocation()
+}
+
 func metadataRequest(urlPath string) (string, error) {
 	client := &http.Client{}
 	req, err := http.NewRequest("GET",
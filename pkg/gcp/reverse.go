@@ -0,0 +1,111 @@
+package gcp
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	"github.com/tanelmae/private-dns/internal/metrics"
+	"github.com/tanelmae/private-dns/internal/pdns"
+	"google.golang.org/api/dns/v1"
+	"k8s.io/klog/v2"
+)
+
+// Reverse zones are looked up again after this to find the zones created later
+const reverseRefresh = 10 * time.Minute
+
+// reverseEnabled tells if PTR records are written at all
+func (c *CloudDNS) reverseEnabled() bool {
+	return len(c.reverseZones) > 0 || c.discoverReverse
+}
+
+// reverseDNSNames returns the DNS names of the reverse zones by the managed zone name.
+// Only the managed zone names are configured so the zones are looked up and refreshed
+// periodically. In the discovery mode all the reverse zones of the project are used.
+// Previously found zones are kept when the refresh fails.
+func (c *CloudDNS) reverseDNSNames() (map[string]string, error) {
+	c.reverseMu.Lock()
+	defer c.reverseMu.Unlock()
+
+	if c.reverseNames != nil && time.Since(c.reverseFetched) < reverseRefresh {
+		return c.reverseNames, nil
+	}
+
+	names, err := c.fetchReverseZones()
+	if err != nil {
+		if c.reverseNames == nil {
+			return nil, err
+		}
+		klog.Errorf("Failed to refresh reverse zones, using the previous ones: %s\n", err)
+		return c.reverseNames, nil
+	}
+
+	c.reverseNames = names
+	c.reverseFetched = time.Now()
+	return c.reverseNames, nil
+}
+
+// fetchReverseZones looks the DNS names of the reverse zones up from the API
+func (c *CloudDNS) fetchReverseZones() (map[string]string, error) {
+
+	names := make(map[string]string)
+	if c.discoverReverse {
+		start := time.Now()
+		err := c.api.ManagedZones.List(c.project).Pages(context.Background(),
+			func(list *dns.ManagedZonesListResponse) error {
+				for _, zone := range list.ManagedZones {
+					if pdns.IsReverseZone(zone.DnsName) {
+						names[zone.Name] = zone.DnsName
+					}
+				}
+				return nil
+			})
+		metrics.ObserveCall(providerName, "list-zones", c.project, start, err)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	for _, name := range c.reverseZones {
+		if _, ok := names[name]; ok {
+			continue
+		}
+
+		start := time.Now()
+		zone, err := c.api.ManagedZones.Get(c.project, name).Do()
+		metrics.ObserveCall(providerName, "get-zone", name, start, err)
+		if err != nil {
+			return nil, err
+		}
+		names[name] = zone.DnsName
+	}
+	return names, nil
+}
+
+// reverseZoneOf returns the reverse zone with the longest DNS name the reverse lookup name is in.
+// Empty zone is returned when the name is in none of the reverse zones.
+func (c *CloudDNS) reverseZoneOf(name string) (string, error) {
+	zones, err := c.reverseDNSNames()
+	if err != nil {
+		return "", err
+	}
+
+	match, longest := "", 0
+	for _, zone := range sortedZones(zones) {
+		dnsName := zones[zone]
+		if pdns.InDomain(name, dnsName) && len(dnsName) > longest {
+			match, longest = zone, len(dnsName)
+		}
+	}
+	return match, nil
+}
+
+// sortedZones returns the managed zone names in a stable order
+func sortedZones(zones map[string]string) []string {
+	names := make([]string, 0, len(zones))
+	for name := range zones {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
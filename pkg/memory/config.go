@@ -0,0 +1,43 @@
+package memory
+
+import (
+	"errors"
+	"flag"
+
+	"github.com/tanelmae/private-dns/internal/pdns"
+)
+
+func init() {
+	pdns.Register(providerName, func() pdns.ProviderConfig { return &Options{Listen: ":53"} })
+}
+
+// Options holds built-in DNS server configuration
+type Options struct {
+	Zone         string `json:"zone"`
+	ReverseZone  string `json:"reverse-zone"`
+	Listen       string `json:"listen"`
+	Nameserver   string `json:"ns"`
+	NameserverIP string `json:"ns-ip"`
+}
+
+// RegisterFlags adds built-in DNS server flags to the flag set
+func (o *Options) RegisterFlags(fs *flag.FlagSet) {
+	fs.StringVar(&o.Zone, "dns-server-zone", "", "Zone served by the built-in DNS server")
+	fs.StringVar(&o.ReverseZone, "dns-server-reverse-zone", "", "Reverse lookup zone served by the built-in DNS server")
+	fs.StringVar(&o.Listen, "dns-server-listen", o.Listen, "UDP and TCP address for the built-in DNS server")
+	fs.StringVar(&o.Nameserver, "dns-server-ns", "", "Nameserver name for the built-in DNS server zones. Defaults to ns.<zone>.")
+	fs.StringVar(&o.NameserverIP, "dns-server-ns-ip", "", "IP published for the nameserver name")
+}
+
+// New creates the in-memory zones and starts serving them
+func (o *Options) New() (pdns.DNSProvider, error) {
+	if o.Zone == "" {
+		return nil, errors.New("-dns-server-zone is required")
+	}
+
+	zones := New(o.Zone, o.ReverseZone, o.Nameserver, o.NameserverIP)
+	if err := NewServer(zones, o.Listen).Start(); err != nil {
+		return nil, err
+	}
+	return zones, nil
+}
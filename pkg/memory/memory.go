@@ -0,0 +1,558 @@
+package memory
+
+import (
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/tanelmae/private-dns/internal/metrics"
+	"github.com/tanelmae/private-dns/internal/pdns"
+	"k8s.io/klog/v2"
+)
+
+const (
+	providerName = "memory"
+
+	// TTL of the zone SOA, NS and nameserver records
+	defaultTTL uint32 = pdns.DefaultTTL
+
+	// Owner ID of the records. Zones are only written by this process.
+	ownerID = providerName
+)
+
+// Zones keeps the records in memory. Used as the backing store
+// for the built-in authoritative DNS server.
+type Zones struct {
+	mu          sync.RWMutex
+	zone        string
+	reverseZone string
+	nameserver  string
+	serial      uint32
+	records     map[string][]dns.RR
+	// PrivateDNS resources that wrote the record sets
+	owners map[string]pdns.Owner
+}
+
+// New creates in-memory zones. Nameserver defaults to ns.<zone>
+// and its IP is published as glue record when given.
+func New(zone, reverseZone, nameserver, nsIP string) *Zones {
+	z := &Zones{
+		zone:       dns.Fqdn(strings.ToLower(zone)),
+		nameserver: dns.Fqdn(nameserver),
+		serial:     1,
+		records:    make(map[string][]dns.RR),
+		owners:     make(map[string]pdns.Owner),
+	}
+
+	if reverseZone != "" {
+		z.reverseZone = dns.Fqdn(strings.ToLower(reverseZone))
+	}
+
+	if nameserver == "" {
+		z.nameserver = fmt.Sprintf("ns.%s", z.zone)
+	}
+
+	if ip := net.ParseIP(nsIP).To4(); ip != nil {
+		z.records[recordKey(z.nameserver, dns.TypeA)] = []dns.RR{&dns.A{
+			Hdr: header(z.nameserver, dns.TypeA, defaultTTL),
+			A:   ip,
+		}}
+	}
+	return z
+}
+
+// NewRequest creates a new change request for the in-memory zones
+func (z *Zones) NewRequest() pdns.DNSRequest {
+	return &DNSRequest{
+		client: z,
+		owner:  pdns.Owner{ID: ownerID},
+	}
+}
+
+// ListRecords returns the records under the given domain grouped into record sets
+func (z *Zones) ListRecords(domain string) ([]pdns.Record, error) {
+	z.mu.RLock()
+	defer z.mu.RUnlock()
+
+	records := []pdns.Record{}
+	for _, rrs := range z.records {
+		if pdns.InDomain(rrs[0].Header().Name, domain) {
+			records = append(records, recordSet(rrs))
+		}
+	}
+	return records, nil
+}
+
+// ReplicaLocal tells that the records are only in the memory of this replica
+func (z *Zones) ReplicaLocal() bool {
+	return true
+}
+
+// OwnerID returns the owner ID of the records written by this process
+func (z *Zones) OwnerID() string {
+	return ownerID
+}
+
+// OwnedRecords returns the record sets in the zones together with
+// the PrivateDNS resource that wrote them
+func (z *Zones) OwnedRecords() ([]pdns.OwnedRecord, error) {
+	z.mu.RLock()
+	defer z.mu.RUnlock()
+
+	records := []pdns.OwnedRecord{}
+	for key, owner := range z.owners {
+		if rrs, ok := z.records[key]; ok {
+			records = append(records, pdns.OwnedRecord{Record: recordSet(rrs), Owner: owner})
+		}
+	}
+	return records, nil
+}
+
+func recordSet(rrs []dns.RR) pdns.Record {
+	values := []string{}
+	for _, rr := range rrs {
+		values = append(values, strings.TrimPrefix(rr.String(), rr.Header().String()))
+	}
+	return pdns.Record{
+		Name:   strings.TrimSuffix(rrs[0].Header().Name, "."),
+		Type:   dns.TypeToString[rrs[0].Header().Rrtype],
+		Values: values,
+	}
+}
+
+// zoneFor returns the zone the name belongs to
+func (z *Zones) zoneFor(name string) (string, bool) {
+	for _, zone := range []string{z.zone, z.reverseZone} {
+		if zone != "" && dns.IsSubDomain(zone, name) {
+			return zone, true
+		}
+	}
+	return "", false
+}
+
+func (z *Zones) soa(zone string) dns.RR {
+	return &dns.SOA{
+		Hdr:     header(zone, dns.TypeSOA, defaultTTL),
+		Ns:      z.nameserver,
+		Mbox:    fmt.Sprintf("hostmaster.%s", zone),
+		Serial:  z.serial,
+		Refresh: 3600,
+		Retry:   600,
+		Expire:  86400,
+		Minttl:  defaultTTL,
+	}
+}
+
+func (z *Zones) ns(zone string) dns.RR {
+	return &dns.NS{
+		Hdr: header(zone, dns.TypeNS, defaultTTL),
+		Ns:  z.nameserver,
+	}
+}
+
+// lookup returns the records of given type and whether the name exists at all.
+// Caller must hold the read lock.
+func (z *Zones) lookup(zone, name string, qtype uint16) ([]dns.RR, bool) {
+	if name == zone {
+		apex := []dns.RR{z.soa(zone), z.ns(zone)}
+		switch qtype {
+		case dns.TypeSOA:
+			return apex[:1], true
+		case dns.TypeNS:
+			return apex[1:], true
+		case dns.TypeANY:
+			return apex, true
+		}
+	}
+
+	if qtype == dns.TypeANY {
+		records := []dns.RR{}
+		for key, rrs := range z.records {
+			if strings.HasPrefix(key, name+"|") {
+				records = append(records, rrs...)
+			}
+		}
+		return records, len(records) > 0 || z.nameExists(zone, name)
+	}
+
+	if rrs, ok := z.records[recordKey(name, qtype)]; ok {
+		return rrs, true
+	}
+	return nil, z.nameExists(zone, name)
+}
+
+// nameExists checks if the name owns any records
+// or is an empty non-terminal with records below it
+func (z *Zones) nameExists(zone, name string) bool {
+	if name == zone {
+		return true
+	}
+	for key := range z.records {
+		owner := key[:strings.Index(key, "|")]
+		if owner == name || strings.HasSuffix(owner, "."+name) {
+			return true
+		}
+	}
+	return false
+}
+
+// DNSRequest holds the changes that are applied together
+type DNSRequest struct {
+	client  *Zones
+	owner   pdns.Owner
+	ttl     pdns.TTL
+	changes []func(records map[string][]dns.RR)
+	// Kinds of the added record sets by record key
+	added map[string]pdns.RecordKind
+}
+
+// SetResource sets the PrivateDNS resource the added records are owned by
+func (d *DNSRequest) SetResource(namespace, name string) {
+	d.owner.Namespace = namespace
+	d.owner.Name = name
+}
+
+// SetTTL sets the TTL of the records added in the request
+func (d *DNSRequest) SetTTL(ttl pdns.TTL) {
+	d.ttl = ttl
+}
+
+// Do applies all the attached changes at once and bumps the zone serial
+// No error would be returned when no changes have been added
+func (d *DNSRequest) Do() (err error) {
+	if len(d.changes) == 0 {
+		return nil
+	}
+	defer metrics.ObserveRequest(providerName, time.Now(), &err)
+
+	d.client.mu.Lock()
+	defer d.client.mu.Unlock()
+
+	for _, change := range d.changes {
+		change(d.client.records)
+	}
+	d.client.own(d.owner, d.added)
+	d.client.serial++
+	return nil
+}
+
+// Changes returns the records the request would remove and add.
+// Changes are applied to a copy of the current records.
+func (d *DNSRequest) Changes() []string {
+	d.client.mu.RLock()
+	before := make(map[string][]dns.RR, len(d.client.records))
+	after := make(map[string][]dns.RR, len(d.client.records))
+	for key, rrs := range d.client.records {
+		before[key] = rrs
+		after[key] = append([]dns.RR{}, rrs...)
+	}
+	d.client.mu.RUnlock()
+
+	for _, change := range d.changes {
+		change(after)
+	}
+
+	changes := []string{}
+	for _, rr := range diffRRs(before, after) {
+		changes = append(changes, fmt.Sprintf("remove %s", rrString(rr)))
+	}
+	for _, rr := range diffRRs(after, before) {
+		changes = append(changes, fmt.Sprintf("add %s", rrString(rr)))
+	}
+	return changes
+}
+
+// diffRRs returns the records of a that are not in b sorted by their text.
+// Records are compared with the TTL so the changed TTLs show up too.
+func diffRRs(a, b map[string][]dns.RR) []dns.RR {
+	diff := []dns.RR{}
+	for key, rrs := range a {
+		existing := make(map[string]bool)
+		for _, rr := range b[key] {
+			existing[rr.String()] = true
+		}
+		for _, rr := range rrs {
+			if !existing[rr.String()] {
+				diff = append(diff, rr)
+			}
+		}
+	}
+	sort.Slice(diff, func(i, j int) bool { return diff[i].String() < diff[j].String() })
+	return diff
+}
+
+// rrString returns the record in the zone file format on a single line
+func rrString(rr dns.RR) string {
+	return strings.Join(strings.Fields(rr.String()), " ")
+}
+
+func (d *DNSRequest) change(fn func(records map[string][]dns.RR)) {
+	d.changes = append(d.changes, fn)
+}
+
+// add queues the change adding the record and keeps track of its kind for the ownership
+func (d *DNSRequest) add(rec dns.RR, kind pdns.RecordKind, fn func(records map[string][]dns.RR)) {
+	if d.added == nil {
+		d.added = make(map[string]pdns.RecordKind)
+	}
+	d.added[recordKey(rec.Header().Name, rec.Header().Rrtype)] = kind
+	d.change(fn)
+}
+
+// own sets the owner of the added record sets that have no owner yet
+// and forgets the owners of the removed record sets.
+// Caller must hold the write lock.
+func (z *Zones) own(owner pdns.Owner, added map[string]pdns.RecordKind) {
+	if owner.Name != "" {
+		for key, kind := range added {
+			if _, ok := z.owners[key]; ok {
+				continue
+			}
+			if _, ok := z.records[key]; ok {
+				owner.Kind = kind
+				z.owners[key] = owner
+			}
+		}
+	}
+
+	for key := range z.owners {
+		if _, ok := z.records[key]; !ok {
+			delete(z.owners, key)
+		}
+	}
+}
+
+// AddRecord adds A or AAAA record with single IP
+func (d *DNSRequest) AddRecord(domain, ip string) {
+	rec := newAddress(domain, ip, d.ttl.For(pdns.KindPod))
+	if rec == nil {
+		return
+	}
+
+	d.add(rec, pdns.KindPod, func(records map[string][]dns.RR) {
+		key := recordKey(rec.Header().Name, rec.Header().Rrtype)
+		if old, ok := records[key]; ok {
+			klog.V(2).Infof("Replacing record: %v\n", old)
+		}
+		records[key] = []dns.RR{rec}
+	})
+
+	if d.client.reverseZone != "" {
+		d.AddReverseRecord(domain, ip)
+	}
+}
+
+// RemoveRecord deletes A or AAAA record with a single IP
+func (d *DNSRequest) RemoveRecord(domain, ip string) {
+	rec := newAddress(domain, ip, d.ttl.For(pdns.KindPod))
+	if rec == nil {
+		return
+	}
+
+	d.change(func(records map[string][]dns.RR) {
+		key := recordKey(rec.Header().Name, rec.Header().Rrtype)
+
+		// If records and pods have somehow got into inconsistent state
+		// we avoid deleting records that don't match the event.
+		if !contains(records[key], rec) {
+			klog.V(2).Infof("No DNS record found for %s with the same IP (%s)", domain, ip)
+			return
+		}
+		delete(records, key)
+	})
+
+	if d.client.reverseZone != "" {
+		d.RemoveReverseRecord(domain, ip)
+	}
+}
+
+// AddReverseRecord adds a PTR record for the reverse lookup
+func (d *DNSRequest) AddReverseRecord(domain, ip string) {
+	rec := d.newPTR(domain, ip)
+	if rec == nil {
+		return
+	}
+
+	d.add(rec, pdns.KindPTR, func(records map[string][]dns.RR) {
+		records[recordKey(rec.Hdr.Name, dns.TypePTR)] = []dns.RR{rec}
+	})
+}
+
+// RemoveReverseRecord removes a PTR record from the reverse lookup zone
+func (d *DNSRequest) RemoveReverseRecord(domain, ip string) {
+	rec := d.newPTR(domain, ip)
+	if rec == nil {
+		return
+	}
+
+	d.change(func(records map[string][]dns.RR) {
+		key := recordKey(rec.Hdr.Name, dns.TypePTR)
+		if !contains(records[key], rec) {
+			klog.V(2).Infof("No PTR record found for %s with the same domain (%s)", rec.Hdr.Name, domain)
+			return
+		}
+		delete(records, key)
+	})
+}
+
+// AddToService adds the given IP to A or AAAA record with multiple IPs
+func (d *DNSRequest) AddToService(domain, ip string) {
+	rec := newAddress(domain, ip, d.ttl.For(pdns.KindService))
+	if rec == nil {
+		return
+	}
+
+	d.add(rec, pdns.KindService, func(records map[string][]dns.RR) {
+		addRR(records, rec)
+	})
+}
+
+// RemoveFromService removes given IP from an A or AAAA record with multiple IPs
+func (d *DNSRequest) RemoveFromService(domain, ip string) {
+	rec := newAddress(domain, ip, d.ttl.For(pdns.KindService))
+	if rec == nil {
+		return
+	}
+
+	d.change(func(records map[string][]dns.RR) {
+		removeRR(records, rec.Header().Name, rec.Header().Rrtype, func(rr dns.RR) bool {
+			return dns.IsDuplicate(rr, rec)
+		})
+	})
+}
+
+// AddToSRV adds target to SRV record.
+// Target with other priority, weight or port gets replaced.
+func (d *DNSRequest) AddToSRV(srv string, data pdns.SRV) {
+	rec := &dns.SRV{
+		Hdr:      header(dns.Fqdn(srv), dns.TypeSRV, uint32(d.ttl.For(pdns.KindSRV))),
+		Priority: uint16(data.Priority),
+		Weight:   uint16(data.Weight),
+		Port:     uint16(data.Port),
+		Target:   dns.Fqdn(data.Target),
+	}
+
+	d.add(rec, pdns.KindSRV, func(records map[string][]dns.RR) {
+		for _, rr := range records[recordKey(rec.Hdr.Name, dns.TypeSRV)] {
+			old := rr.(*dns.SRV)
+			if !strings.EqualFold(old.Target, rec.Target) {
+				continue
+			}
+			if old.Priority == rec.Priority && old.Weight == rec.Weight && old.Port == rec.Port {
+				klog.V(2).Infof("Record exists: %s\n", rr)
+				return
+			}
+			removeRR(records, rec.Hdr.Name, dns.TypeSRV, func(rr dns.RR) bool { return rr == old })
+			break
+		}
+		addRR(records, rec)
+	})
+}
+
+// RemoveFromSRV removes domain from SRV record
+func (d *DNSRequest) RemoveFromSRV(srv, domain string) {
+	name := dns.Fqdn(srv)
+	target := dns.Fqdn(domain)
+
+	d.change(func(records map[string][]dns.RR) {
+		removeRR(records, name, dns.TypeSRV, func(rr dns.RR) bool {
+			return strings.EqualFold(rr.(*dns.SRV).Target, target)
+		})
+	})
+}
+
+// UTILS
+func recordKey(name string, recType uint16) string {
+	return fmt.Sprintf("%s|%d", strings.ToLower(name), recType)
+}
+
+func header(name string, recType uint16, ttl uint32) dns.RR_Header {
+	return dns.RR_Header{
+		Name:   strings.ToLower(name),
+		Rrtype: recType,
+		Class:  dns.ClassINET,
+		Ttl:    ttl,
+	}
+}
+
+// newAddress returns A record for IPv4 and AAAA record for IPv6 address
+func newAddress(domain, ip string, ttl int64) dns.RR {
+	addr := net.ParseIP(ip)
+	switch {
+	case addr == nil:
+		klog.Errorf("Invalid IP address for %s: %s\n", domain, ip)
+		return nil
+	case addr.To4() != nil:
+		return &dns.A{
+			Hdr: header(dns.Fqdn(domain), dns.TypeA, uint32(ttl)),
+			A:   addr.To4(),
+		}
+	default:
+		return &dns.AAAA{
+			Hdr:  header(dns.Fqdn(domain), dns.TypeAAAA, uint32(ttl)),
+			AAAA: addr,
+		}
+	}
+}
+
+// newPTR returns the PTR record when the reverse name of the IP is in the reverse zone
+func (d *DNSRequest) newPTR(domain, ip string) *dns.PTR {
+	name, ok := pdns.ReverseName(ip)
+	if !ok {
+		klog.Errorf("Invalid IP address for %s: %s\n", domain, ip)
+		return nil
+	}
+	if !pdns.InReverseZone(ip, d.client.reverseZone) {
+		klog.V(2).Infof("No reverse zone for %s. Not writing PTR record.\n", ip)
+		return nil
+	}
+
+	return &dns.PTR{
+		Hdr: header(dns.Fqdn(name), dns.TypePTR, uint32(d.ttl.For(pdns.KindPTR))),
+		Ptr: dns.Fqdn(domain),
+	}
+}
+
+func contains(rrs []dns.RR, rec dns.RR) bool {
+	for _, rr := range rrs {
+		if dns.IsDuplicate(rr, rec) {
+			return true
+		}
+	}
+	return false
+}
+
+func addRR(records map[string][]dns.RR, rec dns.RR) {
+	key := recordKey(rec.Header().Name, rec.Header().Rrtype)
+	if contains(records[key], rec) {
+		klog.V(2).Infof("Record exists: %s\n", rec)
+		return
+	}
+	records[key] = append(records[key], rec)
+}
+
+func removeRR(records map[string][]dns.RR, name string, recType uint16, match func(dns.RR) bool) {
+	key := recordKey(name, recType)
+
+	kept := []dns.RR{}
+	for _, rr := range records[key] {
+		if !match(rr) {
+			kept = append(kept, rr)
+		}
+	}
+
+	if len(kept) == len(records[key]) {
+		klog.V(2).Infof("No matching record found in %s\n", name)
+		return
+	}
+
+	if len(kept) == 0 {
+		delete(records, key)
+		return
+	}
+	records[key] = kept
+}
@@ -0,0 +1,276 @@
+package memory
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/miekg/dns"
+	"github.com/tanelmae/private-dns/internal/pdns"
+	"github.com/tanelmae/private-dns/internal/pdns/pdnstest"
+)
+
+func newTestServer(t *testing.T) (*Zones, string) {
+	zones := New("example.com", "in-addr.arpa", "", "10.0.0.53")
+	srv := NewServer(zones, "127.0.0.1:0")
+	if err := srv.Start(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(srv.Stop)
+	return zones, srv.Addr()
+}
+
+func query(t *testing.T, addr, name string, qtype uint16) *dns.Msg {
+	t.Helper()
+	m := new(dns.Msg)
+	m.SetQuestion(name, qtype)
+
+	resp, _, err := new(dns.Client).Exchange(m, addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp
+}
+
+func answers(resp *dns.Msg) []string {
+	values := []string{}
+	for _, rr := range resp.Answer {
+		values = append(values, strings.TrimPrefix(rr.String(), rr.Header().String()))
+	}
+	sort.Strings(values)
+	return values
+}
+
+func TestProvider(t *testing.T) {
+	pdnstest.Run(t, func(t *testing.T) pdnstest.Fake {
+		zones, addr := newTestServer(t)
+		return pdnstest.Fake{
+			Provider: zones,
+			Values: func(name, recType string) []string {
+				return answers(query(t, addr, name, dns.StringToType[recType]))
+			},
+		}
+	})
+}
+
+func TestAuthoritative(t *testing.T) {
+	zones, addr := newTestServer(t)
+
+	req := zones.NewRequest()
+	req.AddRecord("pod-0.app.example.com", "10.0.0.1")
+	pdnstest.Do(t, req)
+
+	resp := query(t, addr, "pod-0.app.example.com.", dns.TypeA)
+	if !resp.Authoritative {
+		t.Fatal("expected authoritative answer")
+	}
+
+	req = zones.NewRequest()
+	req.RemoveRecord("pod-0.app.example.com", "10.0.0.1")
+	pdnstest.Do(t, req)
+
+	resp = query(t, addr, "pod-0.app.example.com.", dns.TypeA)
+	if resp.Rcode != dns.RcodeNameError {
+		t.Fatalf("expected NXDOMAIN, got %s", dns.RcodeToString[resp.Rcode])
+	}
+}
+
+func TestDualStack(t *testing.T) {
+	zones, addr := newTestServer(t)
+
+	req := zones.NewRequest()
+	for _, ip := range []string{"10.0.0.1", "fd00::1"} {
+		req.AddRecord("pod-0.app.example.com", ip)
+		req.AddToService("app.example.com", ip)
+	}
+	pdnstest.Do(t, req)
+
+	pdnstest.AssertValues(t, answers(query(t, addr, "pod-0.app.example.com.", dns.TypeA)), "10.0.0.1")
+	pdnstest.AssertValues(t, answers(query(t, addr, "pod-0.app.example.com.", dns.TypeAAAA)), "fd00::1")
+	pdnstest.AssertValues(t, answers(query(t, addr, "app.example.com.", dns.TypeAAAA)), "fd00::1")
+	pdnstest.AssertValues(t, answers(query(t, addr, "1.0.0.10.in-addr.arpa.", dns.TypePTR)), "pod-0.app.example.com.")
+
+	// IPv6 PTR is only written when there is a reverse zone for it
+	records, err := zones.ListRecords("ip6.arpa")
+	if err != nil || len(records) != 0 {
+		t.Fatalf("expected no IPv6 PTR records, got %v %v", records, err)
+	}
+
+	req = zones.NewRequest()
+	req.RemoveRecord("pod-0.app.example.com", "10.0.0.1")
+	pdnstest.Do(t, req)
+	pdnstest.AssertValues(t, answers(query(t, addr, "pod-0.app.example.com.", dns.TypeA)))
+	pdnstest.AssertValues(t, answers(query(t, addr, "pod-0.app.example.com.", dns.TypeAAAA)), "fd00::1")
+}
+
+func TestIPv6Reverse(t *testing.T) {
+	zones := New("example.com", "d.f.ip6.arpa", "", "")
+	req := zones.NewRequest()
+	req.AddRecord("pod-0.app.example.com", "fd00::1")
+	req.AddRecord("pod-1.app.example.com", "10.0.0.2")
+	pdnstest.Do(t, req)
+
+	records, err := zones.ListRecords("arpa")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 1 || records[0].Name != "1.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.d.f.ip6.arpa" {
+		t.Fatalf("expected only IPv6 PTR record, got %v", records)
+	}
+}
+
+func TestServiceAndSRV(t *testing.T) {
+	zones, addr := newTestServer(t)
+
+	for i, ip := range []string{"10.0.0.1", "10.0.0.2"} {
+		req := zones.NewRequest()
+		req.AddRecord(fmt.Sprintf("pod-%d.app.example.com", i), ip)
+		req.AddToService("app.example.com", ip)
+		req.AddToSRV("_http._tcp.example.com", pdns.SRV{Priority: 1, Target: fmt.Sprintf("pod-%d.app.example.com", i)})
+		pdnstest.Do(t, req)
+	}
+	pdnstest.AssertValues(t, answers(query(t, addr, "app.example.com.", dns.TypeA)), "10.0.0.1", "10.0.0.2")
+
+	resp := query(t, addr, "_http._tcp.example.com.", dns.TypeSRV)
+	pdnstest.AssertValues(t, answers(resp), "1 0 0 pod-0.app.example.com.", "1 0 0 pod-1.app.example.com.")
+	if len(resp.Extra) != 2 {
+		t.Fatalf("expected SRV target addresses in additional section, got %v", resp.Extra)
+	}
+
+	req := zones.NewRequest()
+	req.RemoveFromService("app.example.com", "10.0.0.1")
+	req.RemoveFromSRV("_http._tcp.example.com", "pod-0.app.example.com")
+	pdnstest.Do(t, req)
+	pdnstest.AssertValues(t, answers(query(t, addr, "app.example.com.", dns.TypeA)), "10.0.0.2")
+	pdnstest.AssertValues(t, answers(query(t, addr, "_http._tcp.example.com.", dns.TypeSRV)), "1 0 0 pod-1.app.example.com.")
+}
+
+func TestTTL(t *testing.T) {
+	zones, addr := newTestServer(t)
+
+	req := zones.NewRequest()
+	req.SetTTL(pdns.TTL{Pod: 30, Service: 300, PTR: 3600})
+	req.AddRecord("pod-0.app.example.com", "10.0.0.1")
+	req.AddToService("app.example.com", "10.0.0.1")
+	req.AddToSRV("_http._tcp.example.com", pdns.SRV{Priority: 1, Target: "pod-0.app.example.com"})
+	pdnstest.Do(t, req)
+
+	for _, q := range []struct {
+		name  string
+		qtype uint16
+		ttl   uint32
+	}{
+		{"pod-0.app.example.com.", dns.TypeA, 30},
+		{"app.example.com.", dns.TypeA, 300},
+		{"_http._tcp.example.com.", dns.TypeSRV, pdns.DefaultTTL},
+		{"1.0.0.10.in-addr.arpa.", dns.TypePTR, 3600},
+	} {
+		resp := query(t, addr, q.name, q.qtype)
+		if len(resp.Answer) != 1 || resp.Answer[0].Header().Ttl != q.ttl {
+			t.Fatalf("expected %s with TTL %d, got %v", q.name, q.ttl, resp.Answer)
+		}
+	}
+}
+
+func TestZoneApex(t *testing.T) {
+	zones, addr := newTestServer(t)
+
+	resp := query(t, addr, "example.com.", dns.TypeSOA)
+	soa, ok := resp.Answer[0].(*dns.SOA)
+	if !ok || soa.Ns != "ns.example.com." {
+		t.Fatalf("unexpected SOA answer: %v", resp.Answer)
+	}
+
+	resp = query(t, addr, "example.com.", dns.TypeNS)
+	pdnstest.AssertValues(t, answers(resp), "ns.example.com.")
+	if len(resp.Extra) != 1 {
+		t.Fatalf("expected nameserver glue record, got %v", resp.Extra)
+	}
+
+	// Serial is bumped with every change
+	req := zones.NewRequest()
+	req.AddRecord("pod-0.app.example.com", "10.0.0.1")
+	pdnstest.Do(t, req)
+	next := query(t, addr, "example.com.", dns.TypeSOA).Answer[0].(*dns.SOA)
+	if next.Serial <= soa.Serial {
+		t.Fatalf("expected serial to grow from %d, got %d", soa.Serial, next.Serial)
+	}
+}
+
+func TestNegativeAnswers(t *testing.T) {
+	zones, addr := newTestServer(t)
+
+	req := zones.NewRequest()
+	req.AddRecord("pod-0.app.example.com", "10.0.0.1")
+	pdnstest.Do(t, req)
+
+	// Empty non-terminal
+	resp := query(t, addr, "app.example.com.", dns.TypeA)
+	if resp.Rcode != dns.RcodeSuccess || len(resp.Answer) != 0 || len(resp.Ns) != 1 {
+		t.Fatalf("expected NODATA, got %s", resp)
+	}
+
+	resp = query(t, addr, "pod-0.app.example.com.", dns.TypeSRV)
+	if resp.Rcode != dns.RcodeSuccess || len(resp.Answer) != 0 {
+		t.Fatalf("expected NODATA, got %s", resp)
+	}
+
+	resp = query(t, addr, "missing.example.com.", dns.TypeA)
+	if resp.Rcode != dns.RcodeNameError {
+		t.Fatalf("expected NXDOMAIN, got %s", resp)
+	}
+
+	resp = query(t, addr, "example.org.", dns.TypeA)
+	if resp.Rcode != dns.RcodeRefused {
+		t.Fatalf("expected REFUSED, got %s", resp)
+	}
+}
+
+func TestOwnedRecords(t *testing.T) {
+	zones := New("example.com", "in-addr.arpa", "", "")
+
+	req := zones.NewRequest()
+	req.(pdns.OwnedRequest).SetResource("default", "app")
+	req.AddRecord("pod-0.app.example.com", "10.0.0.1")
+	req.AddToService("app.example.com", "10.0.0.1")
+	req.AddToSRV("_http._tcp.example.com", pdns.SRV{Port: 80, Target: "pod-0.app.example.com"})
+	pdnstest.Do(t, req)
+
+	// Shared record keeps the first owner and records without a resource have none
+	req = zones.NewRequest()
+	req.(pdns.OwnedRequest).SetResource("default", "other")
+	req.AddToSRV("_http._tcp.example.com", pdns.SRV{Port: 80, Target: "pod-0.other.example.com"})
+	pdnstest.Do(t, req)
+	req = zones.NewRequest()
+	req.AddRecord("manual.example.com", "10.0.0.9")
+	pdnstest.Do(t, req)
+
+	owned := func() []string {
+		records, err := zones.OwnedRecords()
+		if err != nil {
+			t.Fatal(err)
+		}
+		values := []string{}
+		for _, r := range records {
+			values = append(values, fmt.Sprintf("%s %s %s/%s %s", r.Name, r.Type, r.Owner.Namespace, r.Owner.Name, r.Owner.Kind))
+		}
+		sort.Strings(values)
+		return values
+	}
+	pdnstest.AssertValues(t, owned(),
+		"1.0.0.10.in-addr.arpa PTR default/app ptr",
+		"_http._tcp.example.com SRV default/app srv",
+		"app.example.com A default/app service",
+		"pod-0.app.example.com A default/app pod",
+	)
+
+	// Ownership is forgotten with the record
+	req = zones.NewRequest()
+	req.RemoveRecord("pod-0.app.example.com", "10.0.0.1")
+	pdnstest.Do(t, req)
+	pdnstest.AssertValues(t, owned(),
+		"_http._tcp.example.com SRV default/app srv",
+		"app.example.com A default/app service",
+	)
+}
@@ -0,0 +1,142 @@
+package memory
+
+import (
+	"net"
+	"strings"
+
+	"github.com/miekg/dns"
+	"k8s.io/klog/v2"
+)
+
+// Server is an authoritative DNS server answering from the in-memory zones
+type Server struct {
+	zones *Zones
+	addr  string
+	udp   *dns.Server
+	tcp   *dns.Server
+}
+
+// NewServer creates UDP and TCP DNS server for the zones
+func NewServer(zones *Zones, addr string) *Server {
+	return &Server{
+		zones: zones,
+		addr:  addr,
+	}
+}
+
+// Start binds the listeners and serves the queries in the background
+func (s *Server) Start() error {
+	pc, err := net.ListenPacket("udp", s.addr)
+	if err != nil {
+		return err
+	}
+
+	// Use the same port for TCP when random port was requested
+	l, err := net.Listen("tcp", pc.LocalAddr().String())
+	if err != nil {
+		pc.Close()
+		return err
+	}
+
+	s.udp = &dns.Server{PacketConn: pc, Handler: s}
+	s.tcp = &dns.Server{Listener: l, Handler: s}
+
+	for _, srv := range []*dns.Server{s.udp, s.tcp} {
+		go func(srv *dns.Server) {
+			if err := srv.ActivateAndServe(); err != nil {
+				klog.Errorln(err)
+			}
+		}(srv)
+	}
+
+	klog.Infof("DNS server listening on %s\n", pc.LocalAddr())
+	return nil
+}
+
+// Addr returns the address the server is listening on
+func (s *Server) Addr() string {
+	return s.udp.PacketConn.LocalAddr().String()
+}
+
+// Stop shuts down the listeners
+func (s *Server) Stop() {
+	for _, srv := range []*dns.Server{s.udp, s.tcp} {
+		if srv != nil {
+			srv.Shutdown()
+		}
+	}
+}
+
+// ServeDNS answers the queries for the zones
+func (s *Server) ServeDNS(w dns.ResponseWriter, r *dns.Msg) {
+	m := new(dns.Msg)
+	m.SetReply(r)
+
+	if len(r.Question) != 1 || r.Opcode != dns.OpcodeQuery {
+		m.Rcode = dns.RcodeNotImplemented
+		w.WriteMsg(m)
+		return
+	}
+
+	q := r.Question[0]
+	name := strings.ToLower(q.Name)
+
+	zone, ok := s.zones.zoneFor(name)
+	if !ok {
+		m.Rcode = dns.RcodeRefused
+		w.WriteMsg(m)
+		return
+	}
+	m.Authoritative = true
+
+	s.zones.mu.RLock()
+	answer, exists := s.zones.lookup(zone, name, q.Qtype)
+	switch {
+	case len(answer) > 0:
+		m.Answer = answer
+		m.Extra = s.glue(zone, answer)
+	case !exists:
+		m.Rcode = dns.RcodeNameError
+		m.Ns = []dns.RR{s.zones.soa(zone)}
+	default:
+		// NODATA
+		m.Ns = []dns.RR{s.zones.soa(zone)}
+	}
+	s.zones.mu.RUnlock()
+
+	// Let client retry over TCP when the answer doesn't fit
+	if _, isUDP := w.RemoteAddr().(*net.UDPAddr); isUDP {
+		size := dns.MinMsgSize
+		if opt := r.IsEdns0(); opt != nil {
+			size = int(opt.UDPSize())
+		}
+		m.Truncate(size)
+	}
+
+	if err := w.WriteMsg(m); err != nil {
+		klog.Errorln(err)
+	}
+}
+
+// glue returns A and AAAA records for SRV targets and nameservers in the answer.
+// Caller must hold the read lock.
+func (s *Server) glue(zone string, answer []dns.RR) []dns.RR {
+	extra := []dns.RR{}
+	for _, rr := range answer {
+		target := ""
+		switch v := rr.(type) {
+		case *dns.SRV:
+			target = v.Target
+		case *dns.NS:
+			target = v.Ns
+		default:
+			continue
+		}
+		for _, qtype := range []uint16{dns.TypeA, dns.TypeAAAA} {
+			if records, _ := s.zones.lookup(zone, strings.ToLower(target), qtype); len(records) > 0 {
+				extra = append(extra, records...)
+			}
+		}
+	}
+	return extra
+}
@@ -0,0 +1,43 @@
+package powerdns
+
+import (
+	"errors"
+	"flag"
+	"os"
+
+	"github.com/tanelmae/private-dns/internal/pdns"
+)
+
+func init() {
+	pdns.Register(providerName, func() pdns.ProviderConfig { return &Options{Server: defaultServer} })
+}
+
+// Options holds PowerDNS provider configuration
+type Options struct {
+	URL         string `json:"url"`
+	APIKey      string `json:"api-key"`
+	Server      string `json:"server"`
+	Zone        string `json:"zone"`
+	ReverseZone string `json:"reverse-zone"`
+}
+
+// RegisterFlags adds PowerDNS flags to the flag set
+func (o *Options) RegisterFlags(fs *flag.FlagSet) {
+	fs.StringVar(&o.URL, "powerdns-url", "", "PowerDNS API URL, e.g. http://powerdns:8081")
+	fs.StringVar(&o.APIKey, "powerdns-api-key", "", "PowerDNS API key. Defaults to POWERDNS_API_KEY environment variable.")
+	fs.StringVar(&o.Server, "powerdns-server", o.Server, "PowerDNS server ID")
+	fs.StringVar(&o.Zone, "powerdns-zone", "", "PowerDNS zone where to write the records")
+	fs.StringVar(&o.ReverseZone, "powerdns-reverse-zone", "", "PowerDNS zone where to write the reverse lookup records")
+}
+
+// New creates PowerDNS API client from the options
+func (o *Options) New() (pdns.DNSProvider, error) {
+	if o.URL == "" || o.Zone == "" {
+		return nil, errors.New("-powerdns-url and -powerdns-zone are required")
+	}
+
+	if o.APIKey == "" {
+		o.APIKey = os.Getenv("POWERDNS_API_KEY")
+	}
+	return New(o.URL, o.APIKey, o.Server, o.Zone, o.ReverseZone), nil
+}
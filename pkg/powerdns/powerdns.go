@@ -0,0 +1,579 @@
+package powerdns
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/tanelmae/private-dns/internal/metrics"
+	"github.com/tanelmae/private-dns/internal/pdns"
+	"k8s.io/klog/v2"
+)
+
+const (
+	providerName = "powerdns"
+
+	typeSRV = "SRV"
+	typePTR = "PTR"
+
+	defaultServer = "localhost"
+
+	changeReplace = "REPLACE"
+	changeDelete  = "DELETE"
+)
+
+type record struct {
+	Content  string `json:"content"`
+	Disabled bool   `json:"disabled"`
+}
+
+type rrset struct {
+	Name       string   `json:"name"`
+	Type       string   `json:"type"`
+	TTL        int      `json:"ttl,omitempty"`
+	ChangeType string   `json:"changetype,omitempty"`
+	Records    []record `json:"records"`
+}
+
+type zone struct {
+	RRsets []rrset `json:"rrsets"`
+}
+
+type apiError struct {
+	Error string `json:"error"`
+}
+
+// PowerDNS is a client for PowerDNS Authoritative HTTP API
+type PowerDNS struct {
+	client      *http.Client
+	apiURL      string
+	apiKey      string
+	server      string
+	zone        string
+	reverseZone string
+}
+
+// New creates PowerDNS API client instance.
+// Server defaults to localhost which is the only server PowerDNS API supports.
+func New(apiURL, apiKey, server, zone, reverseZone string) *PowerDNS {
+	if server == "" {
+		server = defaultServer
+	}
+
+	c := &PowerDNS{
+		client: &http.Client{Timeout: 30 * time.Second},
+		apiURL: strings.TrimSuffix(apiURL, "/"),
+		apiKey: apiKey,
+		server: server,
+		zone:   canonical(zone),
+	}
+
+	if reverseZone != "" {
+		c.reverseZone = canonical(reverseZone)
+	}
+	return c
+}
+
+// String describes the client without the API key
+func (c *PowerDNS) String() string {
+	return fmt.Sprintf("%s API %s server %s zone %s reverse zone %q", providerName, c.apiURL, c.server, c.zone, c.reverseZone)
+}
+
+func (c *PowerDNS) zoneURL(zone string) string {
+	return fmt.Sprintf("%s/api/v1/servers/%s/zones/%s", c.apiURL, url.PathEscape(c.server), url.PathEscape(zone))
+}
+
+func (c *PowerDNS) do(method, reqURL string, body interface{}, out interface{}) error {
+	var payload []byte
+	if body != nil {
+		var err error
+		payload, err = json.Marshal(body)
+		if err != nil {
+			return err
+		}
+	}
+
+	req, err := http.NewRequest(method, reqURL, bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("X-API-Key", c.apiKey)
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	respBody, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		apiErr := apiError{}
+		if json.Unmarshal(respBody, &apiErr) == nil && apiErr.Error != "" {
+			return fmt.Errorf("PowerDNS API returned %d: %s", resp.StatusCode, apiErr.Error)
+		}
+		return fmt.Errorf("PowerDNS API returned %d", resp.StatusCode)
+	}
+
+	if out != nil {
+		return json.Unmarshal(respBody, out)
+	}
+	return nil
+}
+
+func (c *PowerDNS) applyChange(zone string, changes []rrset) error {
+	start := time.Now()
+	err := c.do(http.MethodPatch, c.zoneURL(zone), struct {
+		RRsets []rrset `json:"rrsets"`
+	}{changes}, nil)
+	metrics.ObserveCall(providerName, "change", zone, start, err)
+	return err
+}
+
+// checkForRec returns the values of the RRset. Nil is returned when it doesn't exist.
+// Older PowerDNS versions ignore the filter and return the whole zone.
+func (c *PowerDNS) checkForRec(zoneName, name, recType string) ([]string, error) {
+	query := url.Values{}
+	query.Set("rrset_name", name)
+	query.Set("rrset_type", recType)
+
+	z := zone{}
+	start := time.Now()
+	err := c.do(http.MethodGet, c.zoneURL(zoneName)+"?"+query.Encode(), nil, &z)
+	metrics.ObserveCall(providerName, "get", zoneName, start, err)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, set := range z.RRsets {
+		if strings.EqualFold(set.Name, name) && set.Type == recType {
+			return contents(set), nil
+		}
+	}
+	return nil, nil
+}
+
+// CheckHealth gets the SOA record of the forward zone to verify the API key
+func (c *PowerDNS) CheckHealth() error {
+	query := url.Values{}
+	query.Set("rrset_name", c.zone)
+	query.Set("rrset_type", "SOA")
+
+	start := time.Now()
+	err := c.do(http.MethodGet, c.zoneURL(c.zone)+"?"+query.Encode(), nil, &zone{})
+	metrics.ObserveCall(providerName, "get-zone", c.zone, start, err)
+	return err
+}
+
+// ListRecords returns the RRsets in the zone under the given domain
+func (c *PowerDNS) ListRecords(domain string) ([]pdns.Record, error) {
+	z := zone{}
+	start := time.Now()
+	err := c.do(http.MethodGet, c.zoneURL(c.zone), nil, &z)
+	metrics.ObserveCall(providerName, "list", c.zone, start, err)
+	if err != nil {
+		return nil, err
+	}
+
+	records := []pdns.Record{}
+	for _, set := range z.RRsets {
+		if pdns.InDomain(set.Name, domain) {
+			records = append(records, pdns.Record{
+				Name:   strings.TrimSuffix(set.Name, "."),
+				Type:   set.Type,
+				Values: contents(set),
+			})
+		}
+	}
+	return records, nil
+}
+
+// NewRequest creates a new request for RRset changes
+func (c *PowerDNS) NewRequest() pdns.DNSRequest {
+	return &DNSRequest{
+		client:    c,
+		change:    newChangeSet(),
+		revChange: newChangeSet(),
+	}
+}
+
+// changeSet keeps the last change for every RRset in the order they were added
+type changeSet struct {
+	keys    []string
+	changes map[string]rrset
+}
+
+func newChangeSet() *changeSet {
+	return &changeSet{changes: make(map[string]rrset)}
+}
+
+func (s *changeSet) add(set rrset) {
+	key := fmt.Sprintf("%s|%s", strings.ToLower(set.Name), set.Type)
+	if _, exists := s.changes[key]; !exists {
+		s.keys = append(s.keys, key)
+	}
+	s.changes[key] = set
+}
+
+// get returns the values of the RRset after the change.
+// Nil values are returned when the RRset is deleted.
+func (s *changeSet) get(name, recType string) ([]string, bool) {
+	set, exists := s.changes[fmt.Sprintf("%s|%s", strings.ToLower(name), recType)]
+	if !exists || set.ChangeType == changeDelete {
+		return nil, exists
+	}
+	return contents(set), true
+}
+
+func (s *changeSet) describe(zone string) []string {
+	changes := []string{}
+	for _, set := range s.list() {
+		if set.ChangeType == changeDelete {
+			changes = append(changes, fmt.Sprintf("delete %s %s in %s", set.Name, set.Type, zone))
+			continue
+		}
+		changes = append(changes, fmt.Sprintf("replace %s %s %d %v in %s", set.Name, set.Type, set.TTL, contents(set), zone))
+	}
+	return changes
+}
+
+func (s *changeSet) list() []rrset {
+	list := []rrset{}
+	for _, key := range s.keys {
+		list = append(list, s.changes[key])
+	}
+	return list
+}
+
+// DNSRequest holds the RRset changes for the forward and reverse zones.
+// Changes for a zone are sent with a single PATCH request.
+// RRsets are replaced as a whole so a failed lookup of the existing
+// RRset fails the whole request instead of dropping its other values.
+type DNSRequest struct {
+	client    *PowerDNS
+	ttl       pdns.TTL
+	change    *changeSet
+	revChange *changeSet
+	err       error
+}
+
+// SetTTL sets the TTL of the records added in the request
+func (d *DNSRequest) SetTTL(ttl pdns.TTL) {
+	d.ttl = ttl
+}
+
+// Do makes the requests with all the attached changes
+// No error would be returned when no changes have been added
+func (d *DNSRequest) Do() (err error) {
+	defer metrics.ObserveRequest(providerName, time.Now(), &err)
+
+	if d.err != nil {
+		return d.err
+	}
+
+	if len(d.change.keys) > 0 {
+		if err = d.client.applyChange(d.client.zone, d.change.list()); err != nil {
+			return err
+		}
+	}
+
+	if len(d.revChange.keys) > 0 {
+		if err = d.client.applyChange(d.client.reverseZone, d.revChange.list()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Changes returns the RRset replacements and deletions of the forward and reverse zones
+func (d *DNSRequest) Changes() []string {
+	return append(d.change.describe(d.client.zone), d.revChange.describe(d.client.reverseZone)...)
+}
+
+// checkForRec returns the values of the RRset as they will be after the changes already in the request.
+// False is returned when the RRset can't be read. Request fails then without applying any changes.
+func (d *DNSRequest) checkForRec(changes *changeSet, zoneName, name, recType string) ([]string, bool) {
+	if values, found := changes.get(name, recType); found {
+		return values, true
+	}
+	if d.err != nil {
+		return nil, false
+	}
+
+	values, err := d.client.checkForRec(zoneName, name, recType)
+	if err != nil {
+		klog.Errorf("Failed to get %s %s record: %s\n", name, recType, err)
+		d.err = err
+		return nil, false
+	}
+	return values, true
+}
+
+func replace(name, recType string, ttl int64, values []string) rrset {
+	set := rrset{
+		Name:       name,
+		Type:       recType,
+		TTL:        int(ttl),
+		ChangeType: changeReplace,
+		Records:    []record{},
+	}
+	for _, v := range values {
+		set.Records = append(set.Records, record{Content: v})
+	}
+	return set
+}
+
+func remove(name, recType string) rrset {
+	return rrset{
+		Name:       name,
+		Type:       recType,
+		ChangeType: changeDelete,
+		Records:    []record{},
+	}
+}
+
+// AddRecord adds A or AAAA record with single IP
+func (d *DNSRequest) AddRecord(domain, ip string) {
+	name := canonical(domain)
+	recType := pdns.AddressType(ip)
+
+	oldRec, ok := d.checkForRec(d.change, d.client.zone, name, recType)
+	if !ok {
+		return
+	}
+
+	if len(oldRec) == 1 && oldRec[0] == ip {
+		klog.V(2).Infof("Record exists: %s/%s\n", name, ip)
+		return
+	}
+
+	// Stale record gets replaced
+	if oldRec != nil {
+		klog.V(2).Infof("Stale record found: %s/%v\n", name, oldRec)
+	}
+	d.change.add(replace(name, recType, d.ttl.For(pdns.KindPod), []string{ip}))
+
+	if d.client.reverseZone != "" {
+		d.AddReverseRecord(domain, ip)
+	}
+}
+
+// RemoveRecord deletes A or AAAA record with a single IP
+func (d *DNSRequest) RemoveRecord(domain, ip string) {
+	name := canonical(domain)
+	recType := pdns.AddressType(ip)
+
+	oldRec, ok := d.checkForRec(d.change, d.client.zone, name, recType)
+	if !ok {
+		return
+	}
+	if oldRec == nil {
+		klog.V(2).Infof("No DNS record found for %s/%s", name, ip)
+		return
+	}
+
+	// If records and pods have somehow got into inconsistent state
+	// we avoid deleting records that don't match the event.
+	if !contains(oldRec, ip) {
+		klog.V(2).Infof("No DNS record found for %s with the same IP (%s)", name, ip)
+		return
+	}
+	d.change.add(remove(name, recType))
+
+	if d.client.reverseZone != "" {
+		d.RemoveReverseRecord(domain, ip)
+	}
+}
+
+// AddReverseRecord adds a PTR record for the reverse lookup
+func (d *DNSRequest) AddReverseRecord(domain, ip string) {
+	name, ok := d.reverseName(domain, ip)
+	if !ok {
+		return
+	}
+	target := canonical(domain)
+
+	oldRec, ok := d.checkForRec(d.revChange, d.client.reverseZone, name, typePTR)
+	if !ok {
+		return
+	}
+
+	if len(oldRec) == 1 && oldRec[0] == target {
+		klog.V(2).Infof("Record exists: %s/%s\n", name, target)
+		return
+	}
+
+	d.revChange.add(replace(name, typePTR, d.ttl.For(pdns.KindPTR), []string{target}))
+}
+
+// RemoveReverseRecord removes a PTR record from the reverse lookup zone
+func (d *DNSRequest) RemoveReverseRecord(domain, ip string) {
+	name, ok := d.reverseName(domain, ip)
+	if !ok {
+		return
+	}
+
+	oldRec, ok := d.checkForRec(d.revChange, d.client.reverseZone, name, typePTR)
+	if !ok {
+		return
+	}
+	if !contains(oldRec, canonical(domain)) {
+		klog.V(2).Infof("No PTR record found for %s with the same domain (%s)", name, domain)
+		return
+	}
+	d.revChange.add(remove(name, typePTR))
+}
+
+// reverseName returns the canonical PTR record name of the IP.
+// False is returned when the name is not in the reverse zone.
+func (d *DNSRequest) reverseName(domain, ip string) (string, bool) {
+	name, ok := pdns.ReverseName(ip)
+	if !ok {
+		klog.Errorf("Invalid IP address for %s: %s\n", domain, ip)
+		return "", false
+	}
+	if !pdns.InReverseZone(ip, d.client.reverseZone) {
+		klog.V(2).Infof("%s is not in %s reverse zone. Not writing PTR record.\n", name, d.client.reverseZone)
+		return "", false
+	}
+	return canonical(name), true
+}
+
+// AddToService adds the given IP to A or AAAA record with multiple IPs
+func (d *DNSRequest) AddToService(domain, ip string) {
+	name := canonical(domain)
+	recType := pdns.AddressType(ip)
+
+	oldRec, ok := d.checkForRec(d.change, d.client.zone, name, recType)
+	if !ok {
+		return
+	}
+
+	if contains(oldRec, ip) {
+		klog.V(2).Infof("Service %s record exists and contains %s\n", domain, ip)
+		return
+	}
+
+	d.change.add(replace(name, recType, d.ttl.For(pdns.KindService), append(oldRec, ip)))
+}
+
+// RemoveFromService removes given IP from an A or AAAA record with multiple IPs
+func (d *DNSRequest) RemoveFromService(domain, ip string) {
+	name := canonical(domain)
+	recType := pdns.AddressType(ip)
+
+	oldRec, ok := d.checkForRec(d.change, d.client.zone, name, recType)
+	if !ok {
+		return
+	}
+	if !contains(oldRec, ip) {
+		klog.V(2).Infof("%s service doesn't include %s\n", domain, ip)
+		return
+	}
+
+	values := without(oldRec, ip)
+	if len(values) == 0 {
+		d.change.add(remove(name, recType))
+		return
+	}
+	d.change.add(replace(name, recType, d.ttl.For(pdns.KindService), values))
+}
+
+// AddToSRV adds target to SRV record.
+// Target with other priority, weight or port gets replaced.
+func (d *DNSRequest) AddToSRV(srv string, data pdns.SRV) {
+	name := canonical(srv)
+
+	oldRec, ok := d.checkForRec(d.change, d.client.zone, name, typeSRV)
+	if !ok {
+		return
+	}
+
+	value := fmt.Sprintf("%d %d %d %s", data.Priority, data.Weight, data.Port, canonical(data.Target))
+	if old, found := srvTarget(oldRec, data.Target); found {
+		if old == value {
+			klog.V(2).Infof("Record exists: %s/%v\n", name, oldRec)
+			return
+		}
+		oldRec = without(oldRec, old)
+	}
+
+	d.change.add(replace(name, typeSRV, d.ttl.For(pdns.KindSRV), append(oldRec, value)))
+}
+
+// RemoveFromSRV removes domain from SRV record
+func (d *DNSRequest) RemoveFromSRV(srv, domain string) {
+	name := canonical(srv)
+
+	oldRec, ok := d.checkForRec(d.change, d.client.zone, name, typeSRV)
+	if !ok {
+		return
+	}
+
+	value, found := srvTarget(oldRec, domain)
+	if !found {
+		klog.V(2).Infof("%s doesn't include %s\n", srv, domain)
+		return
+	}
+
+	values := without(oldRec, value)
+	if len(values) == 0 {
+		d.change.add(remove(name, typeSRV))
+		return
+	}
+	d.change.add(replace(name, typeSRV, d.ttl.For(pdns.KindSRV), values))
+}
+
+// UTILS
+
+// canonical returns the name with trailing dot as PowerDNS expects
+func canonical(name string) string {
+	return strings.TrimSuffix(name, ".") + "."
+}
+
+// srvTarget returns the SRV record content pointing to the given domain
+func srvTarget(values []string, domain string) (string, bool) {
+	for _, v := range values {
+		fields := strings.Fields(v)
+		if len(fields) == 4 && strings.EqualFold(fields[3], canonical(domain)) {
+			return v, true
+		}
+	}
+	return "", false
+}
+
+func contents(set rrset) []string {
+	values := []string{}
+	for _, r := range set.Records {
+		values = append(values, r.Content)
+	}
+	return values
+}
+
+func contains(values []string, data string) bool {
+	for _, v := range values {
+		if v == data {
+			return true
+		}
+	}
+	return false
+}
+
+func without(values []string, data string) []string {
+	newValues := []string{}
+	for _, v := range values {
+		if v != data {
+			newValues = append(newValues, v)
+		}
+	}
+	return newValues
+}
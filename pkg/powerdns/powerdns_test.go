@@ -0,0 +1,205 @@
+package powerdns
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/tanelmae/private-dns/internal/pdns"
+	"github.com/tanelmae/private-dns/internal/pdns/pdnstest"
+)
+
+const (
+	testKey     = "secret"
+	testZone    = "example.com."
+	testRevZone = "in-addr.arpa."
+)
+
+// fakePowerDNS is a minimal local stand-in for the PowerDNS zones API
+type fakePowerDNS struct {
+	mu       sync.Mutex
+	zones    map[string]map[string]rrset
+	patches  int
+	failGets bool
+}
+
+func (f *fakePowerDNS) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if r.Header.Get("X-API-Key") != testKey {
+		w.WriteHeader(http.StatusUnauthorized)
+		json.NewEncoder(w).Encode(apiError{Error: "Unauthorized"})
+		return
+	}
+
+	zoneName := strings.TrimPrefix(r.URL.Path, "/api/v1/servers/localhost/zones/")
+	records, ok := f.zones[zoneName]
+	if !ok {
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(apiError{Error: "Could not find domain"})
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		if f.failGets {
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(apiError{Error: "Internal Server Error"})
+			return
+		}
+		// Behaves like an older server ignoring the RRset filter
+		z := zone{RRsets: []rrset{}}
+		for _, set := range records {
+			z.RRsets = append(z.RRsets, set)
+		}
+		json.NewEncoder(w).Encode(z)
+	case http.MethodPatch:
+		z := zone{}
+		if err := json.NewDecoder(r.Body).Decode(&z); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		f.patches++
+		for _, set := range z.RRsets {
+			key := fmt.Sprintf("%s|%s", set.Name, set.Type)
+			switch set.ChangeType {
+			case changeReplace:
+				set.ChangeType = ""
+				records[key] = set
+			case changeDelete:
+				delete(records, key)
+			}
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+func (f *fakePowerDNS) values(zone, name, recType string) []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	values := []string{}
+	for _, r := range f.zones[zone][fmt.Sprintf("%s|%s", name, recType)].Records {
+		values = append(values, r.Content)
+	}
+	sort.Strings(values)
+	return values
+}
+
+func newTestClient(t *testing.T, key string) (*PowerDNS, *fakePowerDNS) {
+	fake := &fakePowerDNS{zones: map[string]map[string]rrset{
+		testZone:    {},
+		testRevZone: {},
+	}}
+	srv := httptest.NewServer(fake)
+	t.Cleanup(srv.Close)
+
+	return New(srv.URL, key, "", "example.com", "in-addr.arpa"), fake
+}
+
+func TestProvider(t *testing.T) {
+	pdnstest.Run(t, func(t *testing.T) pdnstest.Fake {
+		client, fake := newTestClient(t, testKey)
+		return pdnstest.Fake{
+			Provider: client,
+			Values: func(name, recType string) []string {
+				if strings.HasSuffix(name, testRevZone) {
+					return fake.values(testRevZone, name, recType)
+				}
+				return fake.values(testZone, name, recType)
+			},
+			Writes: func() int { return fake.patches },
+		}
+	})
+}
+
+func TestAAAARecord(t *testing.T) {
+	client, fake := newTestClient(t, testKey)
+
+	req := client.NewRequest()
+	req.AddRecord("pod-0.app.example.com", "10.0.0.1")
+	req.AddRecord("pod-0.app.example.com", "fd00::1")
+	req.AddToService("app.example.com", "fd00::1")
+	pdnstest.Do(t, req)
+	pdnstest.AssertValues(t, fake.values(testZone, "pod-0.app.example.com.", pdns.TypeA), "10.0.0.1")
+	pdnstest.AssertValues(t, fake.values(testZone, "pod-0.app.example.com.", pdns.TypeAAAA), "fd00::1")
+	pdnstest.AssertValues(t, fake.values(testZone, "app.example.com.", pdns.TypeAAAA), "fd00::1")
+
+	req = client.NewRequest()
+	req.RemoveRecord("pod-0.app.example.com", "fd00::1")
+	pdnstest.Do(t, req)
+	pdnstest.AssertValues(t, fake.values(testZone, "pod-0.app.example.com.", pdns.TypeAAAA))
+	pdnstest.AssertValues(t, fake.values(testZone, "pod-0.app.example.com.", pdns.TypeA), "10.0.0.1")
+}
+
+func TestSinglePatch(t *testing.T) {
+	client, fake := newTestClient(t, testKey)
+
+	req := client.NewRequest()
+	req.AddRecord("pod-0.app.example.com", "10.0.0.1")
+	req.AddToService("app.example.com", "10.0.0.1")
+	req.AddToSRV("_http._tcp.example.com", pdns.SRV{Priority: 1, Target: "pod-0.app.example.com"})
+	pdnstest.Do(t, req)
+
+	// One PATCH for the forward and one for the reverse zone
+	if fake.patches != 2 {
+		t.Fatalf("expected 2 PATCH requests, got %d", fake.patches)
+	}
+}
+
+func TestAPIError(t *testing.T) {
+	client, _ := newTestClient(t, "wrong")
+
+	req := client.NewRequest()
+	req.AddRecord("pod-0.app.example.com", "10.0.0.1")
+	if err := req.Do(); err == nil || !strings.Contains(err.Error(), "Unauthorized") {
+		t.Fatalf("expected API error, got %v", err)
+	}
+}
+
+func TestLookupErrorAbortsRequest(t *testing.T) {
+	client, fake := newTestClient(t, testKey)
+
+	req := client.NewRequest()
+	req.AddToService("app.example.com", "10.0.0.1")
+	req.AddToSRV("_http._tcp.example.com", pdns.SRV{Priority: 1, Target: "app.example.com"})
+	pdnstest.Do(t, req)
+
+	// Failed lookup must not replace the RRsets with only the new values
+	fake.mu.Lock()
+	fake.failGets = true
+	patches := fake.patches
+	fake.mu.Unlock()
+
+	req = client.NewRequest()
+	req.AddToService("app.example.com", "10.0.0.2")
+	req.AddToSRV("_http._tcp.example.com", pdns.SRV{Priority: 1, Target: "other.example.com"})
+	if err := req.Do(); err == nil || !strings.Contains(err.Error(), "Internal Server Error") {
+		t.Fatalf("expected lookup error, got %v", err)
+	}
+	if fake.patches != patches {
+		t.Fatalf("expected no changes to be sent, got %d", fake.patches-patches)
+	}
+	pdnstest.AssertValues(t, fake.values(testZone, "app.example.com.", pdns.TypeA), "10.0.0.1")
+	pdnstest.AssertValues(t, fake.values(testZone, "_http._tcp.example.com.", typeSRV), "1 0 0 app.example.com.")
+}
+
+func TestCheckHealthWrongKey(t *testing.T) {
+	client, _ := newTestClient(t, "wrong")
+	if err := client.CheckHealth(); err == nil || !strings.Contains(err.Error(), "Unauthorized") {
+		t.Fatalf("expected wrong API key to fail the health check, got %v", err)
+	}
+}
+
+func TestStringHidesAPIKey(t *testing.T) {
+	client := New("http://pdns:8081", "api-key-value", "", "example.com", "")
+	if s := fmt.Sprint(client); strings.Contains(s, "api-key-value") || !strings.Contains(s, "example.com.") {
+		t.Fatalf("unexpected description %s", s)
+	}
+}
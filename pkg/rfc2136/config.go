@@ -0,0 +1,62 @@
+package rfc2136
+
+import (
+	"errors"
+	"flag"
+	"os"
+
+	"github.com/tanelmae/private-dns/internal/pdns"
+)
+
+func init() {
+	pdns.Register(providerName, func() pdns.ProviderConfig {
+		return &Options{Network: "udp", TSIGAlgorithm: "hmac-sha256"}
+	})
+}
+
+// Options holds dynamic update provider configuration
+type Options struct {
+	Server        string `json:"server"`
+	Network       string `json:"net"`
+	Zone          string `json:"zone"`
+	ReverseZone   string `json:"reverse-zone"`
+	TSIGKey       string `json:"tsig-key"`
+	TSIGSecret    string `json:"tsig-secret"`
+	TSIGAlgorithm string `json:"tsig-algorithm"`
+}
+
+// RegisterFlags adds dynamic update flags to the flag set
+func (o *Options) RegisterFlags(fs *flag.FlagSet) {
+	fs.StringVar(&o.Server, "rfc2136-server", "", "Nameserver accepting RFC 2136 dynamic updates (host:port)")
+	fs.StringVar(&o.Network, "rfc2136-net", o.Network, "Protocol used for dynamic updates: udp or tcp")
+	fs.StringVar(&o.Zone, "rfc2136-zone", "", "DNS zone where to write the records")
+	fs.StringVar(&o.ReverseZone, "rfc2136-reverse-zone", "", "DNS zone where to write the reverse lookup records")
+	fs.StringVar(&o.TSIGKey, "rfc2136-tsig-key", "", "TSIG key name used to sign the updates")
+	fs.StringVar(&o.TSIGSecret, "rfc2136-tsig-secret", "", "Base64 encoded TSIG secret. Defaults to TSIG_SECRET environment variable.")
+	fs.StringVar(&o.TSIGAlgorithm, "rfc2136-tsig-algorithm", o.TSIGAlgorithm, "TSIG algorithm")
+}
+
+// New creates dynamic update client from the options
+func (o *Options) New() (pdns.DNSProvider, error) {
+	if o.Server == "" || o.Zone == "" {
+		return nil, errors.New("-rfc2136-server and -rfc2136-zone are required")
+	}
+
+	if o.Network != "udp" && o.Network != "tcp" {
+		return nil, errors.New("-rfc2136-net must be udp or tcp")
+	}
+
+	if o.TSIGSecret == "" {
+		o.TSIGSecret = os.Getenv("TSIG_SECRET")
+	}
+
+	if o.TSIGKey != "" && o.TSIGSecret == "" {
+		return nil, errors.New("TSIG secret is required with -rfc2136-tsig-key")
+	}
+
+	return New(o.Server, o.Network, o.Zone, o.ReverseZone, TSIG{
+		KeyName:   o.TSIGKey,
+		Secret:    o.TSIGSecret,
+		Algorithm: o.TSIGAlgorithm,
+	}), nil
+}
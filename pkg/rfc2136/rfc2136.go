@@ -0,0 +1,508 @@
+package rfc2136
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/tanelmae/private-dns/internal/metrics"
+	"github.com/tanelmae/private-dns/internal/pdns"
+	"k8s.io/klog/v2"
+)
+
+const (
+	providerName = "rfc2136"
+
+	// Allowed time difference between the signer and the server
+	tsigFudge = 300
+)
+
+// TSIG holds the key used to sign the update messages.
+// Empty key name disables signing.
+type TSIG struct {
+	KeyName   string
+	Secret    string
+	Algorithm string
+}
+
+// DynamicDNS sends RFC 2136 dynamic updates to an authoritative nameserver
+type DynamicDNS struct {
+	client      *dns.Client
+	server      string
+	zone        string
+	reverseZone string
+	tsig        TSIG
+}
+
+// New creates dynamic DNS update client for the given nameserver.
+// Network is either "udp" or "tcp".
+func New(server, network, zone, reverseZone string, tsig TSIG) *DynamicDNS {
+	if _, _, err := net.SplitHostPort(server); err != nil {
+		server = net.JoinHostPort(server, "53")
+	}
+
+	client := &dns.Client{
+		Net:     network,
+		Timeout: 10 * time.Second,
+	}
+
+	if tsig.KeyName != "" {
+		tsig.KeyName = dns.Fqdn(tsig.KeyName)
+		if tsig.Algorithm == "" {
+			tsig.Algorithm = dns.HmacSHA256
+		}
+		tsig.Algorithm = dns.Fqdn(tsig.Algorithm)
+		client.TsigSecret = map[string]string{tsig.KeyName: tsig.Secret}
+	}
+
+	return &DynamicDNS{
+		client:      client,
+		server:      server,
+		zone:        dns.Fqdn(zone),
+		reverseZone: reverseZone,
+		tsig:        tsig,
+	}
+}
+
+// String describes the client without the TSIG secret
+func (c *DynamicDNS) String() string {
+	return fmt.Sprintf("%s server %s zone %s reverse zone %q TSIG key %q", providerName, c.server, c.zone, c.reverseZone, c.tsig.KeyName)
+}
+
+func (c *DynamicDNS) exchange(msg *dns.Msg) (*dns.Msg, error) {
+	if c.tsig.KeyName != "" {
+		msg.SetTsig(c.tsig.KeyName, c.tsig.Algorithm, tsigFudge, time.Now().Unix())
+	}
+
+	resp, _, err := c.client.Exchange(msg, c.server)
+	if err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+func (c *DynamicDNS) applyChange(msg *dns.Msg) (err error) {
+	zone := msg.Question[0].Name
+	defer func(start time.Time) {
+		metrics.ObserveCall(providerName, "update", zone, start, err)
+	}(time.Now())
+
+	resp, err := c.exchange(msg)
+	if err != nil {
+		return err
+	}
+
+	if resp.Rcode != dns.RcodeSuccess {
+		return fmt.Errorf("Update of %s zone failed: %s", zone, dns.RcodeToString[resp.Rcode])
+	}
+	return nil
+}
+
+// lookup returns the records with given name and type from the nameserver
+func (c *DynamicDNS) lookup(name string, recType uint16) (records []dns.RR, err error) {
+	msg := new(dns.Msg)
+	msg.SetQuestion(name, recType)
+	msg.RecursionDesired = false
+
+	defer func(start time.Time) {
+		metrics.ObserveCall(providerName, "lookup", c.zone, start, err)
+	}(time.Now())
+
+	resp, err := c.exchange(msg)
+	if err != nil {
+		return nil, err
+	}
+
+	// Name that doesn't exist has no records
+	if resp.Rcode != dns.RcodeSuccess && resp.Rcode != dns.RcodeNameError {
+		return nil, fmt.Errorf("Lookup of %s failed: %s", name, dns.RcodeToString[resp.Rcode])
+	}
+
+	records = []dns.RR{}
+	for _, rr := range resp.Answer {
+		if rr.Header().Rrtype == recType {
+			records = append(records, rr)
+		}
+	}
+	return records, nil
+}
+
+// CheckHealth queries the SOA record of the zone from the nameserver
+func (c *DynamicDNS) CheckHealth() error {
+	msg := new(dns.Msg)
+	msg.SetQuestion(c.zone, dns.TypeSOA)
+	msg.RecursionDesired = false
+
+	start := time.Now()
+	resp, err := c.exchange(msg)
+	if err == nil && resp.Rcode != dns.RcodeSuccess {
+		err = fmt.Errorf("SOA query of %s zone failed: %s", c.zone, dns.RcodeToString[resp.Rcode])
+	}
+	metrics.ObserveCall(providerName, "get-zone", c.zone, start, err)
+	return err
+}
+
+// NewRequest creates a new dynamic update request
+func (c *DynamicDNS) NewRequest() pdns.DNSRequest {
+	req := &DNSRequest{
+		client: c,
+		change: newUpdate(c.zone),
+	}
+
+	if c.reverseZone != "" {
+		req.revChange = newUpdate(dns.Fqdn(c.reverseZone))
+	}
+	return req
+}
+
+// update is the UPDATE message of a zone with the RRsets its changes depend on.
+// RRsets are read from the server when they are first changed and sent as prerequisites:
+// "RRset exists (value dependent)" with the read records or "RRset does not exist".
+// Server rejects the whole message with NXRRSET or YXRRSET when any of them has been changed meanwhile.
+type update struct {
+	msg *dns.Msg
+	// RRsets with the changes of the message applied
+	rrsets map[string][]dns.RR
+}
+
+func newUpdate(zone string) *update {
+	msg := new(dns.Msg)
+	msg.SetUpdate(zone)
+	return &update{msg: msg, rrsets: make(map[string][]dns.RR)}
+}
+
+func rrsetKey(name string, recType uint16) string {
+	return fmt.Sprintf("%s|%d", strings.ToLower(name), recType)
+}
+
+// DNSRequest holds one UPDATE message for the forward zone and one for the reverse zone.
+// Checks that CloudDNS does by reading the zone are done on the RRsets read from the server.
+// The read RRsets are sent as prerequisites so the server applies all the changes
+// in a message or none of them if the zone has changed since.
+type DNSRequest struct {
+	client    *DynamicDNS
+	ttl       pdns.TTL
+	change    *update
+	revChange *update
+	err       error
+}
+
+// SetTTL sets the TTL of the records added in the request
+func (d *DNSRequest) SetTTL(ttl pdns.TTL) {
+	d.ttl = ttl
+}
+
+// Do sends the update messages with all the attached changes
+// No error would be returned when no changes have been added
+func (d *DNSRequest) Do() (err error) {
+	defer metrics.ObserveRequest(providerName, time.Now(), &err)
+
+	if d.err != nil {
+		return d.err
+	}
+
+	if len(d.change.msg.Ns) > 0 {
+		if err = d.client.applyChange(d.change.msg); err != nil {
+			return err
+		}
+	}
+
+	if d.revChange != nil && len(d.revChange.msg.Ns) > 0 {
+		if err = d.client.applyChange(d.revChange.msg); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Changes returns the updates of the forward and reverse zone messages
+func (d *DNSRequest) Changes() []string {
+	changes := d.change.describe()
+	if d.revChange != nil {
+		changes = append(changes, d.revChange.describe()...)
+	}
+	return changes
+}
+
+// describe returns the updates of the message as RFC 2136 defines them by the class
+func (u *update) describe() []string {
+	zone := u.msg.Question[0].Name
+	changes := []string{}
+	for _, rr := range u.msg.Ns {
+		hdr := rr.Header()
+		switch hdr.Class {
+		case dns.ClassANY:
+			changes = append(changes, fmt.Sprintf("delete %s %s in %s", hdr.Name, dns.TypeToString[hdr.Rrtype], zone))
+		case dns.ClassNONE:
+			changes = append(changes, fmt.Sprintf("delete %s in %s", rrData(rr), zone))
+		default:
+			changes = append(changes, fmt.Sprintf("add %s in %s", rrData(rr), zone))
+		}
+	}
+	return changes
+}
+
+// rrData returns the name, TTL, type and data of the record on a single line without the class
+func rrData(rr dns.RR) string {
+	hdr := rr.Header()
+	data := strings.TrimPrefix(rr.String(), hdr.String())
+	return fmt.Sprintf("%s %d %s %s", hdr.Name, hdr.Ttl, dns.TypeToString[hdr.Rrtype], strings.TrimSpace(data))
+}
+
+// rrset returns the records of the RRset with the changes of the message.
+// RRset is read from the server and added to the prerequisites when it is first used.
+func (d *DNSRequest) rrset(u *update, name string, recType uint16) []dns.RR {
+	key := rrsetKey(name, recType)
+	if records, ok := u.rrsets[key]; ok {
+		return records
+	}
+
+	records, err := d.client.lookup(name, recType)
+	if err != nil {
+		klog.Errorln(err)
+		if d.err == nil {
+			d.err = err
+		}
+		return nil
+	}
+
+	if len(records) == 0 {
+		u.msg.RRsetNotUsed([]dns.RR{&dns.ANY{Hdr: header(name, recType, 0)}})
+	} else {
+		prerequisites := make([]dns.RR, len(records))
+		for i, rr := range records {
+			prerequisites[i] = dns.Copy(rr)
+			prerequisites[i].Header().Ttl = 0
+		}
+		u.msg.Used(prerequisites)
+	}
+
+	u.rrsets[key] = records
+	return records
+}
+
+// insert adds the record to the RRset
+func (u *update) insert(rr dns.RR) {
+	u.msg.Insert([]dns.RR{rr})
+	key := rrsetKey(rr.Header().Name, rr.Header().Rrtype)
+	u.rrsets[key] = append(u.rrsets[key], rr)
+}
+
+// remove deletes the record from the RRset
+func (u *update) remove(rr dns.RR) {
+	u.msg.Remove([]dns.RR{dns.Copy(rr)})
+	key := rrsetKey(rr.Header().Name, rr.Header().Rrtype)
+	kept := []dns.RR{}
+	for _, old := range u.rrsets[key] {
+		if !dns.IsDuplicate(old, rr) {
+			kept = append(kept, old)
+		}
+	}
+	u.rrsets[key] = kept
+}
+
+// replace makes the record the only one in the RRset
+func (d *DNSRequest) replace(u *update, rec dns.RR) {
+	existing := d.rrset(u, rec.Header().Name, rec.Header().Rrtype)
+	if len(existing) == 1 && dns.IsDuplicate(existing[0], rec) {
+		klog.V(2).Infof("Record exists: %s\n", existing[0])
+		return
+	}
+
+	for _, rr := range existing {
+		klog.V(2).Infof("Stale record found: %s\n", rr)
+		u.remove(rr)
+	}
+	u.insert(rec)
+}
+
+// has checks if the RRset has the record
+func (d *DNSRequest) has(u *update, rec dns.RR) bool {
+	for _, rr := range d.rrset(u, rec.Header().Name, rec.Header().Rrtype) {
+		if dns.IsDuplicate(rr, rec) {
+			return true
+		}
+	}
+	return false
+}
+
+// AddRecord adds A or AAAA record with single IP.
+// Any stale record of the same type with the same name is replaced.
+func (d *DNSRequest) AddRecord(domain, ip string) {
+	rec := newAddress(domain, ip, d.ttl.For(pdns.KindPod))
+	if rec == nil {
+		return
+	}
+	d.replace(d.change, rec)
+
+	if d.revChange != nil {
+		d.AddReverseRecord(domain, ip)
+	}
+}
+
+// RemoveRecord deletes A or AAAA record with a single IP.
+// If records and pods have somehow got into inconsistent state
+// the record is left alone when it has other IP.
+func (d *DNSRequest) RemoveRecord(domain, ip string) {
+	rec := newAddress(domain, ip, d.ttl.For(pdns.KindPod))
+	if rec == nil {
+		return
+	}
+
+	if d.has(d.change, rec) {
+		d.change.remove(rec)
+	} else {
+		klog.V(2).Infof("%s doesn't have %s\n", domain, ip)
+	}
+
+	if d.revChange != nil {
+		d.RemoveReverseRecord(domain, ip)
+	}
+}
+
+// AddReverseRecord adds a PTR record for the reverse lookup
+func (d *DNSRequest) AddReverseRecord(domain, ip string) {
+	if d.revChange == nil {
+		klog.Errorf("No reverse zone configured for %s\n", domain)
+		return
+	}
+
+	if rec := d.newPTR(domain, ip); rec != nil {
+		d.replace(d.revChange, rec)
+	}
+}
+
+// RemoveReverseRecord removes a PTR record from the reverse lookup zone
+func (d *DNSRequest) RemoveReverseRecord(domain, ip string) {
+	if d.revChange == nil {
+		klog.Errorf("No reverse zone configured for %s\n", domain)
+		return
+	}
+
+	// Only the PTR record pointing to the same domain is deleted
+	if rec := d.newPTR(domain, ip); rec != nil && d.has(d.revChange, rec) {
+		d.revChange.remove(rec)
+	}
+}
+
+// AddToService adds the given IP to A or AAAA record with multiple IPs
+func (d *DNSRequest) AddToService(domain, ip string) {
+	rec := newAddress(domain, ip, d.ttl.For(pdns.KindService))
+	if rec == nil {
+		return
+	}
+
+	if d.has(d.change, rec) {
+		klog.V(2).Infof("%s already has %s\n", domain, ip)
+		return
+	}
+	d.change.insert(rec)
+}
+
+// RemoveFromService removes given IP from an A or AAAA record with multiple IPs
+func (d *DNSRequest) RemoveFromService(domain, ip string) {
+	rec := newAddress(domain, ip, d.ttl.For(pdns.KindService))
+	if rec == nil {
+		return
+	}
+
+	if !d.has(d.change, rec) {
+		klog.V(2).Infof("%s doesn't have %s\n", domain, ip)
+		return
+	}
+	d.change.remove(rec)
+}
+
+// AddToSRV adds target to SRV record.
+// Target with other priority, weight or port gets replaced.
+func (d *DNSRequest) AddToSRV(srv string, data pdns.SRV) {
+	name := dns.Fqdn(srv)
+	rec := &dns.SRV{
+		Hdr:      header(name, dns.TypeSRV, d.ttl.For(pdns.KindSRV)),
+		Priority: uint16(data.Priority),
+		Weight:   uint16(data.Weight),
+		Port:     uint16(data.Port),
+		Target:   dns.Fqdn(data.Target),
+	}
+
+	for _, rr := range d.rrset(d.change, name, dns.TypeSRV) {
+		if !strings.EqualFold(rr.(*dns.SRV).Target, rec.Target) {
+			continue
+		}
+		if dns.IsDuplicate(rr, rec) {
+			klog.V(2).Infof("Record exists: %s\n", rr)
+			return
+		}
+		d.change.remove(rr)
+	}
+
+	d.change.insert(rec)
+}
+
+// RemoveFromSRV removes domain from SRV record
+func (d *DNSRequest) RemoveFromSRV(srv, domain string) {
+	name := dns.Fqdn(srv)
+	target := dns.Fqdn(domain)
+
+	found := false
+	for _, rr := range d.rrset(d.change, name, dns.TypeSRV) {
+		if strings.EqualFold(rr.(*dns.SRV).Target, target) {
+			d.change.remove(rr)
+			found = true
+		}
+	}
+
+	if !found {
+		klog.V(2).Infof("%s doesn't include %s\n", srv, domain)
+	}
+}
+
+// UTILS
+func header(name string, recType uint16, ttl int64) dns.RR_Header {
+	return dns.RR_Header{
+		Name:   name,
+		Rrtype: recType,
+		Class:  dns.ClassINET,
+		Ttl:    uint32(ttl),
+	}
+}
+
+// newAddress returns A record for IPv4 and AAAA record for IPv6 address
+func newAddress(domain, ip string, ttl int64) dns.RR {
+	addr := net.ParseIP(ip)
+	switch {
+	case addr == nil:
+		klog.Errorf("Invalid IP address for %s: %s\n", domain, ip)
+		return nil
+	case addr.To4() != nil:
+		return &dns.A{
+			Hdr: header(dns.Fqdn(domain), dns.TypeA, ttl),
+			A:   addr.To4(),
+		}
+	default:
+		return &dns.AAAA{
+			Hdr:  header(dns.Fqdn(domain), dns.TypeAAAA, ttl),
+			AAAA: addr,
+		}
+	}
+}
+
+// newPTR returns the PTR record when the reverse name of the IP is in the reverse zone
+func (d *DNSRequest) newPTR(domain, ip string) *dns.PTR {
+	name, ok := pdns.ReverseName(ip)
+	if !ok {
+		klog.Errorf("Invalid IP address for %s: %s\n", domain, ip)
+		return nil
+	}
+	if !pdns.InReverseZone(ip, d.client.reverseZone) {
+		klog.V(2).Infof("%s is not in %s reverse zone. Not writing PTR record.\n", name, d.client.reverseZone)
+		return nil
+	}
+
+	return &dns.PTR{
+		Hdr: header(dns.Fqdn(name), dns.TypePTR, d.ttl.For(pdns.KindPTR)),
+		Ptr: dns.Fqdn(domain),
+	}
+}
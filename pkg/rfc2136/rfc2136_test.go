@@ -0,0 +1,279 @@
+package rfc2136
+
+import (
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/tanelmae/private-dns/internal/pdns"
+	"github.com/tanelmae/private-dns/internal/pdns/pdnstest"
+)
+
+const (
+	testZone    = "example.com."
+	testRevZone = "in-addr.arpa."
+	testKey     = "pdns-key."
+	testSecret  = "c2VjcmV0c2VjcmV0c2VjcmV0c2VjcmV0"
+)
+
+// fakeNameserver is a minimal in-process authoritative server
+// that applies RFC 2136 updates signed with the test key
+type fakeNameserver struct {
+	mu      sync.Mutex
+	records map[string][]dns.RR
+	updates int
+}
+
+func rdata(rr dns.RR) string {
+	return strings.TrimPrefix(rr.String(), rr.Header().String())
+}
+
+func (f *fakeNameserver) ServeDNS(w dns.ResponseWriter, r *dns.Msg) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	m := new(dns.Msg)
+	m.SetReply(r)
+
+	if r.Opcode == dns.OpcodeUpdate {
+		switch {
+		case r.IsTsig() == nil:
+			m.Rcode = dns.RcodeRefused
+		case w.TsigStatus() != nil:
+			m.Rcode = dns.RcodeNotAuth
+		default:
+			m.Rcode = f.update(r)
+		}
+	} else {
+		m.Answer = f.records[rrsetKey(r.Question[0].Name, r.Question[0].Qtype)]
+	}
+
+	if t := r.IsTsig(); t != nil {
+		m.SetTsig(t.Hdr.Name, t.Algorithm, tsigFudge, time.Now().Unix())
+	}
+	w.WriteMsg(m)
+}
+
+func (f *fakeNameserver) update(r *dns.Msg) int {
+	// Value dependent prerequisites need exactly matching RRsets
+	wanted := map[string][]string{}
+	for _, rr := range r.Answer {
+		h := rr.Header()
+		key := rrsetKey(h.Name, h.Rrtype)
+		switch h.Class {
+		case dns.ClassNONE:
+			if len(f.records[key]) > 0 {
+				return dns.RcodeYXRrset
+			}
+		case dns.ClassANY:
+			if len(f.records[key]) == 0 {
+				return dns.RcodeNXRrset
+			}
+		default:
+			wanted[key] = append(wanted[key], rdata(rr))
+		}
+	}
+	for key, values := range wanted {
+		existing := []string{}
+		for _, rr := range f.records[key] {
+			existing = append(existing, rdata(rr))
+		}
+		sort.Strings(values)
+		sort.Strings(existing)
+		if fmt.Sprint(values) != fmt.Sprint(existing) {
+			return dns.RcodeNXRrset
+		}
+	}
+
+	f.updates++
+	for _, rr := range r.Ns {
+		h := rr.Header()
+		key := rrsetKey(h.Name, h.Rrtype)
+		switch h.Class {
+		case dns.ClassANY:
+			delete(f.records, key)
+		case dns.ClassNONE:
+			kept := []dns.RR{}
+			for _, old := range f.records[key] {
+				if rdata(old) != rdata(rr) {
+					kept = append(kept, old)
+				}
+			}
+			f.records[key] = kept
+		default:
+			exists := false
+			for _, old := range f.records[key] {
+				exists = exists || rdata(old) == rdata(rr)
+			}
+			if !exists {
+				f.records[key] = append(f.records[key], dns.Copy(rr))
+			}
+		}
+		if len(f.records[key]) == 0 {
+			delete(f.records, key)
+		}
+	}
+	return dns.RcodeSuccess
+}
+
+func (f *fakeNameserver) values(name string, recType uint16) []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	values := []string{}
+	for _, rr := range f.records[rrsetKey(name, recType)] {
+		values = append(values, strings.TrimSpace(rdata(rr)))
+	}
+	sort.Strings(values)
+	return values
+}
+
+func newTestClient(t *testing.T, secret string) (*DynamicDNS, *fakeNameserver) {
+	fake := &fakeNameserver{records: map[string][]dns.RR{}}
+
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	started := make(chan struct{})
+	srv := &dns.Server{
+		PacketConn:        pc,
+		Handler:           fake,
+		TsigSecret:        map[string]string{testKey: testSecret},
+		MsgAcceptFunc:     func(dh dns.Header) dns.MsgAcceptAction { return dns.MsgAccept },
+		NotifyStartedFunc: func() { close(started) },
+	}
+	go srv.ActivateAndServe()
+	<-started
+	t.Cleanup(func() { srv.Shutdown() })
+
+	client := New(pc.LocalAddr().String(), "udp", testZone, testRevZone, TSIG{
+		KeyName: testKey,
+		Secret:  secret,
+	})
+	return client, fake
+}
+
+func TestProvider(t *testing.T) {
+	pdnstest.Run(t, func(t *testing.T) pdnstest.Fake {
+		client, fake := newTestClient(t, testSecret)
+		return pdnstest.Fake{
+			Provider: client,
+			Values: func(name, recType string) []string {
+				return fake.values(name, dns.StringToType[recType])
+			},
+			Writes: func() int { return fake.updates },
+		}
+	})
+}
+
+func TestAAAARecord(t *testing.T) {
+	client, fake := newTestClient(t, testSecret)
+
+	req := client.NewRequest()
+	req.AddRecord("pod-0.app.example.com", "10.0.0.1")
+	req.AddRecord("pod-0.app.example.com", "fd00::1")
+	req.AddToService("app.example.com", "fd00::1")
+	pdnstest.Do(t, req)
+
+	// IPv6 record doesn't replace the IPv4 one and has no reverse zone
+	pdnstest.AssertValues(t, fake.values("pod-0.app.example.com.", dns.TypeA), "10.0.0.1")
+	pdnstest.AssertValues(t, fake.values("pod-0.app.example.com.", dns.TypeAAAA), "fd00::1")
+	pdnstest.AssertValues(t, fake.values("app.example.com.", dns.TypeAAAA), "fd00::1")
+	pdnstest.AssertValues(t, fake.values("1.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.d.f.ip6.arpa.", dns.TypePTR))
+
+	req = client.NewRequest()
+	req.RemoveRecord("pod-0.app.example.com", "fd00::1")
+	pdnstest.Do(t, req)
+	pdnstest.AssertValues(t, fake.values("pod-0.app.example.com.", dns.TypeAAAA))
+	pdnstest.AssertValues(t, fake.values("pod-0.app.example.com.", dns.TypeA), "10.0.0.1")
+}
+
+// set changes the zone behind the back of the client
+func (f *fakeNameserver) set(rr string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	rec, err := dns.NewRR(rr)
+	if err != nil {
+		panic(err)
+	}
+	key := rrsetKey(rec.Header().Name, rec.Header().Rrtype)
+	f.records[key] = []dns.RR{rec}
+}
+
+func TestPrerequisites(t *testing.T) {
+	client, fake := newTestClient(t, testSecret)
+
+	req := client.NewRequest()
+	req.AddRecord("pod-0.app.example.com", "10.0.0.1")
+	pdnstest.Do(t, req)
+
+	// Record changed after it was read fails the value dependent prerequisite
+	req = client.NewRequest()
+	req.RemoveRecord("pod-0.app.example.com", "10.0.0.1")
+	req.AddToService("app.example.com", "10.0.0.5")
+	fake.set("pod-0.app.example.com. 60 IN A 10.0.0.2")
+	err := req.Do()
+	if err == nil || !strings.Contains(err.Error(), "NXRRSET") {
+		t.Fatalf("expected NXRRSET, got %v", err)
+	}
+	pdnstest.AssertValues(t, fake.values("pod-0.app.example.com.", dns.TypeA), "10.0.0.2")
+	pdnstest.AssertValues(t, fake.values("app.example.com.", dns.TypeA))
+
+	// Record created after the RRset was read empty fails the RRset does not exist prerequisite
+	req = client.NewRequest()
+	req.AddToSRV("_http._tcp.example.com", pdns.SRV{Port: 80, Target: "pod-0.app.example.com"})
+	fake.set("_http._tcp.example.com. 60 IN SRV 0 0 80 other.example.com.")
+	err = req.Do()
+	if err == nil || !strings.Contains(err.Error(), "YXRRSET") {
+		t.Fatalf("expected YXRRSET, got %v", err)
+	}
+	pdnstest.AssertValues(t, fake.values("_http._tcp.example.com.", dns.TypeSRV), "0 0 80 other.example.com.")
+
+	// Retried request reads the current RRsets
+	req = client.NewRequest()
+	req.AddToSRV("_http._tcp.example.com", pdns.SRV{Port: 80, Target: "pod-0.app.example.com"})
+	pdnstest.Do(t, req)
+	pdnstest.AssertValues(t, fake.values("_http._tcp.example.com.", dns.TypeSRV),
+		"0 0 80 other.example.com.", "0 0 80 pod-0.app.example.com.")
+}
+
+func TestSingleMessage(t *testing.T) {
+	client, fake := newTestClient(t, testSecret)
+
+	req := client.NewRequest()
+	req.AddRecord("pod-0.app.example.com", "10.0.0.1")
+	req.AddToService("app.example.com", "10.0.0.1")
+	req.AddToSRV("_http._tcp.example.com", pdns.SRV{Priority: 1, Target: "pod-0.app.example.com"})
+	pdnstest.Do(t, req)
+
+	// One message for the forward and one for the reverse zone
+	if fake.updates != 2 {
+		t.Fatalf("expected 2 update messages, got %d", fake.updates)
+	}
+}
+
+func TestTSIG(t *testing.T) {
+	client, fake := newTestClient(t, "d3JvbmdzZWNyZXR3cm9uZ3NlY3JldA==")
+
+	req := client.NewRequest()
+	req.AddRecord("pod-0.app.example.com", "10.0.0.1")
+	if err := req.Do(); err == nil {
+		t.Fatal("expected update with wrong key to fail")
+	}
+	pdnstest.AssertValues(t, fake.values("pod-0.app.example.com.", dns.TypeA))
+}
+
+func TestStringHidesSecret(t *testing.T) {
+	client, _ := newTestClient(t, testSecret)
+	if s := fmt.Sprint(client); strings.Contains(s, testSecret) || !strings.Contains(s, testZone) {
+		t.Fatalf("unexpected description %s", s)
+	}
+}